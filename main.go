@@ -71,7 +71,15 @@ func main() {
 		log.Fatal(err)
 	}
 
-	repo := patcher.NewRepo(runner, releaseRepository, "bot", "witchcraft@example.com")
+	repo, err := patcher.NewRepoE(runner, releaseRepository, "bot", "witchcraft@example.com")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if !quiet {
+		repo = repo.WithStdout(os.Stdout)
+	}
+
 	apply := patcher.NewApply(repo)
 
 	initialCheckpoint, err := versionsParser.GetCheckpoint()
@@ -0,0 +1,58 @@
+package patcher_test
+
+import (
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ApplyPatch in a checkout without a git identity", func() {
+	var (
+		repo       gitRepo
+		patchPath  string
+		savedEnv   map[string]string
+		isolateEnv = []string{"HOME", "GIT_CONFIG_GLOBAL", "GIT_CONFIG_NOSYSTEM", "GIT_COMMITTER_NAME", "GIT_COMMITTER_EMAIL", "EMAIL"}
+	)
+
+	BeforeEach(func() {
+		savedEnv = map[string]string{}
+		for _, key := range isolateEnv {
+			if value, ok := os.LookupEnv(key); ok {
+				savedEnv[key] = value
+			}
+			os.Unsetenv(key)
+		}
+		os.Setenv("HOME", os.TempDir())
+		os.Setenv("GIT_CONFIG_GLOBAL", os.DevNull)
+		os.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+
+		repo = newGitRepo()
+		repo.git("config", "user.useConfigOnly", "true")
+
+		repo.writeFile("file", "one\n")
+		repo.commit("Initial")
+		repo.writeFile("file", "two\n")
+		repo.commit("Change")
+
+		patchPath = repo.formatPatch("-1")
+		repo.git("reset", "-q", "--hard", "HEAD~1")
+	})
+
+	AfterEach(func() {
+		repo.remove()
+
+		for _, key := range isolateEnv {
+			os.Unsetenv(key)
+			if value, ok := savedEnv[key]; ok {
+				os.Setenv(key, value)
+			}
+		}
+	})
+
+	It("commits as the configured committer while keeping the patch author", func() {
+		Expect(repo.repo().ApplyPatch(patchPath)).To(Succeed())
+
+		Expect(repo.git("log", "-1", "--format=%an <%ae>|%cn <%ce>")).To(Equal("Some Author <author@example.com>|testbot <foo@example.com>\n"))
+	})
+})
@@ -14,6 +14,8 @@ type repository interface {
 	RemoveSubmodule(path string) error
 	BumpSubmodule(path, sha string) error
 	PatchSubmodule(path string, patch string) error
+	PresentSubmodules() ([]string, error)
+	VerifySubmoduleCounts(before []string, changes []Changeset) error
 }
 
 func NewApply(repo repository) Apply {
@@ -33,6 +35,11 @@ func (a Apply) Checkpoint(checkpoint Checkpoint) error {
 		return err
 	}
 
+	submodulesBefore, err := a.repo.PresentSubmodules()
+	if err != nil {
+		return err
+	}
+
 	for _, change := range checkpoint.Changes {
 		for _, patch := range change.Patches {
 			err := a.repo.ApplyPatch(patch)
@@ -77,7 +84,7 @@ func (a Apply) Checkpoint(checkpoint Checkpoint) error {
 		}
 	}
 
-	return nil
+	return a.repo.VerifySubmoduleCounts(submodulesBefore, checkpoint.Changes)
 }
 
 func sortSubmodules(submodules map[string]string) []string {
@@ -0,0 +1,148 @@
+package patcher
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type HunkState string
+
+const (
+	HunkClean  HunkState = "clean"
+	HunkOffset HunkState = "offset"
+	HunkFuzz   HunkState = "fuzz"
+	HunkFailed HunkState = "failed"
+)
+
+// HunkStatus describes how one hunk of a patch landed. Line is where the hunk
+// applied, or where it was expected when it failed.
+type HunkStatus struct {
+	File   string
+	Hunk   int
+	Line   int
+	Offset int
+	Fuzz   int
+	State  HunkState
+}
+
+type ApplyReport struct {
+	Hunks []HunkStatus
+}
+
+// ApplyPatchVerbose dry-runs the patch with git apply --verbose to record the
+// outcome of every hunk, then applies it like ApplyPatch if all of them fit.
+// The report is returned even when the patch does not apply.
+func (r Repo) ApplyPatchVerbose(patch string) (ApplyReport, error) {
+	return r.ApplyPatchVerboseWithOptions(patch, ApplyPatchOptions{})
+}
+
+// ApplyPatchVerboseWithOptions is ApplyPatchVerbose applying the patch like
+// ApplyPatchWithOptions. Hunks are only reported as HunkFuzz when
+// options.Fuzz allows them to apply with reduced context.
+func (r Repo) ApplyPatchVerboseWithOptions(patch string, options ApplyPatchOptions) (ApplyReport, error) {
+	var result ApplyReport
+	err := r.withPatchHooks("ApplyPatchVerbose", patch, func() error {
+		var err error
+		result, err = r.applyPatchVerbose(patch, options)
+		return err
+	})
+
+	return result, err
+}
+
+func (r Repo) applyPatchVerbose(patch string, options ApplyPatchOptions) (ApplyReport, error) {
+	if err := r.requireLocal("ApplyPatchVerbose"); err != nil {
+		return ApplyReport{}, err
+	}
+
+	if err := checkFuzz(options.Fuzz); err != nil {
+		return ApplyReport{}, err
+	}
+
+	contents, err := ioutil.ReadFile(patch)
+	if err != nil {
+		return ApplyReport{}, err
+	}
+
+	output, checkErr := r.combinedOutput(Command{
+		Args: append(append([]string{"apply", "--check", "--verbose"}, contextArgs(options.Fuzz)...), patch),
+		Dir:  r.repo,
+	})
+
+	report := ApplyReport{Hunks: parseHunkStatuses(string(contents), output)}
+	if checkErr != nil {
+		return report, fmt.Errorf("Patch %q does not apply: %s\n%s", patch, checkErr, strings.TrimSpace(string(output)))
+	}
+
+	_, err = r.applyPatch(patch, patch, options)
+	return report, err
+}
+
+func parseHunkStatuses(contents string, output []byte) []HunkStatus {
+	header := regexp.MustCompile(`^diff --git a/.* b/(.*)$`)
+	hunk := regexp.MustCompile(`^@@ -(\d+)(?:,\d+)? \+(\d+)(?:,\d+)? @@`)
+
+	var (
+		statuses  []HunkStatus
+		oldStarts []int
+		file      string
+		index     int
+	)
+
+	for _, line := range strings.Split(contents, "\n") {
+		if matches := header.FindStringSubmatch(line); matches != nil {
+			file = matches[1]
+			index = 0
+			continue
+		}
+
+		matches := hunk.FindStringSubmatch(line)
+		if matches == nil {
+			continue
+		}
+
+		index++
+		status := HunkStatus{File: file, Hunk: index, State: HunkClean}
+		status.Line, _ = strconv.Atoi(matches[2])
+		statuses = append(statuses, status)
+
+		oldStart, _ := strconv.Atoi(matches[1])
+		oldStarts = append(oldStarts, oldStart)
+	}
+
+	for _, warning := range parseFuzzWarnings(contents, output) {
+		for i := range statuses {
+			if statuses[i].File != warning.File || statuses[i].Hunk != warning.Hunk {
+				continue
+			}
+
+			statuses[i].Line = warning.Line
+			statuses[i].Offset = warning.Offset
+			statuses[i].Fuzz = warning.Fuzz
+			statuses[i].State = HunkOffset
+			if warning.Fuzz > 0 {
+				statuses[i].State = HunkFuzz
+			}
+		}
+	}
+
+	failure := regexp.MustCompile(`^error: patch failed: (.*):(\d+)$`)
+	for _, line := range splitLines(output) {
+		matches := failure.FindStringSubmatch(line)
+		if matches == nil {
+			continue
+		}
+
+		oldLine, _ := strconv.Atoi(matches[2])
+		for i := range statuses {
+			if statuses[i].File == matches[1] && oldStarts[i] == oldLine {
+				statuses[i].State = HunkFailed
+			}
+		}
+	}
+
+	return statuses
+}
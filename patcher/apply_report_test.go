@@ -0,0 +1,154 @@
+package patcher_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pivotal-cf/knit/patcher"
+	"github.com/pivotal-cf/knit/patcher/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const reportedPatch = `Subject: [PATCH] Tune settings
+
+---
+diff --git a/config/app.yml b/config/app.yml
+--- a/config/app.yml
++++ b/config/app.yml
+@@ -10,3 +10,3 @@ settings:
+ name: app
+-timeout: 30
++timeout: 60
+ port: 8080
+@@ -40,3 +40,4 @@ limits:
+ a: 1
+ b: 2
++retries: 3
+ c: 3
+diff --git a/lib/client.go b/lib/client.go
+--- a/lib/client.go
++++ b/lib/client.go
+@@ -5,2 +5,2 @@
+-const a = 1
++const a = 2
+ const b = 1
+`
+
+var _ = Describe("ApplyPatchVerbose", func() {
+	var (
+		runner  *fakes.CommandRunner
+		tempDir string
+		patch   string
+		r       patcher.Repo
+	)
+
+	BeforeEach(func() {
+		var err error
+		tempDir, err = ioutil.TempDir("", "")
+		Expect(err).NotTo(HaveOccurred())
+
+		patch = filepath.Join(tempDir, "tune.patch")
+		Expect(ioutil.WriteFile(patch, []byte(reportedPatch), 0644)).To(Succeed())
+
+		runner = &fakes.CommandRunner{}
+		r = patcher.NewRepo(runner, "/some/repo", "testbot", "foo@example.com")
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(tempDir)).To(Succeed())
+	})
+
+	It("reports how each hunk landed and applies the patch", func() {
+		runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte(`Checking patch config/app.yml...
+Hunk #2 succeeded at 43 (offset 3 lines).
+Context reduced to (1/0) to apply fragment at 43
+Checking patch lib/client.go...
+Hunk #1 succeeded at 7 (offset 2 lines).
+`)}
+		runner.CombinedOutputCall.Returns.Errors = []error{nil}
+
+		report, err := r.ApplyPatchVerbose(patch)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(report).To(Equal(patcher.ApplyReport{Hunks: []patcher.HunkStatus{
+			{File: "config/app.yml", Hunk: 1, Line: 10, State: patcher.HunkClean},
+			{File: "config/app.yml", Hunk: 2, Line: 43, Offset: 2, Fuzz: 1, State: patcher.HunkFuzz},
+			{File: "lib/client.go", Hunk: 1, Line: 7, Offset: 2, State: patcher.HunkOffset},
+		}}))
+
+		Expect(runner.CombinedOutputCall.Receives.Commands[0].Args).To(Equal([]string{"apply", "--check", "--verbose", patch}))
+		Expect(runner.RunCall.Receives.Commands).To(Equal([]patcher.Command{
+			patcher.Command{
+				Args: []string{"-c", "user.name=testbot", "-c", "user.email=foo@example.com", "am", patch},
+				Dir:  "/some/repo",
+			},
+		}))
+	})
+
+	Context("when a hunk does not apply", func() {
+		It("reports the failed hunk without applying the patch", func() {
+			runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte(`Checking patch config/app.yml...
+error: while searching for:
+timeout: 30
+error: patch failed: config/app.yml:10
+error: config/app.yml: patch does not apply
+`)}
+			runner.CombinedOutputCall.Returns.Errors = []error{errors.New("exit status 1")}
+
+			report, err := r.ApplyPatchVerbose(patch)
+			Expect(err).To(MatchError(ContainSubstring("does not apply: exit status 1")))
+
+			Expect(report.Hunks[0]).To(Equal(patcher.HunkStatus{File: "config/app.yml", Hunk: 1, Line: 10, State: patcher.HunkFailed}))
+			Expect(report.Hunks[1].State).To(Equal(patcher.HunkClean))
+			Expect(runner.RunCall.Receives.Commands).To(BeEmpty())
+		})
+	})
+})
+
+var _ = Describe("ApplyPatchVerboseWithOptions", func() {
+	var (
+		repo  gitRepo
+		patch string
+		r     patcher.Repo
+	)
+
+	BeforeEach(func() {
+		// The patch's leading context has 3 where the file has three, so its
+		// hunk only fits once that context line is ignored.
+		repo = newGitRepo()
+		repo.writeFile("numbers.txt", "1\n2\nthree\n4\n5\n6\n7\n8\n9\n10\n")
+		repo.commit("Initial")
+
+		r = repo.repo()
+
+		var err error
+		patch, err = filepath.Abs("fixtures/fuzz.patch")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		repo.remove()
+	})
+
+	It("reports a hunk that only applies with fuzz", func() {
+		report, err := r.ApplyPatchVerboseWithOptions(patch, patcher.ApplyPatchOptions{Fuzz: 1})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Hunks).To(Equal([]patcher.HunkStatus{
+			{File: "numbers.txt", Hunk: 1, Line: 4, Fuzz: 1, State: patcher.HunkFuzz},
+		}))
+
+		Expect(repo.readFile("numbers.txt")).To(Equal("1\n2\nthree\n4\n5\nsix\n7\n8\n9\n10\n"))
+	})
+
+	Context("without fuzz", func() {
+		It("reports the hunk as failed", func() {
+			report, err := r.ApplyPatchVerbose(patch)
+			Expect(err).To(HaveOccurred())
+			Expect(report.Hunks[0].State).To(Equal(patcher.HunkFailed))
+		})
+	})
+})
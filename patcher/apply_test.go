@@ -108,6 +108,16 @@ var _ = Describe("Apply", func() {
 			}))
 		})
 
+		It("reconciles the submodules against the declared changes", func() {
+			repo.PresentSubmodulesCall.Returns.Paths = []string{"src/some-old-submodule"}
+
+			err := apply.Checkpoint(checkpoint)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(repo.VerifySubmoduleCountsCall.Receives.Before).To(Equal([]string{"src/some-old-submodule"}))
+			Expect(repo.VerifySubmoduleCountsCall.Receives.Changes).To(Equal(checkpoint.Changes))
+		})
+
 		It("patches individual submodules", func() {
 			err := apply.Checkpoint(checkpoint)
 			Expect(err).NotTo(HaveOccurred())
@@ -200,6 +210,60 @@ var _ = Describe("Apply", func() {
 					Expect(err).To(MatchError("meow"))
 				})
 			})
+
+			Context("when the submodules do not reconcile", func() {
+				It("returns an error", func() {
+					repo.VerifySubmoduleCountsCall.Returns.Error = errors.New("meow")
+
+					err := apply.Checkpoint(checkpoint)
+					Expect(err).To(MatchError("meow"))
+				})
+			})
+		})
+
+		Context("when the repo runs its commands on a remote host", func() {
+			It("applies the checkpoint through the remote runner", func() {
+				runner := remoteRunner{CommandRunner: &fakes.CommandRunner{}}
+				runner.RunCall.Stub = func(command patcher.Command) error {
+					if command.Args[0] == "rev-parse" && command.Args[len(command.Args)-1] == "refs/heads/1.9.2" {
+						return errors.New("no such branch")
+					}
+					return nil
+				}
+				apply = patcher.NewApply(patcher.NewRepo(runner, "/remote/checkout", "testbot", "foo@example.com"))
+
+				err := apply.Checkpoint(patcher.Checkpoint{
+					Changes: []patcher.Changeset{
+						{
+							Patches: []string{"/remote/patch-1"},
+							Bumps: map[string]string{
+								"src/some-path": "some-other-sha",
+							},
+							SubmodulePatches: map[string][]string{
+								"src/sub/path": []string{"/remote/other.patch"},
+							},
+						},
+					},
+					CheckoutRef: "abcde12345",
+					FinalBranch: "1.9.2",
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				var args [][]string
+				for _, command := range append(runner.RunCall.Receives.Commands, runner.CombinedOutputCall.Receives.Commands...) {
+					args = append(args, command.Args)
+				}
+				Expect(args).To(ContainElement(ContainElement("/remote/patch-1")))
+				Expect(args).To(ContainElement(ContainElement("/remote/other.patch")))
+			})
 		})
 	})
 })
+
+type remoteRunner struct {
+	*fakes.CommandRunner
+}
+
+func (remoteRunner) RemoteHost() string {
+	return "build-host"
+}
@@ -0,0 +1,141 @@
+package patcher
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func (r Repo) Archive(ref, outPath string) error {
+	return r.run(Command{
+		Args: []string{"archive", "--format=tar.gz", "-o", outPath, ref},
+		Dir:  r.repo,
+	})
+}
+
+// ArchiveWithSubmodules behaves like Archive but also includes the contents
+// of every submodule, recursively, at the sha recorded for it in ref. The
+// submodules must be initialized so that those shas are available locally.
+func (r Repo) ArchiveWithSubmodules(ref, outPath string) error {
+	if err := r.requireLocal("ArchiveWithSubmodules"); err != nil {
+		return err
+	}
+
+	stagingDir, err := ioutil.TempDir(r.tempDir, "knit-archive")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(stagingDir)
+
+	tarballs, err := r.archiveTree(stagingDir, r.repo, ref, "")
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	gzipWriter := gzip.NewWriter(out)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	for _, tarball := range tarballs {
+		err := appendTarball(tarWriter, tarball)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		return err
+	}
+
+	return gzipWriter.Close()
+}
+
+func (r Repo) archiveTree(stagingDir, dir, ref, prefix string) ([]string, error) {
+	tarballFile, err := ioutil.TempFile(stagingDir, "archive")
+	if err != nil {
+		return nil, err
+	}
+	tarballFile.Close()
+
+	tarball := tarballFile.Name()
+	args := []string{"archive", "--format=tar", "-o", tarball}
+	if prefix != "" {
+		args = append(args, fmt.Sprintf("--prefix=%s", prefix))
+	}
+
+	err = r.run(Command{
+		Args: append(args, ref),
+		Dir:  dir,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := r.combinedOutput(Command{
+		Args: []string{"ls-tree", "-r", ref},
+		Dir:  dir,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tarballs := []string{tarball}
+	for _, line := range splitLines(output) {
+		parts := strings.SplitN(line, "\t", 2)
+		fields := strings.Fields(parts[0])
+		if len(parts) != 2 || len(fields) != 3 || fields[0] != gitlinkMode {
+			continue
+		}
+
+		submoduleTarballs, err := r.archiveTree(stagingDir, filepath.Join(dir, parts[1]), fields[2], prefix+parts[1]+"/")
+		if err != nil {
+			return nil, err
+		}
+
+		tarballs = append(tarballs, submoduleTarballs...)
+	}
+
+	return tarballs, nil
+}
+
+func appendTarball(tarWriter *tar.Writer, tarball string) error {
+	file, err := os.Open(tarball)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	tarReader := tar.NewReader(file)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if header.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if _, err := io.Copy(tarWriter, tarReader); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package patcher_test
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pivotal-cf/knit/patcher"
+	"github.com/pivotal-cf/knit/patcher/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Archive", func() {
+	var (
+		runner   *fakes.CommandRunner
+		repoPath string
+		outPath  string
+		r        patcher.Repo
+	)
+
+	BeforeEach(func() {
+		var err error
+		repoPath, err = ioutil.TempDir("", "")
+		Expect(err).NotTo(HaveOccurred())
+
+		outPath = filepath.Join(repoPath, "release.tgz")
+		runner = &fakes.CommandRunner{}
+		r = patcher.NewRepo(runner, repoPath, "testbot", "foo@example.com")
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(repoPath)).To(Succeed())
+	})
+
+	It("archives the ref as a tarball", func() {
+		err := r.Archive("some-ref", outPath)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(runner.RunCall.Receives.Commands).To(Equal([]patcher.Command{
+			patcher.Command{
+				Args: []string{"archive", "--format=tar.gz", "-o", outPath, "some-ref"},
+				Dir:  repoPath,
+			},
+		}))
+	})
+
+	Describe("ArchiveWithSubmodules", func() {
+		writeTarball := func(path string, files ...string) error {
+			file, err := os.Create(path)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+
+			tarWriter := tar.NewWriter(file)
+			for _, name := range files {
+				err := tarWriter.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(name))})
+				if err != nil {
+					return err
+				}
+
+				if _, err := tarWriter.Write([]byte(name)); err != nil {
+					return err
+				}
+			}
+
+			return tarWriter.Close()
+		}
+
+		BeforeEach(func() {
+			runner.RunCall.Stub = func(command patcher.Command) error {
+				var prefix string
+				for _, arg := range command.Args {
+					if strings.HasPrefix(arg, "--prefix=") {
+						prefix = strings.TrimPrefix(arg, "--prefix=")
+					}
+				}
+
+				return writeTarball(command.Args[3], prefix+"file-at-"+command.Args[len(command.Args)-1])
+			}
+
+			runner.CombinedOutputCall.Stub = func(command patcher.Command) ([]byte, error) {
+				switch command.Dir {
+				case repoPath:
+					return []byte("100644 blob aaaaaaa\tfile.txt\n160000 commit bbbbbbb\tsrc/module-one\n"), nil
+				case filepath.Join(repoPath, "src/module-one"):
+					return []byte("160000 commit ccccccc\tsrc/nested\n"), nil
+				}
+				return []byte{}, nil
+			}
+		})
+
+		It("includes the contents of each submodule at its recorded sha", func() {
+			err := r.ArchiveWithSubmodules("some-ref", outPath)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(runner.RunCall.Receives.Commands).To(HaveLen(3))
+			Expect(runner.RunCall.Receives.Commands[1].Args).To(ContainElement("--prefix=src/module-one/"))
+			Expect(runner.RunCall.Receives.Commands[1].Dir).To(Equal(filepath.Join(repoPath, "src/module-one")))
+			Expect(runner.RunCall.Receives.Commands[2].Args).To(ContainElement("--prefix=src/module-one/src/nested/"))
+
+			file, err := os.Open(outPath)
+			Expect(err).NotTo(HaveOccurred())
+			defer file.Close()
+
+			gzipReader, err := gzip.NewReader(file)
+			Expect(err).NotTo(HaveOccurred())
+
+			var names []string
+			tarReader := tar.NewReader(gzipReader)
+			for {
+				header, err := tarReader.Next()
+				if err == io.EOF {
+					break
+				}
+				Expect(err).NotTo(HaveOccurred())
+				names = append(names, header.Name)
+			}
+
+			Expect(names).To(Equal([]string{
+				"file-at-some-ref",
+				"src/module-one/file-at-bbbbbbb",
+				"src/module-one/src/nested/file-at-ccccccc",
+			}))
+		})
+
+		Context("when archiving a submodule fails", func() {
+			It("returns an error", func() {
+				runner.RunCall.Stub = nil
+				runner.RunCall.Returns.Errors = []error{nil, errors.New("meow")}
+
+				err := r.ArchiveWithSubmodules("some-ref", outPath)
+				Expect(err).To(MatchError("meow"))
+			})
+		})
+	})
+})
@@ -0,0 +1,91 @@
+package patcher
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// StashPopError is returned when changes stashed by ApplyPatchOptions.AutoStash
+// could not be restored. The half-restored working tree is reset and the
+// changes are left in the stash rather than discarded.
+type StashPopError struct {
+	Err error
+}
+
+func (e StashPopError) Error() string {
+	return fmt.Sprintf("Stashed changes could not be restored, so the working tree was reset and they remain in the stash: %s", e.Err)
+}
+
+func (r Repo) withAutoStash(f func() error) error {
+	output, err := r.combinedOutput(Command{
+		Args: []string{"status", "--porcelain"},
+		Dir:  r.repo,
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(splitLines(output)) == 0 {
+		return f()
+	}
+
+	before := r.stashTip()
+
+	err = r.run(Command{
+		Args: r.withIdentity("stash", "push", "-u", "-m", fmt.Sprintf("%s auto-stash", r.messagePrefix)),
+		Dir:  r.repo,
+	})
+	if err != nil {
+		return err
+	}
+
+	// stash push succeeds without stashing anything when the only changes are
+	// in submodules, and popping then would take someone else's stash.
+	if r.stashTip() == before {
+		return f()
+	}
+
+	applyErr := f()
+	if applyErr != nil && !r.deferCommits {
+		r.run(Command{
+			Args: []string{"am", "--abort"},
+			Dir:  r.repo,
+		})
+	}
+
+	err = r.run(Command{
+		Args: []string{"stash", "pop"},
+		Dir:  r.repo,
+	})
+	if err != nil {
+		// Everything the pop half-restored is still in the stash, which git
+		// keeps when the pop conflicts.
+		for _, args := range [][]string{{"reset", "-q", "--hard", "HEAD"}, {"clean", "-fdq"}} {
+			r.run(Command{
+				Args: args,
+				Dir:  r.repo,
+			})
+		}
+
+		return StashPopError{Err: err}
+	}
+
+	return applyErr
+}
+
+// stashTip returns the commit of the newest stash entry, or "" when there is
+// none.
+func (r Repo) stashTip() string {
+	stdout := &bytes.Buffer{}
+	err := r.run(Command{
+		Args:   []string{"rev-parse", "-q", "--verify", "refs/stash"},
+		Dir:    r.repo,
+		Stdout: stdout,
+	})
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(stdout.String())
+}
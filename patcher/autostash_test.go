@@ -0,0 +1,137 @@
+package patcher_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/pivotal-cf/knit/patcher"
+	"github.com/pivotal-cf/knit/patcher/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ApplyPatchWithOptions with AutoStash", func() {
+	var (
+		runner   *fakes.CommandRunner
+		r        patcher.Repo
+		options  patcher.ApplyPatchOptions
+		commands []string
+		stashes  []string
+		failures map[string]error
+	)
+
+	BeforeEach(func() {
+		runner = &fakes.CommandRunner{}
+		runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte(" M some-file\n?? untracked\n")}
+		runner.CombinedOutputCall.Returns.Errors = []error{nil}
+
+		commands = nil
+		stashes = []string{"users-stash"}
+		failures = map[string]error{}
+		runner.RunCall.Stub = func(command patcher.Command) error {
+			args := command.Args
+			if args[0] == "-c" {
+				args = args[4:]
+			}
+			commands = append(commands, strings.Join(args, " "))
+
+			switch {
+			case args[0] == "rev-parse":
+				fmt.Fprintln(command.Stdout, stashes[len(stashes)-1])
+			case args[0] == "stash" && args[1] == "push" && failures["stash push"] == nil:
+				stashes = append(stashes, "knit-stash")
+			}
+
+			return failures[strings.Join(args[:2], " ")]
+		}
+
+		r = patcher.NewRepo(runner, "/some/repo", "testbot", "foo@example.com")
+		options = patcher.ApplyPatchOptions{AutoStash: true}
+	})
+
+	It("stashes the dirty tree around the patch", func() {
+		_, err := r.ApplyPatchWithOptions("some.patch", options)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(runner.CombinedOutputCall.Receives.Commands).To(Equal([]patcher.Command{
+			patcher.Command{
+				Args: []string{"status", "--porcelain"},
+				Dir:  "/some/repo",
+			},
+		}))
+		Expect(commands).To(Equal([]string{
+			"rev-parse -q --verify refs/stash",
+			"stash push -u -m Knit auto-stash",
+			"rev-parse -q --verify refs/stash",
+			"am some.patch",
+			"stash pop",
+		}))
+	})
+
+	Context("when the tree is clean", func() {
+		It("applies the patch without stashing", func() {
+			runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte("")}
+
+			_, err := r.ApplyPatchWithOptions("some.patch", options)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(commands).To(Equal([]string{"am some.patch"}))
+		})
+	})
+
+	Context("when the stash push saves nothing", func() {
+		It("applies the patch without popping someone else's stash", func() {
+			runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte(" M some-submodule\n")}
+			runner.RunCall.Stub = func(command patcher.Command) error {
+				args := command.Args
+				if args[0] == "-c" {
+					args = args[4:]
+				}
+				commands = append(commands, strings.Join(args, " "))
+
+				if args[0] == "rev-parse" {
+					fmt.Fprintln(command.Stdout, "users-stash")
+				}
+				return nil
+			}
+
+			_, err := r.ApplyPatchWithOptions("some.patch", options)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(commands).NotTo(ContainElement("stash pop"))
+			Expect(commands[len(commands)-1]).To(Equal("am some.patch"))
+		})
+	})
+
+	Context("when the patch fails to apply", func() {
+		It("aborts the am and restores the stash", func() {
+			failures["am some.patch"] = errors.New("patch does not apply")
+
+			_, err := r.ApplyPatchWithOptions("some.patch", options)
+			Expect(err).To(MatchError("patch does not apply"))
+
+			Expect(commands[3:]).To(Equal([]string{
+				"am some.patch",
+				"am --abort",
+				"stash pop",
+			}))
+		})
+	})
+
+	Context("when the stash cannot be popped", func() {
+		It("resets the tree and reports that the changes remain in the stash", func() {
+			failures["stash pop"] = errors.New("conflict")
+
+			_, err := r.ApplyPatchWithOptions("some.patch", options)
+			Expect(err).To(Equal(patcher.StashPopError{Err: errors.New("conflict")}))
+			Expect(err).To(MatchError("Stashed changes could not be restored, so the working tree was reset and they remain in the stash: conflict"))
+
+			Expect(commands[len(commands)-2:]).To(Equal([]string{
+				"reset -q --hard HEAD",
+				"clean -fdq",
+			}))
+		})
+	})
+})
@@ -0,0 +1,219 @@
+package patcher
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ApplyStrategy is one way of applying a patch tried by ApplyPatchBestEffort.
+type ApplyStrategy string
+
+const (
+	ApplyStrategyAm          ApplyStrategy = "am"
+	ApplyStrategyAm3Way      ApplyStrategy = "am --3way"
+	ApplyStrategyApply3Way   ApplyStrategy = "apply --3way"
+	ApplyStrategyApplyReject ApplyStrategy = "apply --reject"
+)
+
+type StrategyFailure struct {
+	Strategy ApplyStrategy
+	Err      error
+}
+
+type BestEffortResult struct {
+	// Strategy is the strategy that applied the patch.
+	Strategy ApplyStrategy
+	// Failures holds the strategies tried before it, in order.
+	Failures []StrategyFailure
+	// Rejected lists the files with hunks that apply --reject could not
+	// apply. Each has a .rej file beside it holding those hunks; the rest of
+	// the patch was committed.
+	Rejected []string
+}
+
+// ApplyPatchBestEffort tries each strategy in order until one applies patch,
+// resetting the checkout to its starting commit with reset --merge after
+// every failed attempt, which keeps unstaged edits. Staged changes would be
+// lost, so the index must match HEAD. The am strategies keep the patch's
+// commit; the apply strategies commit the result with a knit message, and
+// apply --reject succeeds as long as any of the patch applied. If every
+// strategy fails, the result lists why alongside the error.
+func (r Repo) ApplyPatchBestEffort(patch string, strategies []ApplyStrategy) (BestEffortResult, error) {
+	var result BestEffortResult
+	err := r.withPatchHooks("ApplyPatchBestEffort", patch, func() error {
+		var err error
+		result, err = r.applyPatchBestEffort(patch, strategies)
+		return err
+	})
+
+	return result, err
+}
+
+func (r Repo) applyPatchBestEffort(patch string, strategies []ApplyStrategy) (BestEffortResult, error) {
+	if err := r.requireLocal("ApplyPatchBestEffort"); err != nil {
+		return BestEffortResult{}, err
+	}
+
+	if len(strategies) == 0 {
+		return BestEffortResult{}, errors.New("No apply strategies given")
+	}
+
+	if r.deferCommits {
+		return BestEffortResult{}, errors.New("Patches cannot be applied best-effort while commits are deferred")
+	}
+
+	attempts := make([][]Command, len(strategies))
+	for i, strategy := range strategies {
+		var err error
+		attempts[i], err = r.strategyCommands(strategy, patch)
+		if err != nil {
+			return BestEffortResult{}, err
+		}
+	}
+
+	if err := checkPatchEncoding(patch, ""); err != nil {
+		return BestEffortResult{}, err
+	}
+
+	if err := checkCombinedDiff(patch); err != nil {
+		return BestEffortResult{}, err
+	}
+
+	if r.run(Command{Args: []string{"diff", "--cached", "--quiet"}, Dir: r.repo}) != nil {
+		return BestEffortResult{}, fmt.Errorf("Patch %q cannot be applied best-effort while changes are staged", patch)
+	}
+
+	stdout := &bytes.Buffer{}
+	err := r.run(Command{
+		Args:   []string{"rev-parse", "HEAD"},
+		Dir:    r.repo,
+		Stdout: stdout,
+	})
+	if err != nil {
+		return BestEffortResult{}, err
+	}
+	head := strings.TrimSpace(stdout.String())
+
+	removed, before := r.expectedRemovals(patch)
+
+	var result BestEffortResult
+	for i, strategy := range strategies {
+		rejected, err := r.runStrategy(strategy, patch, attempts[i])
+		if err == nil {
+			err = r.verifyPathChanges(patch, before, removed)
+		}
+
+		if err == nil {
+			result.Strategy = strategy
+			result.Rejected = rejected
+			return result, nil
+		}
+
+		result.Failures = append(result.Failures, StrategyFailure{Strategy: strategy, Err: err})
+
+		if err := r.resetStrategy(strategy, head, rejected); err != nil {
+			return result, fmt.Errorf("Could not reset after strategy %q failed: %s", strategy, err)
+		}
+	}
+
+	return result, fmt.Errorf("Patch %q could not be applied with any of %d strategies", patch, len(strategies))
+}
+
+func (r Repo) strategyCommands(strategy ApplyStrategy, patch string) ([]Command, error) {
+	var apply []string
+	switch strategy {
+	case ApplyStrategyAm:
+		return []Command{{Args: r.withIdentity("am", patch), Dir: r.repo}}, nil
+	case ApplyStrategyAm3Way:
+		return []Command{{Args: r.withIdentity("am", "--3way", patch), Dir: r.repo}}, nil
+	case ApplyStrategyApply3Way:
+		apply = []string{"apply", "--index", "--3way", patch}
+	case ApplyStrategyApplyReject:
+		apply = []string{"apply", "--index", "--reject", patch}
+	default:
+		return nil, fmt.Errorf("Unknown apply strategy %q", strategy)
+	}
+
+	commands := []Command{{Args: apply, Dir: r.repo}}
+	return append(commands, r.commitCommands(r.repo, fmt.Sprintf("%s patch %s", r.messagePrefix, filepath.Base(patch)))...), nil
+}
+
+// runStrategy runs the commands of one attempt, returning the files apply
+// --reject left hunks of in .rej files. git exits nonzero and leaves the
+// index alone when it rejects a hunk, so the applied part is staged here.
+func (r Repo) runStrategy(strategy ApplyStrategy, patch string, commands []Command) ([]string, error) {
+	var rejected []string
+	for i, command := range commands {
+		output, err := r.combinedOutput(command)
+		if strategy == ApplyStrategyApplyReject && i == 0 {
+			rejected = parseRejectedPaths(output)
+			if err != nil && len(rejected) > 0 {
+				err = r.stagePatchPaths(patch)
+			}
+		}
+
+		if err != nil {
+			return rejected, fmt.Errorf("%s\n%s", err, strings.TrimSpace(string(output)))
+		}
+	}
+
+	return rejected, nil
+}
+
+func (r Repo) stagePatchPaths(patch string) error {
+	paths, err := r.patchPaths(patch)
+	if err != nil {
+		return err
+	}
+
+	return r.run(Command{
+		Args: append([]string{"add", "-A", "--"}, paths...),
+		Dir:  r.repo,
+	})
+}
+
+func parseRejectedPaths(output []byte) []string {
+	applying := regexp.MustCompile(`^Applying patch (.*) with \d+ rejects?\.\.\.$`)
+
+	var paths []string
+	for _, line := range splitLines(output) {
+		if matches := applying.FindStringSubmatch(line); matches != nil {
+			paths = append(paths, matches[1])
+		}
+	}
+
+	return paths
+}
+
+// resetStrategy undoes a failed attempt. Only the .rej files git wrote for
+// this attempt are removed.
+func (r Repo) resetStrategy(strategy ApplyStrategy, head string, rejected []string) error {
+	if strings.HasPrefix(string(strategy), "am") {
+		r.run(Command{
+			Args: []string{"am", "--abort"},
+			Dir:  r.repo,
+		})
+	}
+
+	err := r.run(Command{
+		Args: []string{"reset", "--merge", head},
+		Dir:  r.repo,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, path := range rejected {
+		err := os.Remove(filepath.Join(r.repo, path+".rej"))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
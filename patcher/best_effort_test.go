@@ -0,0 +1,258 @@
+package patcher_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pivotal-cf/knit/patcher"
+	"github.com/pivotal-cf/knit/patcher/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ApplyPatchBestEffort", func() {
+	var (
+		runner     *fakes.CommandRunner
+		r          patcher.Repo
+		failing    map[string]bool
+		strategies []patcher.ApplyStrategy
+	)
+
+	BeforeEach(func() {
+		failing = map[string]bool{}
+		strategies = []patcher.ApplyStrategy{
+			patcher.ApplyStrategyAm,
+			patcher.ApplyStrategyAm3Way,
+			patcher.ApplyStrategyApply3Way,
+			patcher.ApplyStrategyApplyReject,
+		}
+
+		runner = &fakes.CommandRunner{}
+		runner.RunCall.Stub = func(command patcher.Command) error {
+			if command.Args[0] == "rev-parse" {
+				fmt.Fprintln(command.Stdout, "some-head")
+			}
+			return nil
+		}
+		runner.CombinedOutputCall.Stub = func(command patcher.Command) ([]byte, error) {
+			args := strings.Join(command.Args, " ")
+			for prefix := range failing {
+				if strings.Contains(args, prefix) {
+					return []byte("error: patch failed: some-file:1"), errors.New("exit status 1")
+				}
+			}
+
+			return nil, nil
+		}
+
+		r = patcher.NewRepo(runner, "/some/repo", "testbot", "foo@example.com")
+	})
+
+	It("stops at the first strategy that applies the patch", func() {
+		result, err := r.ApplyPatchBestEffort("/patches/some.patch", strategies)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(patcher.BestEffortResult{Strategy: patcher.ApplyStrategyAm}))
+
+		Expect(runner.RunCall.Receives.Commands).To(Equal([]patcher.Command{
+			patcher.Command{
+				Args: []string{"diff", "--cached", "--quiet"},
+				Dir:  "/some/repo",
+			},
+			patcher.Command{
+				Args:   []string{"rev-parse", "HEAD"},
+				Dir:    "/some/repo",
+				Stdout: bytes.NewBufferString("some-head\n"),
+			},
+		}))
+		Expect(runner.CombinedOutputCall.Receives.Commands).To(Equal([]patcher.Command{
+			patcher.Command{
+				Args: []string{"-c", "user.name=testbot", "-c", "user.email=foo@example.com", "am", "/patches/some.patch"},
+				Dir:  "/some/repo",
+			},
+		}))
+	})
+
+	It("resets between attempts and records why earlier strategies failed", func() {
+		failing["am /patches"] = true
+		failing["am --3way"] = true
+
+		result, err := r.ApplyPatchBestEffort("/patches/some.patch", strategies)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Strategy).To(Equal(patcher.ApplyStrategyApply3Way))
+		Expect(result.Failures).To(HaveLen(2))
+		Expect(result.Failures[0].Strategy).To(Equal(patcher.ApplyStrategyAm))
+		Expect(result.Failures[0].Err).To(MatchError(ContainSubstring("patch failed: some-file:1")))
+		Expect(result.Failures[1].Strategy).To(Equal(patcher.ApplyStrategyAm3Way))
+
+		Expect(runner.RunCall.Receives.Commands[2:]).To(Equal([]patcher.Command{
+			patcher.Command{Args: []string{"am", "--abort"}, Dir: "/some/repo"},
+			patcher.Command{Args: []string{"reset", "--merge", "some-head"}, Dir: "/some/repo"},
+			patcher.Command{Args: []string{"am", "--abort"}, Dir: "/some/repo"},
+			patcher.Command{Args: []string{"reset", "--merge", "some-head"}, Dir: "/some/repo"},
+		}))
+
+		commands := runner.CombinedOutputCall.Receives.Commands
+		Expect(commands[2:]).To(Equal([]patcher.Command{
+			patcher.Command{
+				Args: []string{"apply", "--index", "--3way", "/patches/some.patch"},
+				Dir:  "/some/repo",
+			},
+			patcher.Command{
+				Args: []string{"-c", "user.name=testbot", "-c", "user.email=foo@example.com", "commit", "-m", "Knit patch some.patch", "--no-verify"},
+				Dir:  "/some/repo",
+			},
+		}))
+	})
+
+	Context("when apply --reject rejects some hunks", func() {
+		const patch = "fixtures/ci_change.patch"
+
+		BeforeEach(func() {
+			runner.CombinedOutputCall.Stub = func(command patcher.Command) ([]byte, error) {
+				if command.Args[0] == "apply" {
+					return []byte("Applying patch .ci/pipeline.yml with 1 reject...\nRejected hunk #1.\nApplied patch src/app/main.go cleanly.\n"), errors.New("exit status 1")
+				}
+				return nil, nil
+			}
+			runner.RunCall.Stub = func(command patcher.Command) error {
+				switch command.Args[0] {
+				case "rev-parse":
+					fmt.Fprintln(command.Stdout, "some-head")
+				case "apply":
+					fmt.Fprint(command.Stdout, "1\t0\t.ci/pipeline.yml\x001\t0\tsrc/app/main.go\x00")
+				}
+				return nil
+			}
+		})
+
+		It("stages and commits what applied and reports the rejected files", func() {
+			result, err := r.ApplyPatchBestEffort(patch, []patcher.ApplyStrategy{patcher.ApplyStrategyApplyReject})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal(patcher.BestEffortResult{
+				Strategy: patcher.ApplyStrategyApplyReject,
+				Rejected: []string{".ci/pipeline.yml"},
+			}))
+
+			commands := runner.RunCall.Receives.Commands
+			Expect(commands[len(commands)-1]).To(Equal(patcher.Command{
+				Args: []string{"add", "-A", "--", ".ci/pipeline.yml", "src/app/main.go"},
+				Dir:  "/some/repo",
+			}))
+			Expect(runner.CombinedOutputCall.Receives.Commands[1].Args).To(ContainElement("commit"))
+		})
+
+		Context("when the result cannot be committed", func() {
+			It("resets and removes only the reject files it wrote", func() {
+				repoPath, err := ioutil.TempDir("", "")
+				Expect(err).NotTo(HaveOccurred())
+				defer os.RemoveAll(repoPath)
+
+				Expect(os.Mkdir(filepath.Join(repoPath, ".ci"), 0755)).To(Succeed())
+				for _, name := range []string{".ci/pipeline.yml.rej", "old.rej"} {
+					Expect(ioutil.WriteFile(filepath.Join(repoPath, name), nil, 0644)).To(Succeed())
+				}
+
+				runner.CombinedOutputCall.Stub = func(command patcher.Command) ([]byte, error) {
+					if command.Args[0] == "apply" {
+						return []byte("Applying patch .ci/pipeline.yml with 1 reject...\n"), errors.New("exit status 1")
+					}
+					return []byte("nothing to commit"), errors.New("exit status 1")
+				}
+
+				r = patcher.NewRepo(runner, repoPath, "testbot", "foo@example.com")
+				_, err = r.ApplyPatchBestEffort(patch, []patcher.ApplyStrategy{patcher.ApplyStrategyApplyReject})
+				Expect(err).To(MatchError(fmt.Sprintf("Patch %q could not be applied with any of 1 strategies", patch)))
+
+				commands := runner.RunCall.Receives.Commands
+				Expect(commands[len(commands)-1]).To(Equal(patcher.Command{
+					Args: []string{"reset", "--merge", "some-head"},
+					Dir:  repoPath,
+				}))
+				Expect(filepath.Join(repoPath, ".ci/pipeline.yml.rej")).NotTo(BeAnExistingFile())
+				Expect(filepath.Join(repoPath, "old.rej")).To(BeAnExistingFile())
+			})
+		})
+	})
+
+	Context("when changes are staged", func() {
+		It("returns an error before trying anything", func() {
+			runner.RunCall.Stub = func(patcher.Command) error {
+				return errors.New("exit status 1")
+			}
+
+			_, err := r.ApplyPatchBestEffort("/patches/some.patch", strategies)
+			Expect(err).To(MatchError(`Patch "/patches/some.patch" cannot be applied best-effort while changes are staged`))
+			Expect(runner.CombinedOutputCall.Receives.Commands).To(BeEmpty())
+		})
+	})
+
+	Context("when every strategy fails", func() {
+		It("returns an error along with every failure", func() {
+			failing["/patches/some.patch"] = true
+
+			result, err := r.ApplyPatchBestEffort("/patches/some.patch", strategies)
+			Expect(err).To(MatchError(`Patch "/patches/some.patch" could not be applied with any of 4 strategies`))
+			Expect(result.Strategy).To(BeEmpty())
+			Expect(result.Failures).To(HaveLen(4))
+		})
+	})
+
+	Context("when a strategy is unknown", func() {
+		It("returns an error before trying anything", func() {
+			_, err := r.ApplyPatchBestEffort("/patches/some.patch", []patcher.ApplyStrategy{"rebase"})
+			Expect(err).To(MatchError(`Unknown apply strategy "rebase"`))
+			Expect(runner.CombinedOutputCall.Receives.Commands).To(BeEmpty())
+		})
+	})
+
+	Context("when commits are deferred", func() {
+		It("returns an error", func() {
+			_, err := r.WithDeferredCommits().ApplyPatchBestEffort("/patches/some.patch", strategies)
+			Expect(err).To(MatchError("Patches cannot be applied best-effort while commits are deferred"))
+		})
+	})
+})
+
+var _ = Describe("ApplyPatchBestEffort with apply --reject", func() {
+	var (
+		repo      gitRepo
+		patchPath string
+	)
+
+	BeforeEach(func() {
+		repo = newGitRepo()
+		repo.writeFile("conflicting", "1\n2\n3\n")
+		repo.writeFile("clean", "a\n")
+		repo.commit("Initial")
+
+		repo.writeFile("conflicting", "1\nX\n3\n")
+		repo.writeFile("clean", "b\n")
+		repo.commit("Patch")
+		patchPath = repo.formatPatch("-1")
+		repo.git("reset", "-q", "--hard", "HEAD^")
+
+		repo.writeFile("conflicting", "1\nY\n3\n")
+		repo.commit("Ours")
+	})
+
+	AfterEach(func() {
+		repo.remove()
+	})
+
+	It("commits the hunks that applied and leaves the rest in .rej files", func() {
+		result, err := repo.repo().ApplyPatchBestEffort(patchPath, []patcher.ApplyStrategy{patcher.ApplyStrategyAm, patcher.ApplyStrategyApplyReject})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Strategy).To(Equal(patcher.ApplyStrategyApplyReject))
+		Expect(result.Rejected).To(Equal([]string{"conflicting"}))
+
+		Expect(repo.git("show", "HEAD:clean")).To(Equal("b\n"))
+		Expect(repo.git("show", "HEAD:conflicting")).To(Equal("1\nY\n3\n"))
+		Expect(repo.git("status", "--porcelain")).To(Equal("?? conflicting.rej\n"))
+	})
+})
@@ -0,0 +1,126 @@
+package patcher
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type BinaryPatchError struct {
+	Patch string
+	Paths []string
+}
+
+func (e BinaryPatchError) Error() string {
+	return fmt.Sprintf("Patch %q did not modify binary files: %s", e.Patch, strings.Join(e.Paths, ", "))
+}
+
+// ApplyBinaryPatch applies a patch containing binary diffs with git apply
+// --binary and commits the result. If any binary file the patch targets is
+// left unchanged, the patch is reverted and a BinaryPatchError returned.
+func (r Repo) ApplyBinaryPatch(patch string) error {
+	return r.withPatchHooks("ApplyBinaryPatch", patch, func() error {
+		return r.applyBinaryPatch(patch)
+	})
+}
+
+func (r Repo) applyBinaryPatch(patch string) error {
+	if err := r.requireLocal("ApplyBinaryPatch"); err != nil {
+		return err
+	}
+
+	contents, err := ioutil.ReadFile(patch)
+	if err != nil {
+		return err
+	}
+
+	binaryPaths := binaryPatchTargets(string(contents))
+
+	before := map[string]string{}
+	for _, path := range binaryPaths {
+		before[path], err = r.fileChecksum(path)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = r.run(Command{
+		Args: []string{"apply", "--binary", "--index", patch},
+		Dir:  r.repo,
+	})
+	if err != nil {
+		return err
+	}
+
+	var unchanged []string
+	for _, path := range binaryPaths {
+		after, err := r.fileChecksum(path)
+		if err != nil {
+			return err
+		}
+
+		if after == before[path] {
+			unchanged = append(unchanged, path)
+		}
+	}
+
+	if len(unchanged) > 0 {
+		err := r.run(Command{
+			Args: []string{"apply", "--binary", "--index", "-R", patch},
+			Dir:  r.repo,
+		})
+		if err != nil {
+			return fmt.Errorf("Patch %q did not modify binary files and could not be reverted: %s", patch, err)
+		}
+
+		return BinaryPatchError{Patch: patch, Paths: unchanged}
+	}
+
+	for _, command := range r.commitCommands(r.repo, fmt.Sprintf("%s binary patch %s", r.messagePrefix, filepath.Base(patch))) {
+		if err := r.run(command); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r Repo) fileChecksum(path string) (string, error) {
+	contents, err := ioutil.ReadFile(filepath.Join(r.repo, path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", sha1.Sum(contents)), nil
+}
+
+func binaryPatchTargets(patch string) []string {
+	var (
+		paths   []string
+		current string
+	)
+
+	for _, line := range strings.Split(patch, "\n") {
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			current = ""
+			if index := strings.LastIndex(line, " b/"); index != -1 {
+				current = line[index+len(" b/"):]
+			}
+		case strings.HasPrefix(line, "GIT binary patch"), strings.HasPrefix(line, "Binary files "):
+			if current != "" {
+				paths = append(paths, current)
+				current = ""
+			}
+		}
+	}
+
+	return paths
+}
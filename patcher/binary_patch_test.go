@@ -0,0 +1,142 @@
+package patcher_test
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pivotal-cf/knit/patcher"
+	"github.com/pivotal-cf/knit/patcher/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const binaryPatch = `From 1234567890abcdef1234567890abcdef12345678 Mon Sep 17 00:00:00 2001
+From: Some Author <author@example.com>
+Subject: [PATCH] update the icon
+
+---
+ icon.png | Bin 4 -> 4 bytes
+ 1 file changed, 0 insertions(+), 0 deletions(-)
+
+diff --git a/icon.png b/icon.png
+index 1111111..2222222 100644
+GIT binary patch
+literal 4
+LcmZ?wbhHQn0QdkO
+
+literal 4
+LcmZ?wbhHQn0QdkO
+
+--
+2.9.0
+`
+
+var _ = Describe("ApplyBinaryPatch", func() {
+	var (
+		runner    *fakes.CommandRunner
+		repoPath  string
+		patchPath string
+		r         patcher.Repo
+	)
+
+	BeforeEach(func() {
+		var err error
+		repoPath, err = ioutil.TempDir("", "")
+		Expect(err).NotTo(HaveOccurred())
+
+		err = ioutil.WriteFile(filepath.Join(repoPath, "icon.png"), []byte("old!"), 0644)
+		Expect(err).NotTo(HaveOccurred())
+
+		patchPath = filepath.Join(repoPath, "icon.patch")
+		err = ioutil.WriteFile(patchPath, []byte(binaryPatch), 0644)
+		Expect(err).NotTo(HaveOccurred())
+
+		runner = &fakes.CommandRunner{}
+		runner.RunCall.Stub = func(command patcher.Command) error {
+			if command.Args[0] == "apply" {
+				return ioutil.WriteFile(filepath.Join(repoPath, "icon.png"), []byte("new!"), 0644)
+			}
+			return nil
+		}
+
+		r = patcher.NewRepo(runner, repoPath, "testbot", "foo@example.com")
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(repoPath)).To(Succeed())
+	})
+
+	It("applies the patch with binary support and commits the result", func() {
+		err := r.ApplyBinaryPatch(patchPath)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(runner.RunCall.Receives.Commands).To(Equal([]patcher.Command{
+			patcher.Command{
+				Args: []string{"apply", "--binary", "--index", patchPath},
+				Dir:  repoPath,
+			},
+			patcher.Command{
+				Args: []string{
+					"-c", "user.name=testbot",
+					"-c", "user.email=foo@example.com",
+					"commit",
+					"-m", "Knit binary patch icon.patch",
+					"--no-verify",
+				},
+				Dir: repoPath,
+			},
+		}))
+	})
+
+	Context("when a binary file is left unmodified", func() {
+		It("reverts the patch and returns a BinaryPatchError without committing", func() {
+			runner.RunCall.Stub = func(command patcher.Command) error {
+				if command.Args[len(command.Args)-2] == "-R" {
+					return os.Remove(filepath.Join(repoPath, "other-file"))
+				}
+				return ioutil.WriteFile(filepath.Join(repoPath, "other-file"), []byte("patched"), 0644)
+			}
+
+			err := r.ApplyBinaryPatch(patchPath)
+			Expect(err).To(Equal(patcher.BinaryPatchError{Patch: patchPath, Paths: []string{"icon.png"}}))
+			Expect(err).To(MatchError(fmt.Sprintf("Patch %q did not modify binary files: icon.png", patchPath)))
+
+			Expect(runner.RunCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{"apply", "--binary", "--index", patchPath},
+					Dir:  repoPath,
+				},
+				patcher.Command{
+					Args: []string{"apply", "--binary", "--index", "-R", patchPath},
+					Dir:  repoPath,
+				},
+			}))
+			Expect(filepath.Join(repoPath, "other-file")).NotTo(BeAnExistingFile())
+			Expect(ioutil.ReadFile(filepath.Join(repoPath, "icon.png"))).To(Equal([]byte("old!")))
+		})
+
+		Context("when the revert fails", func() {
+			It("returns an error", func() {
+				runner.RunCall.Returns.Errors = []error{nil, errors.New("meow")}
+				runner.RunCall.Stub = nil
+
+				err := r.ApplyBinaryPatch(patchPath)
+				Expect(err).To(MatchError(fmt.Sprintf("Patch %q did not modify binary files and could not be reverted: meow", patchPath)))
+			})
+		})
+	})
+
+	Context("when git apply fails", func() {
+		It("returns an error", func() {
+			runner.RunCall.Stub = nil
+			runner.RunCall.Returns.Errors = []error{errors.New("meow")}
+
+			err := r.ApplyBinaryPatch(patchPath)
+			Expect(err).To(MatchError("meow"))
+		})
+	})
+})
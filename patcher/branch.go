@@ -0,0 +1,39 @@
+package patcher
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+const refNotFoundExitCode = 1
+
+// ListBranches returns the short names of the repository's local branches.
+func (r Repo) ListBranches() ([]string, error) {
+	output, err := r.combinedOutput(Command{
+		Args: []string{"branch", "--format=%(refname:short)"},
+		Dir:  r.repo,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return splitLines(output), nil
+}
+
+// BranchExists reports whether a local branch called name exists. Only
+// failures other than a missing ref are returned as errors.
+func (r Repo) BranchExists(name string) (bool, error) {
+	err := r.run(Command{
+		Args: []string{"rev-parse", "--verify", "--quiet", fmt.Sprintf("refs/heads/%s", name)},
+		Dir:  r.repo,
+	})
+	if err == nil {
+		return true, nil
+	}
+
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == refNotFoundExitCode {
+		return false, nil
+	}
+
+	return false, err
+}
@@ -0,0 +1,75 @@
+package patcher_test
+
+import (
+	"errors"
+	"os/exec"
+
+	"github.com/pivotal-cf/knit/patcher"
+	"github.com/pivotal-cf/knit/patcher/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Branches", func() {
+	var (
+		runner *fakes.CommandRunner
+		r      patcher.Repo
+	)
+
+	BeforeEach(func() {
+		runner = &fakes.CommandRunner{}
+		r = patcher.NewRepo(runner, "/some/repo", "testbot", "foo@example.com")
+	})
+
+	Describe("ListBranches", func() {
+		It("returns the local branch names", func() {
+			runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte("master\nknit/1.9.2\n")}
+			runner.CombinedOutputCall.Returns.Errors = []error{nil}
+
+			branches, err := r.ListBranches()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(branches).To(Equal([]string{"master", "knit/1.9.2"}))
+
+			Expect(runner.CombinedOutputCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{"branch", "--format=%(refname:short)"},
+					Dir:  "/some/repo",
+				},
+			}))
+		})
+	})
+
+	Describe("BranchExists", func() {
+		It("returns true when the branch resolves", func() {
+			exists, err := r.BranchExists("1.9.2")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(exists).To(BeTrue())
+
+			Expect(runner.RunCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{"rev-parse", "--verify", "--quiet", "refs/heads/1.9.2"},
+					Dir:  "/some/repo",
+				},
+			}))
+		})
+
+		It("returns false when rev-parse cannot find the branch", func() {
+			notFound := exec.Command("false").Run()
+			runner.RunCall.Returns.Errors = []error{notFound}
+
+			exists, err := r.BranchExists("1.9.2")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(exists).To(BeFalse())
+		})
+
+		Context("when rev-parse fails for another reason", func() {
+			It("returns an error", func() {
+				runner.RunCall.Returns.Errors = []error{errors.New("not a git repository")}
+
+				_, err := r.BranchExists("1.9.2")
+				Expect(err).To(MatchError("not a git repository"))
+			})
+		})
+	})
+})
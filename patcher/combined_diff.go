@@ -0,0 +1,32 @@
+package patcher
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// CombinedDiffError is returned by ApplyPatch for patches exported from
+// merge commits, which git am cannot apply.
+type CombinedDiffError struct {
+	Patch string
+}
+
+func (e CombinedDiffError) Error() string {
+	return fmt.Sprintf("Patch %q is a combined diff from a merge commit: combined diffs are not supported; export with -m or cherry-pick instead", e.Patch)
+}
+
+func checkCombinedDiff(patch string) error {
+	contents, err := ioutil.ReadFile(patch)
+	if err != nil {
+		return nil
+	}
+
+	for _, line := range strings.Split(string(contents), "\n") {
+		if strings.HasPrefix(line, "diff --cc ") || strings.HasPrefix(line, "diff --combined ") || strings.HasPrefix(line, "@@@ ") {
+			return CombinedDiffError{Patch: patch}
+		}
+	}
+
+	return nil
+}
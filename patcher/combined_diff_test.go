@@ -0,0 +1,29 @@
+package patcher_test
+
+import (
+	"github.com/pivotal-cf/knit/patcher"
+	"github.com/pivotal-cf/knit/patcher/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ApplyPatch with a combined diff", func() {
+	var (
+		runner *fakes.CommandRunner
+		r      patcher.Repo
+	)
+
+	BeforeEach(func() {
+		runner = &fakes.CommandRunner{}
+		r = patcher.NewRepo(runner, "/some/repo", "testbot", "foo@example.com")
+	})
+
+	It("rejects the patch before running git am", func() {
+		err := r.ApplyPatch("fixtures/combined_diff.patch")
+		Expect(err).To(Equal(patcher.CombinedDiffError{Patch: "fixtures/combined_diff.patch"}))
+		Expect(err).To(MatchError(ContainSubstring("combined diffs are not supported; export with -m or cherry-pick instead")))
+
+		Expect(runner.RunCall.Receives.Commands).To(BeEmpty())
+	})
+})
@@ -6,9 +6,30 @@ import (
 	"os/exec"
 )
 
+// Command is a single invocation of the runner's executable.
 type Command struct {
+	// Args are passed to the executable, without the executable itself.
 	Args []string
-	Dir  string
+	// Dir is the working directory. An empty Dir runs in the current one.
+	Dir string
+	// Stdin, if set, is streamed to the command's standard input.
+	Stdin io.Reader
+	// Stdout, if set, receives the command's standard output from Run in
+	// place of the runner's own writer.
+	Stdout io.Writer
+	// Stderr, if set, receives a copy of the command's standard error from
+	// Run alongside the runner's own writer.
+	Stderr io.Writer
+	// Env holds KEY=value pairs added to the inherited environment.
+	Env []string
+}
+
+// Runner executes commands on behalf of a Repo. Run sends output wherever the
+// runner is configured to; CombinedOutput returns stdout and stderr together,
+// along with any output produced before a failure.
+type Runner interface {
+	Run(command Command) (err error)
+	CombinedOutput(command Command) ([]byte, error)
 }
 
 type CommandRunner struct {
@@ -35,9 +56,11 @@ func NewCommandRunner(executable string, quiet bool) (CommandRunner, error) {
 
 func (r CommandRunner) CombinedOutput(command Command) ([]byte, error) {
 	cmd := &exec.Cmd{
-		Path: r.Executable,
-		Args: append([]string{r.Executable}, command.Args...),
-		Dir:  command.Dir,
+		Path:  r.Executable,
+		Args:  append([]string{r.Executable}, command.Args...),
+		Dir:   command.Dir,
+		Stdin: command.Stdin,
+		Env:   commandEnv(command),
 	}
 
 	output, err := cmd.CombinedOutput()
@@ -53,9 +76,14 @@ func (r CommandRunner) Run(command Command) error {
 		Path:   r.Executable,
 		Args:   append([]string{r.Executable}, command.Args...),
 		Dir:    command.Dir,
+		Stdin:  command.Stdin,
+		Env:    commandEnv(command),
 		Stdout: r.Stdout,
-		Stderr: r.Stderr,
 	}
+	if command.Stdout != nil {
+		cmd.Stdout = command.Stdout
+	}
+	cmd.Stderr = teeWriter(r.Stderr, command.Stderr)
 
 	err := cmd.Run()
 	if err != nil {
@@ -64,3 +92,22 @@ func (r CommandRunner) Run(command Command) error {
 
 	return nil
 }
+
+func commandEnv(command Command) []string {
+	if len(command.Env) == 0 {
+		return nil
+	}
+
+	return append(os.Environ(), command.Env...)
+}
+
+func teeWriter(w, extra io.Writer) io.Writer {
+	switch {
+	case extra == nil:
+		return w
+	case w == nil:
+		return extra
+	}
+
+	return io.MultiWriter(w, extra)
+}
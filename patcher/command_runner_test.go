@@ -71,6 +71,67 @@ var _ = Describe("CommandRunner", func() {
 			Expect(runner.Stderr).To(ContainSubstring("GET / HTTP/"))
 		})
 
+		It("passes stdin to the command", func() {
+			runner, err = patcher.NewCommandRunner("cat", true)
+			Expect(err).NotTo(HaveOccurred())
+			runner.Stderr = bytes.NewBuffer([]byte{})
+			runner.Stdout = bytes.NewBuffer([]byte{})
+
+			err = runner.Run(patcher.Command{
+				Stdin: bytes.NewBufferString("banana"),
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(runner.Stdout).To(Equal(bytes.NewBuffer([]byte("banana"))))
+		})
+
+		It("adds the command's environment to the inherited one", func() {
+			runner, err = patcher.NewCommandRunner("sh", true)
+			Expect(err).NotTo(HaveOccurred())
+			runner.Stderr = bytes.NewBuffer([]byte{})
+			runner.Stdout = bytes.NewBuffer([]byte{})
+
+			err = runner.Run(patcher.Command{
+				Args: []string{"-c", "echo $KNIT_VAR"},
+				Env:  []string{"KNIT_VAR=banana"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(runner.Stdout).To(Equal(bytes.NewBuffer([]byte("banana\n"))))
+		})
+
+		It("sends stdout to the command's own writer when it has one", func() {
+			runner, err = patcher.NewCommandRunner("sh", true)
+			Expect(err).NotTo(HaveOccurred())
+			runner.Stderr = bytes.NewBuffer([]byte{})
+			runner.Stdout = bytes.NewBuffer([]byte{})
+
+			stdout := bytes.NewBuffer([]byte{})
+			err = runner.Run(patcher.Command{
+				Args:   []string{"-c", "echo banana; echo warning >&2"},
+				Stdout: stdout,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stdout).To(Equal(bytes.NewBuffer([]byte("banana\n"))))
+			Expect(runner.Stdout).To(Equal(bytes.NewBuffer([]byte{})))
+			Expect(runner.Stderr).To(Equal(bytes.NewBuffer([]byte("warning\n"))))
+		})
+
+		It("copies stderr to the command's own writer when it has one", func() {
+			runner, err = patcher.NewCommandRunner("sh", true)
+			Expect(err).NotTo(HaveOccurred())
+			runner.Stderr = bytes.NewBuffer([]byte{})
+			runner.Stdout = bytes.NewBuffer([]byte{})
+
+			stderr := bytes.NewBuffer([]byte{})
+			err = runner.Run(patcher.Command{
+				Args:   []string{"-c", "echo banana; echo warning >&2"},
+				Stderr: stderr,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stderr).To(Equal(bytes.NewBuffer([]byte("warning\n"))))
+			Expect(runner.Stderr).To(Equal(bytes.NewBuffer([]byte("warning\n"))))
+			Expect(runner.Stdout).To(Equal(bytes.NewBuffer([]byte("banana\n"))))
+		})
+
 		Context("failure cases", func() {
 			Context("when the given executable does not exist", func() {
 				It("returns an error", func() {
@@ -113,5 +174,16 @@ var _ = Describe("CommandRunner", func() {
 			Expect(err).NotTo(HaveOccurred())
 			Expect(output).To(Equal([]byte("command output\n")))
 		})
+
+		It("passes stdin to the command", func() {
+			runner, err := patcher.NewCommandRunner("cat", true)
+			Expect(err).NotTo(HaveOccurred())
+
+			output, err := runner.CombinedOutput(patcher.Command{
+				Stdin: bytes.NewBufferString("command input"),
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(output).To(Equal([]byte("command input")))
+		})
 	})
 })
@@ -0,0 +1,65 @@
+package patcher_test
+
+import (
+	"path/filepath"
+
+	"github.com/pivotal-cf/knit/patcher"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ApplyPatchWithOptions with a conflict resolution strategy", func() {
+	var (
+		repo      gitRepo
+		patchPath string
+		r         patcher.Repo
+	)
+
+	// preparePatch commits patched on top of the base contents as the patch,
+	// then moves HEAD to a commit of ours made on the same base.
+	preparePatch := func(patched, ours string) {
+		repo.git("checkout", "-q", "-b", "patch")
+		repo.writeFile("file", patched)
+		repo.commit("Patch")
+		patchPath = repo.formatPatch("-1")
+
+		repo.git("checkout", "-q", "master")
+		repo.writeFile("file", ours)
+		repo.commit("Ours")
+	}
+
+	BeforeEach(func() {
+		repo = newGitRepo()
+		repo.writeFile("file", "a\nb\nc\nd\ne\nf\ng\nh\n")
+		repo.commit("Initial")
+
+		r = repo.repo()
+	})
+
+	AfterEach(func() {
+		repo.remove()
+	})
+
+	It("keeps the patch's hunks that do not conflict", func() {
+		preparePatch("a\nB2\nc\nd\ne\nf\nG2\nh\n", "a\nB1\nc\nd\ne\nf\ng\nh\n")
+
+		result, err := r.ApplyPatchWithOptions(patchPath, patcher.ApplyPatchOptions{Strategy: "ours"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Resolved).To(Equal([]string{"file"}))
+
+		Expect(repo.readFile("file")).To(Equal("a\nB1\nc\nd\ne\nf\nG2\nh\n"))
+		Expect(repo.git("log", "-1", "--format=%s")).To(Equal("Patch\n"))
+		Expect(repo.git("status", "--porcelain")).To(BeEmpty())
+	})
+
+	It("skips the patch when the resolution leaves nothing of it", func() {
+		preparePatch("a\nB2\nc\nd\ne\nf\ng\nh\n", "a\nB1\nc\nd\ne\nf\ng\nh\n")
+
+		_, err := r.ApplyPatchWithOptions(patchPath, patcher.ApplyPatchOptions{Strategy: "ours"})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(repo.git("log", "-1", "--format=%s")).To(Equal("Ours\n"))
+		Expect(filepath.Join(repo.path, ".git", "rebase-apply")).NotTo(BeADirectory())
+	})
+})
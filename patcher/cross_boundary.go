@@ -0,0 +1,143 @@
+package patcher
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ApplyCrossBoundaryPatch applies a patch whose diff touches both superproject
+// files and files inside submodules. Each submodule's portion is applied and
+// committed inside that submodule, then the superproject's portion is applied
+// and committed together with the updated submodule pointers.
+func (r Repo) ApplyCrossBoundaryPatch(patch string) error {
+	return r.withPatchHooks("ApplyCrossBoundaryPatch", patch, func() error {
+		if err := r.requireLocal("ApplyCrossBoundaryPatch"); err != nil {
+			return err
+		}
+
+		contents, err := ioutil.ReadFile(patch)
+		if err != nil {
+			return err
+		}
+
+		modulePaths, err := r.gitmodulesPaths()
+		if err != nil {
+			return err
+		}
+
+		portions, err := splitPatchBySubmodule(string(contents), modulePaths)
+		if err != nil {
+			return fmt.Errorf("Patch %q cannot be split: %s", patch, err)
+		}
+
+		stagingDir, err := ioutil.TempDir(r.tempDir, "knit-cross-boundary")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(stagingDir)
+
+		var submodules []string
+		for path := range portions {
+			if path != "" {
+				submodules = append(submodules, path)
+			}
+		}
+		sort.Strings(submodules)
+
+		var commands []Command
+		for i, path := range submodules {
+			portion := filepath.Join(stagingDir, fmt.Sprintf("%d.patch", i))
+			if err := ioutil.WriteFile(portion, []byte(portions[path]), 0644); err != nil {
+				return err
+			}
+
+			submoduleDir := filepath.Join(r.repo, path)
+			commands = append(commands,
+				Command{
+					Args: []string{"apply", "--index", fmt.Sprintf("-p%d", len(strings.Split(path, "/"))+1), portion},
+					Dir:  submoduleDir,
+				},
+				r.commitCommand(submoduleDir, fmt.Sprintf("%s submodule patch of %s", r.messagePrefix, path)),
+			)
+		}
+
+		if superproject, ok := portions[""]; ok {
+			portion := filepath.Join(stagingDir, "superproject.patch")
+			if err := ioutil.WriteFile(portion, []byte(superproject), 0644); err != nil {
+				return err
+			}
+
+			commands = append(commands, Command{
+				Args: []string{"apply", "--index", portion},
+				Dir:  r.repo,
+			})
+		}
+
+		if len(submodules) > 0 {
+			commands = append(commands, Command{
+				Args: append([]string{"add", "--"}, submodules...),
+				Dir:  r.repo,
+			})
+		}
+		commands = append(commands, r.commitCommands(r.repo, fmt.Sprintf("%s cross-boundary patch %s", r.messagePrefix, filepath.Base(patch)))...)
+
+		for _, command := range commands {
+			if err := r.run(command); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// splitPatchBySubmodule groups the per-file sections of a diff by the
+// submodule that contains each file, keyed by submodule path. Superproject
+// files are grouped under "".
+func splitPatchBySubmodule(contents string, modulePaths []string) (map[string]string, error) {
+	portions := map[string]string{}
+
+	sections := strings.Split("\n"+contents, "\ndiff --git ")
+	for i, section := range sections[1:] {
+		section = "diff --git " + section
+		if i < len(sections)-2 {
+			section += "\n"
+		}
+
+		header := strings.SplitN(section, "\n", 2)[0]
+		fields := strings.SplitN(strings.TrimPrefix(header, "diff --git "), " b/", 2)
+		if len(fields) != 2 || !strings.HasPrefix(fields[0], "a/") {
+			return nil, fmt.Errorf("unrecognised diff header %q", header)
+		}
+
+		from := owningSubmodule(strings.TrimPrefix(fields[0], "a/"), modulePaths)
+		to := owningSubmodule(fields[1], modulePaths)
+		if from != to {
+			return nil, fmt.Errorf("%q moves a file across a submodule boundary", header)
+		}
+
+		portions[from] += section
+	}
+
+	if len(portions) == 0 {
+		return nil, fmt.Errorf("no file changes found")
+	}
+
+	return portions, nil
+}
+
+func owningSubmodule(file string, modulePaths []string) string {
+	var owner string
+	for _, modulePath := range modulePaths {
+		modulePath = filepath.Clean(modulePath)
+		if strings.HasPrefix(file, modulePath+"/") && len(modulePath) > len(owner) {
+			owner = modulePath
+		}
+	}
+
+	return owner
+}
@@ -0,0 +1,158 @@
+package patcher_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pivotal-cf/knit/patcher"
+	"github.com/pivotal-cf/knit/patcher/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const crossBoundaryPatch = `From 1234567890abcdef1234567890abcdef12345678 Mon Sep 17 00:00:00 2001
+From: Some Author <author@example.com>
+Subject: [PATCH] Raise timeouts everywhere
+
+---
+diff --git a/config/timeouts.yml b/config/timeouts.yml
+--- a/config/timeouts.yml
++++ b/config/timeouts.yml
+@@ -1 +1 @@
+-timeout: 30
++timeout: 60
+diff --git a/src/module-one/lib/client.go b/src/module-one/lib/client.go
+--- a/src/module-one/lib/client.go
++++ b/src/module-one/lib/client.go
+@@ -1 +1 @@
+-const timeout = 30
++const timeout = 60
+-- 
+2.25.1
+`
+
+var _ = Describe("ApplyCrossBoundaryPatch", func() {
+	var (
+		runner   *fakes.CommandRunner
+		repoPath string
+		tempDir  string
+		patch    string
+		r        patcher.Repo
+	)
+
+	BeforeEach(func() {
+		var err error
+		repoPath, err = ioutil.TempDir("", "")
+		Expect(err).NotTo(HaveOccurred())
+
+		tempDir, err = ioutil.TempDir("", "")
+		Expect(err).NotTo(HaveOccurred())
+
+		err = ioutil.WriteFile(filepath.Join(repoPath, ".gitmodules"), []byte(`[submodule "src/module-one"]
+	path = src/module-one
+	url = https://example.com/module-one.git
+`), 0644)
+		Expect(err).NotTo(HaveOccurred())
+
+		patch = filepath.Join(tempDir, "0001-raise-timeouts.patch")
+		err = ioutil.WriteFile(patch, []byte(crossBoundaryPatch), 0644)
+		Expect(err).NotTo(HaveOccurred())
+
+		runner = &fakes.CommandRunner{}
+		r = patcher.NewRepo(runner, repoPath, "testbot", "foo@example.com").WithTempDir(tempDir)
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(repoPath)).To(Succeed())
+		Expect(os.RemoveAll(tempDir)).To(Succeed())
+	})
+
+	It("applies each portion in its own repository and commits them inside out", func() {
+		var portions []string
+		runner.RunCall.Stub = func(command patcher.Command) error {
+			if command.Args[0] == "apply" {
+				contents, err := ioutil.ReadFile(command.Args[len(command.Args)-1])
+				Expect(err).NotTo(HaveOccurred())
+				portions = append(portions, string(contents))
+			}
+			return nil
+		}
+
+		err := r.ApplyCrossBoundaryPatch(patch)
+		Expect(err).NotTo(HaveOccurred())
+
+		commands := runner.RunCall.Receives.Commands
+		Expect(commands).To(HaveLen(5))
+
+		Expect(commands[0].Args[:3]).To(Equal([]string{"apply", "--index", "-p3"}))
+		Expect(commands[0].Dir).To(Equal(filepath.Join(repoPath, "src/module-one")))
+		Expect(commands[1]).To(Equal(patcher.Command{
+			Args: []string{
+				"-c", "user.name=testbot",
+				"-c", "user.email=foo@example.com",
+				"commit", "-m", "Knit submodule patch of src/module-one", "--no-verify",
+			},
+			Dir: filepath.Join(repoPath, "src/module-one"),
+		}))
+
+		Expect(commands[2].Args[:2]).To(Equal([]string{"apply", "--index"}))
+		Expect(commands[2].Dir).To(Equal(repoPath))
+		Expect(commands[3]).To(Equal(patcher.Command{
+			Args: []string{"add", "--", "src/module-one"},
+			Dir:  repoPath,
+		}))
+		Expect(commands[4]).To(Equal(patcher.Command{
+			Args: []string{
+				"-c", "user.name=testbot",
+				"-c", "user.email=foo@example.com",
+				"commit", "-m", "Knit cross-boundary patch 0001-raise-timeouts.patch", "--no-verify",
+			},
+			Dir: repoPath,
+		}))
+
+		Expect(portions).To(Equal([]string{
+			`diff --git a/src/module-one/lib/client.go b/src/module-one/lib/client.go
+--- a/src/module-one/lib/client.go
++++ b/src/module-one/lib/client.go
+@@ -1 +1 @@
+-const timeout = 30
++const timeout = 60
+-- 
+2.25.1
+`,
+			`diff --git a/config/timeouts.yml b/config/timeouts.yml
+--- a/config/timeouts.yml
++++ b/config/timeouts.yml
+@@ -1 +1 @@
+-timeout: 30
++timeout: 60
+`,
+		}))
+	})
+
+	It("cleans up the split portions", func() {
+		err := r.ApplyCrossBoundaryPatch(patch)
+		Expect(err).NotTo(HaveOccurred())
+
+		entries, err := ioutil.ReadDir(tempDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(HaveLen(1))
+	})
+
+	Context("when a file moves across a submodule boundary", func() {
+		It("returns an error without applying anything", func() {
+			err := ioutil.WriteFile(patch, []byte(`diff --git a/lib/client.go b/src/module-one/lib/client.go
+similarity index 100%
+rename from lib/client.go
+rename to src/module-one/lib/client.go
+`), 0644)
+			Expect(err).NotTo(HaveOccurred())
+
+			err = r.ApplyCrossBoundaryPatch(patch)
+			Expect(err).To(MatchError(ContainSubstring("moves a file across a submodule boundary")))
+			Expect(runner.RunCall.Receives.Commands).To(BeEmpty())
+		})
+	})
+})
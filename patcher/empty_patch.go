@@ -0,0 +1,65 @@
+package patcher
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrEmptyPatch is returned by ApplyPatch for a patch file that is empty or
+// contains only whitespace, which usually means generating it failed.
+var ErrEmptyPatch = errors.New("Patch is empty")
+
+// checkEmptyPatch returns ErrEmptyPatch for a blank patch file. Patches that
+// cannot be read are left for git am to report.
+func checkEmptyPatch(patch string) error {
+	contents, err := ioutil.ReadFile(patch)
+	if err != nil {
+		return nil
+	}
+
+	if strings.TrimSpace(string(contents)) == "" {
+		return ErrEmptyPatch
+	}
+
+	return nil
+}
+
+// amStoppedOnEmptyPatch reports whether a failed git am stopped because the
+// mail it was applying had no diff, as format-patch produces for an empty
+// commit. git am leaves the extracted diff empty in that case.
+func (r Repo) amStoppedOnEmptyPatch() bool {
+	if remoteHost(r.runner) != "" {
+		return false
+	}
+
+	info, err := os.Stat(filepath.Join(gitDirPath(r.repo), "rebase-apply", "patch"))
+	return err == nil && info.Size() == 0
+}
+
+// amCommitted reports whether the last git am moved HEAD from the commit it
+// started on, which am records in ORIG_HEAD. It errs towards true, so that a
+// patch is checked rather than reported skipped.
+func (r Repo) amCommitted() bool {
+	stdout := &bytes.Buffer{}
+	err := r.run(Command{
+		Args:   []string{"rev-parse", "HEAD", "ORIG_HEAD"},
+		Dir:    r.repo,
+		Stdout: stdout,
+	})
+	if err != nil {
+		return true
+	}
+
+	heads := splitLines(stdout.Bytes())
+	return len(heads) == 2 && heads[0] != heads[1]
+}
+
+// amPosition returns the number of the mail a stopped git am is on.
+func (r Repo) amPosition() string {
+	contents, _ := ioutil.ReadFile(filepath.Join(gitDirPath(r.repo), "rebase-apply", "next"))
+	return strings.TrimSpace(string(contents))
+}
@@ -0,0 +1,142 @@
+package patcher_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pivotal-cf/knit/patcher"
+	"github.com/pivotal-cf/knit/patcher/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ApplyPatch with empty patches", func() {
+	var (
+		runner   *fakes.CommandRunner
+		repoPath string
+		patch    string
+		r        patcher.Repo
+	)
+
+	BeforeEach(func() {
+		var err error
+		repoPath, err = ioutil.TempDir("", "")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(os.MkdirAll(filepath.Join(repoPath, ".git"), 0755)).To(Succeed())
+		patch = filepath.Join(repoPath, "some.patch")
+
+		runner = &fakes.CommandRunner{}
+		r = patcher.NewRepo(runner, repoPath, "testbot", "foo@example.com")
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(repoPath)).To(Succeed())
+	})
+
+	It("returns ErrEmptyPatch for a zero-byte patch without running git am", func() {
+		Expect(ioutil.WriteFile(patch, nil, 0644)).To(Succeed())
+
+		err := r.ApplyPatch(patch)
+		Expect(err).To(Equal(patcher.ErrEmptyPatch))
+		Expect(runner.RunCall.Receives.Commands).To(BeEmpty())
+	})
+
+	It("returns ErrEmptyPatch for a whitespace-only patch", func() {
+		Expect(ioutil.WriteFile(patch, []byte(" \n\t\n"), 0644)).To(Succeed())
+
+		err := r.ApplyPatch(patch)
+		Expect(err).To(Equal(patcher.ErrEmptyPatch))
+		Expect(runner.RunCall.Receives.Commands).To(BeEmpty())
+	})
+
+	Context("when the patch has no changes", func() {
+		BeforeEach(func() {
+			Expect(ioutil.WriteFile(patch, []byte("From: Some Author <author@example.com>\nSubject: [PATCH] Nothing\n\n---\n"), 0644)).To(Succeed())
+
+			runner.RunCall.Stub = func(command patcher.Command) error {
+				if command.Args[len(command.Args)-1] != patch {
+					return nil
+				}
+
+				Expect(os.MkdirAll(filepath.Join(repoPath, ".git", "rebase-apply"), 0755)).To(Succeed())
+				Expect(ioutil.WriteFile(filepath.Join(repoPath, ".git", "rebase-apply", "patch"), nil, 0644)).To(Succeed())
+				return errors.New("exit status 1")
+			}
+		})
+
+		It("skips it and moves past it", func() {
+			result, err := r.ApplyPatchWithOptions(patch, patcher.ApplyPatchOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Skipped).To(BeTrue())
+
+			Expect(runner.RunCall.Receives.Commands[1]).To(Equal(patcher.Command{
+				Args: []string{"-c", "user.name=testbot", "-c", "user.email=foo@example.com", "am", "--skip"},
+				Dir:  repoPath,
+			}))
+		})
+	})
+
+	Context("when git am fails for another reason", func() {
+		It("returns the error", func() {
+			Expect(ioutil.WriteFile(patch, []byte("diff --git a/f b/f\n"), 0644)).To(Succeed())
+			runner.RunCall.Returns.Errors = []error{errors.New("patch does not apply")}
+
+			err := r.ApplyPatch(patch)
+			Expect(err).To(MatchError("patch does not apply"))
+			Expect(runner.RunCall.Receives.Commands).To(HaveLen(1))
+		})
+	})
+})
+
+var _ = Describe("ApplyPatch with an mbox that starts with an empty patch", func() {
+	var (
+		repo      gitRepo
+		patchPath string
+	)
+
+	BeforeEach(func() {
+		repo = newGitRepo()
+		repo.writeFile("README", "readme\n")
+		repo.commit("Initial")
+
+		repo.commit("Nothing")
+		repo.writeFile("ci.yml", "jobs: []\n")
+		repo.commit("Add CI")
+		patchPath = repo.formatPatch("-2")
+		repo.git("reset", "-q", "--hard", "HEAD~2")
+	})
+
+	AfterEach(func() {
+		repo.remove()
+	})
+
+	It("checks the patches applied after skipping the empty one", func() {
+		_, err := repo.repo().ApplyPatchWithOptions(patchPath, patcher.ApplyPatchOptions{ForbiddenPaths: []string{"ci.yml"}})
+		Expect(err).To(Equal(patcher.PolicyViolationError{Patch: patchPath, Paths: []string{"ci.yml"}}))
+
+		Expect(repo.git("log", "-1", "--format=%s")).To(Equal("Initial\n"))
+		Expect(filepath.Join(repo.path, "ci.yml")).NotTo(BeAnExistingFile())
+	})
+
+	It("reports the patch applied rather than skipped", func() {
+		result, err := repo.repo().ApplyPatchWithOptions(patchPath, patcher.ApplyPatchOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Skipped).To(BeFalse())
+		Expect(repo.git("log", "-1", "--format=%s")).To(Equal("Add CI\n"))
+	})
+
+	Context("when every patch in it is empty", func() {
+		It("reports it skipped", func() {
+			patchPath = repo.formatPatch("-1", "HEAD@{1}~1")
+
+			result, err := repo.repo().ApplyPatchWithOptions(patchPath, patcher.ApplyPatchOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Skipped).To(BeTrue())
+			Expect(repo.git("log", "-1", "--format=%s")).To(Equal("Initial\n"))
+		})
+	})
+})
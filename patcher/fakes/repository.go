@@ -66,6 +66,23 @@ type Repository struct {
 			Error error
 		}
 	}
+
+	PresentSubmodulesCall struct {
+		Returns struct {
+			Paths []string
+			Error error
+		}
+	}
+
+	VerifySubmoduleCountsCall struct {
+		Receives struct {
+			Before  []string
+			Changes []patcher.Changeset
+		}
+		Returns struct {
+			Error error
+		}
+	}
 }
 
 func (r *Repository) Checkout(checkoutRef string) error {
@@ -121,3 +138,14 @@ func (r *Repository) CheckoutBranch(name string) error {
 
 	return r.CheckoutBranchCall.Returns.Error
 }
+
+func (r *Repository) PresentSubmodules() ([]string, error) {
+	return r.PresentSubmodulesCall.Returns.Paths, r.PresentSubmodulesCall.Returns.Error
+}
+
+func (r *Repository) VerifySubmoduleCounts(before []string, changes []patcher.Changeset) error {
+	r.VerifySubmoduleCountsCall.Receives.Before = before
+	r.VerifySubmoduleCountsCall.Receives.Changes = changes
+
+	return r.VerifySubmoduleCountsCall.Returns.Error
+}
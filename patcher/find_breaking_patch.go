@@ -0,0 +1,53 @@
+package patcher
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FindBreakingPatch applies patches one at a time on top of the current HEAD,
+// calling test after each, and returns the first patch after which test fails.
+// An empty string means every patch passed. The repository is reset to its
+// starting commit with reset --keep before returning, so uncommitted edits
+// that the patches did not touch survive.
+func (r Repo) FindBreakingPatch(patches []string, test func(Repo) error) (string, error) {
+	output, err := r.combinedOutput(Command{
+		Args: []string{"rev-parse", "HEAD"},
+		Dir:  r.repo,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	base := strings.TrimSpace(string(output))
+	breakingPatch, err := r.findBreakingPatch(patches, test)
+
+	resetErr := r.run(Command{
+		Args: []string{"reset", "--keep", base},
+		Dir:  r.repo,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if resetErr != nil {
+		return "", resetErr
+	}
+
+	return breakingPatch, nil
+}
+
+func (r Repo) findBreakingPatch(patches []string, test func(Repo) error) (string, error) {
+	for _, patch := range patches {
+		if err := r.ApplyPatch(patch); err != nil {
+			r.abortAm()
+			return "", fmt.Errorf("Patch %q could not be applied: %s", patch, err)
+		}
+
+		if err := test(r); err != nil {
+			return patch, nil
+		}
+	}
+
+	return "", nil
+}
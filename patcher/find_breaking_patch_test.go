@@ -0,0 +1,97 @@
+package patcher_test
+
+import (
+	"errors"
+
+	"github.com/pivotal-cf/knit/patcher"
+	"github.com/pivotal-cf/knit/patcher/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("FindBreakingPatch", func() {
+	var (
+		runner  *fakes.CommandRunner
+		r       patcher.Repo
+		patches []string
+		applied []string
+	)
+
+	BeforeEach(func() {
+		runner = &fakes.CommandRunner{}
+		runner.CombinedOutputCall.Returns.Errors = []error{nil}
+		runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte("base-sha\n")}
+
+		applied = nil
+		runner.RunCall.Stub = func(command patcher.Command) error {
+			if command.Args[len(command.Args)-2] == "am" {
+				applied = append(applied, command.Args[len(command.Args)-1])
+			}
+			return nil
+		}
+
+		r = patcher.NewRepo(runner, "/some/repo", "testbot", "foo@example.com")
+		patches = []string{"1.patch", "2.patch", "3.patch"}
+	})
+
+	It("returns the first patch after which the test fails", func() {
+		breaking, err := r.FindBreakingPatch(patches, func(patcher.Repo) error {
+			if len(applied) == 2 {
+				return errors.New("build failed")
+			}
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(breaking).To(Equal("2.patch"))
+		Expect(applied).To(Equal([]string{"1.patch", "2.patch"}))
+	})
+
+	It("resets the repository to where it started", func() {
+		_, err := r.FindBreakingPatch(patches, func(patcher.Repo) error { return nil })
+		Expect(err).NotTo(HaveOccurred())
+
+		commands := runner.RunCall.Receives.Commands
+		Expect(commands[len(commands)-1]).To(Equal(patcher.Command{
+			Args: []string{"reset", "--keep", "base-sha"},
+			Dir:  "/some/repo",
+		}))
+	})
+
+	Context("when every patch passes", func() {
+		It("returns an empty string", func() {
+			breaking, err := r.FindBreakingPatch(patches, func(patcher.Repo) error { return nil })
+			Expect(err).NotTo(HaveOccurred())
+			Expect(breaking).To(BeEmpty())
+			Expect(applied).To(Equal(patches))
+		})
+	})
+
+	Context("when a patch does not apply", func() {
+		It("aborts the am and still resets", func() {
+			runner.RunCall.Stub = func(command patcher.Command) error {
+				if command.Args[len(command.Args)-1] == "2.patch" {
+					return errors.New("meow")
+				}
+				return nil
+			}
+
+			_, err := r.FindBreakingPatch(patches, func(patcher.Repo) error { return nil })
+			Expect(err).To(MatchError(`Patch "2.patch" could not be applied: meow`))
+
+			commands := runner.RunCall.Receives.Commands
+			Expect(commands[len(commands)-2].Args).To(Equal([]string{"am", "--abort"}))
+			Expect(commands[len(commands)-1].Args).To(Equal([]string{"reset", "--keep", "base-sha"}))
+		})
+	})
+
+	Context("when HEAD cannot be resolved", func() {
+		It("returns an error without applying anything", func() {
+			runner.CombinedOutputCall.Returns.Errors = []error{errors.New("meow")}
+
+			_, err := r.FindBreakingPatch(patches, func(patcher.Repo) error { return nil })
+			Expect(err).To(MatchError("meow"))
+			Expect(runner.RunCall.Receives.Commands).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,98 @@
+package patcher
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// SubmoduleForeachParallel runs git with args in every checked out submodule,
+// including nested ones, with up to jobs commands in flight at a time. Unlike
+// git submodule foreach it keeps going when a submodule fails, and returns all
+// of the failures together.
+func (r Repo) SubmoduleForeachParallel(args []string, jobs int) error {
+	if err := r.requireLocal("SubmoduleForeachParallel"); err != nil {
+		return err
+	}
+
+	paths, err := nestedSubmodulePaths(r.repo)
+	if err != nil {
+		return err
+	}
+
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	var (
+		wg    sync.WaitGroup
+		errs  = make([]error, len(paths))
+		slots = make(chan struct{}, jobs)
+	)
+
+	for i, path := range paths {
+		wg.Add(1)
+		slots <- struct{}{}
+
+		go func(i int, path string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			errs[i] = r.run(Command{
+				Args: args,
+				Dir:  path,
+			})
+		}(i, path)
+	}
+
+	wg.Wait()
+
+	var failures []string
+	for i, err := range errs {
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", paths[i], err))
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("Command failed in %d submodule(s):\n%s", len(failures), strings.Join(failures, "\n"))
+	}
+
+	return nil
+}
+
+func nestedSubmodulePaths(dir string) ([]string, error) {
+	contents, err := ioutil.ReadFile(filepath.Join(dir, ".gitmodules"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	var paths []string
+	for _, module := range parseGitmodules(string(contents)) {
+		if module.path == "" {
+			continue
+		}
+
+		path := filepath.Join(dir, module.path)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			continue
+		}
+
+		nested, err := nestedSubmodulePaths(path)
+		if err != nil {
+			return nil, err
+		}
+
+		paths = append(paths, path)
+		paths = append(paths, nested...)
+	}
+
+	return paths, nil
+}
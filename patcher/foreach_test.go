@@ -0,0 +1,100 @@
+package patcher_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pivotal-cf/knit/patcher"
+	"github.com/pivotal-cf/knit/patcher/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SubmoduleForeachParallel", func() {
+	var (
+		runner   *fakes.CommandRunner
+		repoPath string
+		r        patcher.Repo
+	)
+
+	BeforeEach(func() {
+		var err error
+		repoPath, err = ioutil.TempDir("", "")
+		Expect(err).NotTo(HaveOccurred())
+
+		for _, dir := range []string{"src/one", "src/two", "src/one/vendor/nested"} {
+			Expect(os.MkdirAll(filepath.Join(repoPath, dir), 0755)).To(Succeed())
+		}
+
+		Expect(ioutil.WriteFile(filepath.Join(repoPath, ".gitmodules"), []byte(`[submodule "src/one"]
+	path = src/one
+[submodule "src/two"]
+	path = src/two
+[submodule "src/missing"]
+	path = src/missing
+`), 0644)).To(Succeed())
+
+		Expect(ioutil.WriteFile(filepath.Join(repoPath, "src", "one", ".gitmodules"), []byte(`[submodule "vendor/nested"]
+	path = vendor/nested
+`), 0644)).To(Succeed())
+
+		runner = &fakes.CommandRunner{}
+		r = patcher.NewRepo(runner, repoPath, "testbot", "foo@example.com")
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(repoPath)).To(Succeed())
+	})
+
+	It("runs the command in every checked out submodule, including nested ones", func() {
+		err := r.SubmoduleForeachParallel([]string{"clean", "-ffd"}, 1)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(runner.RunCall.Receives.Commands).To(Equal([]patcher.Command{
+			patcher.Command{
+				Args: []string{"clean", "-ffd"},
+				Dir:  filepath.Join(repoPath, "src", "one"),
+			},
+			patcher.Command{
+				Args: []string{"clean", "-ffd"},
+				Dir:  filepath.Join(repoPath, "src", "one", "vendor", "nested"),
+			},
+			patcher.Command{
+				Args: []string{"clean", "-ffd"},
+				Dir:  filepath.Join(repoPath, "src", "two"),
+			},
+		}))
+	})
+
+	It("runs the commands concurrently", func() {
+		shRunner, err := patcher.NewCommandRunner("sh", true)
+		Expect(err).NotTo(HaveOccurred())
+
+		err = r.WithRunner(shRunner).SubmoduleForeachParallel([]string{"-c", "touch ran"}, 3)
+		Expect(err).NotTo(HaveOccurred())
+
+		for _, dir := range []string{"src/one", "src/two", "src/one/vendor/nested"} {
+			Expect(filepath.Join(repoPath, dir, "ran")).To(BeAnExistingFile())
+		}
+	})
+
+	Context("when the command fails in some submodules", func() {
+		It("runs it everywhere and reports every failure", func() {
+			runner.RunCall.Stub = func(command patcher.Command) error {
+				if command.Dir != filepath.Join(repoPath, "src", "two") {
+					return errors.New("meow")
+				}
+				return nil
+			}
+
+			err := r.SubmoduleForeachParallel([]string{"fetch"}, 1)
+			Expect(err).To(MatchError("Command failed in 2 submodule(s):\n" +
+				filepath.Join(repoPath, "src", "one") + ": meow\n" +
+				filepath.Join(repoPath, "src", "one", "vendor", "nested") + ": meow"))
+			Expect(runner.RunCall.Count).To(Equal(3))
+		})
+	})
+})
@@ -0,0 +1,58 @@
+package patcher
+
+import (
+	"fmt"
+	"strings"
+)
+
+type FsckError struct {
+	Corrupt  []string
+	Dangling []string
+	Warnings []string
+}
+
+func (e FsckError) Error() string {
+	return fmt.Sprintf("Repository is corrupt:\n%s", strings.Join(e.Corrupt, "\n"))
+}
+
+// Fsck checks the integrity of the repository's objects. Dangling objects are
+// left behind by normal use and are not treated as corruption on their own,
+// but are included in the FsckError when real corruption is found. Neither
+// are the problems git only warns about; see FsckWithWarnings.
+func (r Repo) Fsck() error {
+	_, err := r.FsckWithWarnings()
+	return err
+}
+
+// FsckWithWarnings is Fsck returning git's warnings, e.g. about badly
+// formatted but readable objects, alongside a nil error when nothing is
+// corrupt.
+func (r Repo) FsckWithWarnings() ([]string, error) {
+	output, err := r.combinedOutput(Command{
+		Args: []string{"fsck", "--full", "--no-progress"},
+		Dir:  r.repo,
+	})
+
+	var fsckErr FsckError
+	for _, line := range splitLines(output) {
+		switch {
+		case strings.HasPrefix(line, "dangling "), strings.HasPrefix(line, "unreachable "):
+			fsckErr.Dangling = append(fsckErr.Dangling, line)
+		case strings.HasPrefix(line, "warning"):
+			fsckErr.Warnings = append(fsckErr.Warnings, line)
+		case strings.HasPrefix(line, "Checking "), strings.HasPrefix(line, "notice: "):
+		default:
+			fsckErr.Corrupt = append(fsckErr.Corrupt, line)
+		}
+	}
+
+	if len(fsckErr.Corrupt) > 0 {
+		return fsckErr.Warnings, fsckErr
+	}
+
+	if err != nil {
+		return fsckErr.Warnings, err
+	}
+
+	return fsckErr.Warnings, nil
+}
@@ -0,0 +1,92 @@
+package patcher_test
+
+import (
+	"errors"
+
+	"github.com/pivotal-cf/knit/patcher"
+	"github.com/pivotal-cf/knit/patcher/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Fsck", func() {
+	var (
+		runner *fakes.CommandRunner
+		r      patcher.Repo
+	)
+
+	BeforeEach(func() {
+		runner = &fakes.CommandRunner{}
+		r = patcher.NewRepo(runner, "/some/repo", "testbot", "foo@example.com")
+	})
+
+	It("checks the repository with a full fsck", func() {
+		err := r.Fsck()
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(runner.CombinedOutputCall.Receives.Commands).To(Equal([]patcher.Command{
+			patcher.Command{
+				Args: []string{"fsck", "--full", "--no-progress"},
+				Dir:  "/some/repo",
+			},
+		}))
+	})
+
+	Context("when only dangling objects are found", func() {
+		It("does not return an error", func() {
+			runner.CombinedOutputCall.Returns.Errors = []error{nil}
+			runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte("dangling commit abc123\ndangling blob def456\n")}
+
+			err := r.Fsck()
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	Context("when git only warns", func() {
+		It("returns the warnings without an error", func() {
+			runner.CombinedOutputCall.Returns.Errors = []error{nil}
+			runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte(`Checking object directories
+warning in tree 0123456: zeroPaddedFilemode: contains zero-padded file modes
+dangling commit abc123
+`)}
+
+			warnings, err := r.FsckWithWarnings()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(Equal([]string{"warning in tree 0123456: zeroPaddedFilemode: contains zero-padded file modes"}))
+
+			Expect(r.Fsck()).To(Succeed())
+		})
+	})
+
+	Context("when objects are corrupt or missing", func() {
+		It("returns an FsckError listing them", func() {
+			runner.CombinedOutputCall.Returns.Errors = []error{errors.New("exit status 2")}
+			runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte(`Checking object directories
+error: sha1 mismatch for .git/objects/ab/c123 (expected abc123)
+missing blob 0123456
+dangling commit fedcba9
+`)}
+
+			err := r.Fsck()
+			Expect(err).To(Equal(patcher.FsckError{
+				Corrupt: []string{
+					"error: sha1 mismatch for .git/objects/ab/c123 (expected abc123)",
+					"missing blob 0123456",
+				},
+				Dangling: []string{"dangling commit fedcba9"},
+			}))
+			Expect(err).To(MatchError("Repository is corrupt:\nerror: sha1 mismatch for .git/objects/ab/c123 (expected abc123)\nmissing blob 0123456"))
+		})
+	})
+
+	Context("when fsck fails without reporting anything", func() {
+		It("returns the error", func() {
+			runner.CombinedOutputCall.Returns.Errors = []error{errors.New("meow")}
+			runner.CombinedOutputCall.Returns.Outputs = [][]byte{nil}
+
+			err := r.Fsck()
+			Expect(err).To(MatchError("meow"))
+		})
+	})
+})
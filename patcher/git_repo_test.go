@@ -0,0 +1,76 @@
+package patcher_test
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/pivotal-cf/knit/patcher"
+
+	. "github.com/onsi/gomega"
+)
+
+// gitRepo is a scratch repository for tests that depend on the behaviour of
+// the real git binary.
+type gitRepo struct {
+	path string
+}
+
+func newGitRepo() gitRepo {
+	path, err := ioutil.TempDir("", "")
+	Expect(err).NotTo(HaveOccurred())
+
+	// init -b needs git 2.28 or later.
+	repo := gitRepo{path: path}
+	repo.git("init", "-q", ".")
+	repo.git("symbolic-ref", "HEAD", "refs/heads/master")
+
+	return repo
+}
+
+// git runs git in the repository as Some Author and returns its output.
+func (g gitRepo) git(args ...string) string {
+	cmd := exec.Command("git", append([]string{"-c", "user.name=Some Author", "-c", "user.email=author@example.com"}, args...)...)
+	cmd.Dir = g.path
+	output, err := cmd.CombinedOutput()
+	Expect(err).NotTo(HaveOccurred(), string(output))
+	return string(output)
+}
+
+func (g gitRepo) writeFile(name, contents string) {
+	Expect(os.MkdirAll(filepath.Dir(filepath.Join(g.path, name)), 0755)).To(Succeed())
+	Expect(ioutil.WriteFile(filepath.Join(g.path, name), []byte(contents), 0644)).To(Succeed())
+}
+
+func (g gitRepo) readFile(name string) string {
+	contents, err := ioutil.ReadFile(filepath.Join(g.path, name))
+	Expect(err).NotTo(HaveOccurred())
+	return string(contents)
+}
+
+// commit commits every change in the working tree.
+func (g gitRepo) commit(message string) {
+	g.git("add", "-A", ".")
+	g.git("commit", "-q", "--allow-empty", "-m", message)
+}
+
+// formatPatch writes the commits git format-patch selects with args, empty
+// ones included, as an mbox beside the repository and returns its path.
+func (g gitRepo) formatPatch(args ...string) string {
+	path := g.path + ".patch"
+	Expect(ioutil.WriteFile(path, []byte(g.git(append([]string{"format-patch", "--always", "--stdout"}, args...)...)), 0644)).To(Succeed())
+	return path
+}
+
+// repo returns a Repo running the real git binary in the repository.
+func (g gitRepo) repo() patcher.Repo {
+	runner, err := patcher.NewCommandRunner("git", true)
+	Expect(err).NotTo(HaveOccurred())
+	return patcher.NewRepo(runner, g.path, "testbot", "foo@example.com")
+}
+
+func (g gitRepo) remove() {
+	Expect(os.RemoveAll(g.path)).To(Succeed())
+	os.Remove(g.path + ".patch")
+}
@@ -0,0 +1,195 @@
+package patcher
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const submoduleSectionRegex = `^\[submodule "(.*)"\]$`
+
+type gitmodule struct {
+	name    string
+	path    string
+	url     string
+	branch  string
+	update  string
+	ignore  string
+	shallow bool
+	depth   int
+}
+
+// SubmoduleConfig is one [submodule] section of .gitmodules.
+type SubmoduleConfig struct {
+	Name   string
+	Path   string
+	URL    string
+	Branch string
+	Update string
+	Ignore string
+
+	// Shallow and Depth are set by SetSubmoduleShallow. Depth is knit's own
+	// key; git itself only knows shallow, which it takes to mean depth 1.
+	Shallow bool
+	Depth   int
+}
+
+// GetSubmoduleConfig parses every section of .gitmodules, whether or not the
+// submodule is checked out.
+func (r Repo) GetSubmoduleConfig() ([]SubmoduleConfig, error) {
+	modules, err := r.gitmodules()
+	if err != nil {
+		return nil, err
+	}
+
+	var configs []SubmoduleConfig
+	for _, module := range modules {
+		configs = append(configs, SubmoduleConfig{
+			Name:    module.name,
+			Path:    module.path,
+			URL:     module.url,
+			Branch:  module.branch,
+			Update:  module.update,
+			Ignore:  module.ignore,
+			Shallow: module.shallow,
+			Depth:   module.depth,
+		})
+	}
+
+	return configs, nil
+}
+
+// ListSubmodulesRecursive is GetSubmoduleConfig extended into every checked
+// out submodule's own .gitmodules. Paths are relative to the superproject.
+func (r Repo) ListSubmodulesRecursive() ([]SubmoduleConfig, error) {
+	if err := r.requireLocal("ListSubmodulesRecursive"); err != nil {
+		return nil, err
+	}
+
+	return listSubmodulesRecursive(r.repo, "", map[string]bool{})
+}
+
+func listSubmodulesRecursive(root, prefix string, visited map[string]bool) ([]SubmoduleConfig, error) {
+	dir := filepath.Join(root, prefix)
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = resolved
+	}
+
+	if visited[dir] {
+		return nil, nil
+	}
+	visited[dir] = true
+
+	contents, err := ioutil.ReadFile(filepath.Join(dir, ".gitmodules"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	var configs []SubmoduleConfig
+	for _, module := range parseGitmodules(string(contents)) {
+		if module.path == "" {
+			continue
+		}
+
+		path := filepath.Join(prefix, module.path)
+		configs = append(configs, SubmoduleConfig{
+			Name:    module.name,
+			Path:    path,
+			URL:     module.url,
+			Branch:  module.branch,
+			Update:  module.update,
+			Ignore:  module.ignore,
+			Shallow: module.shallow,
+			Depth:   module.depth,
+		})
+
+		if _, err := os.Stat(filepath.Join(root, path)); err != nil {
+			continue
+		}
+
+		nested, err := listSubmodulesRecursive(root, path, visited)
+		if err != nil {
+			return nil, err
+		}
+
+		configs = append(configs, nested...)
+	}
+
+	return configs, nil
+}
+
+func parseGitmodules(contents string) []gitmodule {
+	var modules []gitmodule
+	section := regexp.MustCompile(submoduleSectionRegex)
+
+	for _, line := range strings.Split(contents, "\n") {
+		line = strings.TrimSpace(line)
+
+		if matches := section.FindStringSubmatch(line); matches != nil {
+			modules = append(modules, gitmodule{name: matches[1]})
+			continue
+		}
+
+		if len(modules) == 0 {
+			continue
+		}
+
+		key, value, ok := gitmodulesEntry(line)
+		if !ok {
+			continue
+		}
+
+		module := &modules[len(modules)-1]
+		switch key {
+		case "path":
+			module.path = value
+		case "url":
+			module.url = value
+		case "branch":
+			module.branch = value
+		case "update":
+			module.update = value
+		case "ignore":
+			module.ignore = value
+		case "shallow":
+			module.shallow, _ = strconv.ParseBool(value)
+		case "depth":
+			module.depth, _ = strconv.Atoi(value)
+		}
+	}
+
+	return modules
+}
+
+func gitmodulesEntry(line string) (string, string, bool) {
+	index := strings.Index(line, "=")
+	if index == -1 {
+		return "", "", false
+	}
+
+	key := strings.ToLower(strings.TrimSpace(line[:index]))
+	value := strings.TrimSpace(line[index+1:])
+
+	return key, value, true
+}
+
+// fetchDepth is the history depth a shallow submodule is fetched with, or 0
+// when the submodule is not shallow.
+func (m gitmodule) fetchDepth() int {
+	if !m.shallow {
+		return 0
+	}
+
+	if m.depth > 0 {
+		return m.depth
+	}
+
+	return 1
+}
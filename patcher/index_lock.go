@@ -0,0 +1,114 @@
+package patcher
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	indexLockRegex        = `Unable to create '(.*index\.lock)'`
+	indexLockPollInterval = 50 * time.Millisecond
+)
+
+// WithIndexLockWait returns a copy of the repo that retries a git command
+// which failed with git's "Unable to create ... index.lock" message, once the
+// lock is released, for up to timeout. Commands that can stop partway and
+// leave state behind, like am or rebase, are never retried, and neither are
+// commands whose input cannot be rewound.
+func (r Repo) WithIndexLockWait(timeout time.Duration) Repo {
+	r.indexLockTimeout = timeout
+	return r
+}
+
+func (r Repo) canRetryOnIndexLock(command Command) bool {
+	if r.indexLockTimeout <= 0 || remoteHost(r.runner) != "" {
+		return false
+	}
+
+	if _, ok := command.Stdin.(io.Seeker); command.Stdin != nil && !ok {
+		return false
+	}
+
+	args := command.Args
+	for len(args) >= 2 && args[0] == "-c" {
+		args = args[2:]
+	}
+
+	if len(args) == 0 {
+		return false
+	}
+
+	// A commit that cannot take the lock has written nothing yet, so it is
+	// safe to run again; these may have applied part of their work first.
+	switch args[0] {
+	case "am", "rebase", "cherry-pick", "revert", "merge", "pull", "stash", "submodule":
+		return false
+	}
+
+	return true
+}
+
+// waitForIndexLock waits for the lock named in git's output to be released,
+// reporting false when the output is not about the index lock or the lock is
+// still held at the deadline.
+func (r Repo) waitForIndexLock(output []byte, deadline *time.Time) bool {
+	matches := regexp.MustCompile(indexLockRegex).FindSubmatch(output)
+	if matches == nil {
+		return false
+	}
+
+	if deadline.IsZero() {
+		*deadline = time.Now().Add(r.indexLockTimeout)
+	}
+
+	lockPath := string(matches[1])
+	for time.Now().Before(*deadline) {
+		if _, err := os.Stat(lockPath); os.IsNotExist(err) {
+			return true
+		}
+
+		time.Sleep(indexLockPollInterval)
+	}
+
+	return false
+}
+
+// stdinRewinder returns a function that moves stdin back to where it is now,
+// so that a retried command reads the same input.
+func stdinRewinder(stdin io.Reader) func() error {
+	seeker, ok := stdin.(io.Seeker)
+	if !ok {
+		return func() error { return nil }
+	}
+
+	offset, err := seeker.Seek(0, io.SeekCurrent)
+	return func() error {
+		if err != nil {
+			return err
+		}
+
+		_, err := seeker.Seek(offset, io.SeekStart)
+		return err
+	}
+}
+
+// gitDirPath returns the git directory of the repository checked out at dir,
+// following the gitdir file of a submodule or worktree.
+func gitDirPath(dir string) string {
+	gitDir := filepath.Join(dir, ".git")
+
+	contents, err := ioutil.ReadFile(gitDir)
+	if err == nil && strings.HasPrefix(string(contents), "gitdir: ") {
+		gitDir = strings.TrimSpace(strings.TrimPrefix(string(contents), "gitdir: "))
+		if !filepath.IsAbs(gitDir) {
+			gitDir = filepath.Join(dir, gitDir)
+		}
+	}
+
+	return gitDir
+}
@@ -0,0 +1,156 @@
+package patcher_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pivotal-cf/knit/patcher"
+	"github.com/pivotal-cf/knit/patcher/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("WithIndexLockWait", func() {
+	var (
+		runner   *fakes.CommandRunner
+		repoPath string
+		lockPath string
+		r        patcher.Repo
+	)
+
+	BeforeEach(func() {
+		var err error
+		repoPath, err = ioutil.TempDir("", "")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(os.Mkdir(filepath.Join(repoPath, ".git"), 0755)).To(Succeed())
+		lockPath = filepath.Join(repoPath, ".git", "index.lock")
+		Expect(ioutil.WriteFile(lockPath, nil, 0644)).To(Succeed())
+
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			os.Remove(lockPath)
+		}()
+
+		runner = &fakes.CommandRunner{}
+		r = patcher.NewRepo(runner, repoPath, "testbot", "foo@example.com").WithIndexLockWait(5 * time.Second)
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(repoPath)).To(Succeed())
+	})
+
+	lockMessage := func() string {
+		return fmt.Sprintf("fatal: Unable to create '%s': File exists.\n", lockPath)
+	}
+
+	It("retries a command once the index lock is released", func() {
+		runner.RunCall.Stub = func(command patcher.Command) error {
+			if _, err := os.Stat(lockPath); err == nil {
+				fmt.Fprint(command.Stderr, lockMessage())
+				return errors.New("exit status 128")
+			}
+			return nil
+		}
+
+		err := r.StagePaths("some-file")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(runner.RunCall.Count).To(BeNumerically(">", 1))
+	})
+
+	It("does not retry a streamed command that failed for another reason", func() {
+		runner.RunCall.Stub = func(command patcher.Command) error {
+			fmt.Fprint(command.Stderr, "fatal: pathspec 'some-file' did not match any files\n")
+			return errors.New("exit status 128")
+		}
+
+		err := r.StagePaths("some-file")
+		Expect(err).To(MatchError("exit status 128"))
+		Expect(runner.RunCall.Count).To(Equal(1))
+	})
+
+	It("does not retry commands that may have stopped partway", func() {
+		runner.RunCall.Stub = func(command patcher.Command) error {
+			Expect(command.Stderr).To(BeNil())
+			return errors.New("exit status 128")
+		}
+
+		err := r.ApplyPatch("some.patch")
+		Expect(err).To(MatchError("exit status 128"))
+		Expect(runner.RunCall.Count).To(Equal(1))
+	})
+
+	It("rewinds the input of a retried command", func() {
+		var inputs []string
+		runner.CombinedOutputCall.Stub = func(command patcher.Command) ([]byte, error) {
+			input, err := ioutil.ReadAll(command.Stdin)
+			Expect(err).NotTo(HaveOccurred())
+			inputs = append(inputs, string(input))
+
+			if _, err := os.Stat(lockPath); err == nil {
+				return []byte(lockMessage()), errors.New("exit status 128")
+			}
+			return []byte("abc123\n"), nil
+		}
+
+		sha, err := r.HashObject(strings.NewReader("some content"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sha).To(Equal("abc123"))
+		Expect(len(inputs)).To(BeNumerically(">", 1))
+		for _, input := range inputs {
+			Expect(input).To(Equal("some content"))
+		}
+	})
+
+	It("does not retry a command whose input cannot be rewound", func() {
+		runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte(lockMessage())}
+		runner.CombinedOutputCall.Returns.Errors = []error{errors.New("exit status 128")}
+
+		_, err := r.HashObject(io.MultiReader(strings.NewReader("some content")))
+		Expect(err).To(MatchError("exit status 128"))
+		Expect(runner.CombinedOutputCall.Count).To(Equal(1))
+	})
+
+	It("retries captured commands that report the index lock", func() {
+		runner.CombinedOutputCall.Stub = func(patcher.Command) ([]byte, error) {
+			if _, err := os.Stat(lockPath); err == nil {
+				return []byte(lockMessage()), errors.New("exit status 128")
+			}
+			return []byte("some-file\n"), nil
+		}
+
+		conflicts, err := r.Conflicts()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conflicts).To(Equal([]string{"some-file"}))
+	})
+
+	It("does not retry other failures", func() {
+		runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte("fatal: bad revision\n")}
+		runner.CombinedOutputCall.Returns.Errors = []error{errors.New("exit status 128")}
+
+		_, err := r.Conflicts()
+		Expect(err).To(MatchError("exit status 128"))
+		Expect(runner.CombinedOutputCall.Count).To(Equal(1))
+	})
+
+	Context("when the lock is held past the timeout", func() {
+		It("returns the original error", func() {
+			r = r.WithIndexLockWait(10 * time.Millisecond)
+			runner.RunCall.Stub = func(command patcher.Command) error {
+				fmt.Fprint(command.Stderr, lockMessage())
+				return errors.New("exit status 128")
+			}
+
+			err := r.StagePaths("some-file")
+			Expect(err).To(MatchError("exit status 128"))
+			Expect(runner.RunCall.Count).To(Equal(1))
+		})
+	})
+})
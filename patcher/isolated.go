@@ -0,0 +1,91 @@
+package patcher
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type IsolatedApplyResult struct {
+	Applied   bool
+	Conflicts []string
+	DiffStat  string
+}
+
+// ApplyPatchIsolated trial-applies patch on top of ref in a temporary
+// worktree, leaving the main checkout untouched. The worktree is removed
+// afterwards whatever the outcome. A patch that conflicts is reported in the
+// result rather than as an error.
+func (r Repo) ApplyPatchIsolated(ref, patch string) (IsolatedApplyResult, error) {
+	var result IsolatedApplyResult
+	err := r.withPatchHooks("ApplyPatchIsolated", patch, func() error {
+		var err error
+		result, err = r.applyPatchIsolated(ref, patch)
+		return err
+	})
+
+	return result, err
+}
+
+func (r Repo) applyPatchIsolated(ref, patch string) (IsolatedApplyResult, error) {
+	if err := r.requireLocal("ApplyPatchIsolated"); err != nil {
+		return IsolatedApplyResult{}, err
+	}
+
+	// The patch is applied from the worktree, so a relative path is resolved
+	// against the repository as it would be for ApplyPatch.
+	if !filepath.IsAbs(patch) {
+		patch = filepath.Join(r.repo, patch)
+	}
+
+	patch, err := filepath.Abs(patch)
+	if err != nil {
+		return IsolatedApplyResult{}, err
+	}
+
+	worktree, err := ioutil.TempDir(r.tempDir, "knit-worktree")
+	if err != nil {
+		return IsolatedApplyResult{}, err
+	}
+	defer os.RemoveAll(worktree)
+
+	output, err := r.combinedOutput(Command{
+		Args: []string{"worktree", "add", "--detach", worktree, ref},
+		Dir:  r.repo,
+	})
+	if err != nil {
+		return IsolatedApplyResult{}, fmt.Errorf("Could not create a worktree at %q: %s\n%s", ref, err, strings.TrimSpace(string(output)))
+	}
+	defer r.combinedOutput(Command{
+		Args: []string{"worktree", "remove", "--force", worktree},
+		Dir:  r.repo,
+	})
+
+	output, err = r.combinedOutput(Command{
+		Args: r.withIdentity("am", "--3way", patch),
+		Dir:  worktree,
+	})
+	if err != nil {
+		conflicts, conflictsErr := r.combinedOutput(Command{
+			Args: []string{"diff", "--name-only", "--diff-filter=U"},
+			Dir:  worktree,
+		})
+		if conflictsErr != nil || len(splitLines(conflicts)) == 0 {
+			return IsolatedApplyResult{}, fmt.Errorf("Patch %q could not be applied to %s: %s\n%s", patch, ref, err, strings.TrimSpace(string(output)))
+		}
+
+		return IsolatedApplyResult{Conflicts: splitLines(conflicts)}, nil
+	}
+
+	diffStat, err := r.combinedOutput(Command{
+		Args: []string{"diff", "--stat", ref, "HEAD"},
+		Dir:  worktree,
+	})
+	if err != nil {
+		return IsolatedApplyResult{}, err
+	}
+
+	return IsolatedApplyResult{Applied: true, DiffStat: strings.TrimRight(string(diffStat), "\n")}, nil
+}
@@ -0,0 +1,135 @@
+package patcher_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pivotal-cf/knit/patcher"
+	"github.com/pivotal-cf/knit/patcher/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ApplyPatchIsolated", func() {
+	var (
+		runner   *fakes.CommandRunner
+		tempDir  string
+		worktree string
+		outputs  map[string]string
+		failures map[string]error
+		r        patcher.Repo
+	)
+
+	BeforeEach(func() {
+		var err error
+		tempDir, err = ioutil.TempDir("", "")
+		Expect(err).NotTo(HaveOccurred())
+
+		outputs = map[string]string{
+			"diff": " config/app.yml | 2 +-\n 1 file changed, 1 insertion(+), 1 deletion(-)\n",
+		}
+		failures = map[string]error{}
+
+		runner = &fakes.CommandRunner{}
+		runner.CombinedOutputCall.Stub = func(command patcher.Command) ([]byte, error) {
+			if command.Args[0] == "worktree" && command.Args[1] == "add" {
+				worktree = command.Args[3]
+			}
+
+			name := command.Args[0]
+			if name == "-c" {
+				name = command.Args[4]
+			}
+
+			return []byte(outputs[name]), failures[name]
+		}
+
+		r = patcher.NewRepo(runner, "/some/repo", "testbot", "foo@example.com").WithTempDir(tempDir)
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(tempDir)).To(Succeed())
+	})
+
+	It("applies the patch in a temporary worktree and removes it", func() {
+		result, err := r.ApplyPatchIsolated("1.9.2", "/patches/some.patch")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(patcher.IsolatedApplyResult{
+			Applied:  true,
+			DiffStat: " config/app.yml | 2 +-\n 1 file changed, 1 insertion(+), 1 deletion(-)",
+		}))
+
+		Expect(filepath.Dir(worktree)).To(Equal(tempDir))
+		Expect(runner.CombinedOutputCall.Receives.Commands).To(Equal([]patcher.Command{
+			patcher.Command{
+				Args: []string{"worktree", "add", "--detach", worktree, "1.9.2"},
+				Dir:  "/some/repo",
+			},
+			patcher.Command{
+				Args: []string{"-c", "user.name=testbot", "-c", "user.email=foo@example.com", "am", "--3way", "/patches/some.patch"},
+				Dir:  worktree,
+			},
+			patcher.Command{
+				Args: []string{"diff", "--stat", "1.9.2", "HEAD"},
+				Dir:  worktree,
+			},
+			patcher.Command{
+				Args: []string{"worktree", "remove", "--force", worktree},
+				Dir:  "/some/repo",
+			},
+		}))
+		Expect(worktree).NotTo(BeADirectory())
+		Expect(runner.RunCall.Receives.Commands).To(BeEmpty())
+	})
+
+	Context("when the patch path is relative", func() {
+		It("resolves it against the repository", func() {
+			_, err := r.ApplyPatchIsolated("1.9.2", "patches/some.patch")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(runner.CombinedOutputCall.Receives.Commands[1].Args).To(Equal([]string{"-c", "user.name=testbot", "-c", "user.email=foo@example.com", "am", "--3way", "/some/repo/patches/some.patch"}))
+		})
+	})
+
+	Context("when the patch conflicts", func() {
+		It("reports the conflicts and still removes the worktree", func() {
+			failures["am"] = errors.New("exit status 1")
+			outputs["diff"] = "config/app.yml\n"
+
+			result, err := r.ApplyPatchIsolated("1.9.2", "/patches/some.patch")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal(patcher.IsolatedApplyResult{Conflicts: []string{"config/app.yml"}}))
+
+			commands := runner.CombinedOutputCall.Receives.Commands
+			Expect(commands[len(commands)-1].Args).To(Equal([]string{"worktree", "remove", "--force", worktree}))
+		})
+	})
+
+	Context("when the patch does not apply at all", func() {
+		It("returns an error and still removes the worktree", func() {
+			failures["am"] = errors.New("exit status 128")
+			outputs["am"] = "error: corrupt patch at line 6\n"
+			outputs["diff"] = ""
+
+			_, err := r.ApplyPatchIsolated("1.9.2", "/patches/some.patch")
+			Expect(err).To(MatchError("Patch \"/patches/some.patch\" could not be applied to 1.9.2: exit status 128\nerror: corrupt patch at line 6"))
+
+			commands := runner.CombinedOutputCall.Receives.Commands
+			Expect(commands[len(commands)-1].Args).To(Equal([]string{"worktree", "remove", "--force", worktree}))
+		})
+	})
+
+	Context("when the worktree cannot be created", func() {
+		It("returns an error", func() {
+			failures["worktree"] = errors.New("exit status 128")
+			outputs["worktree"] = "fatal: invalid reference: nope\n"
+
+			_, err := r.ApplyPatchIsolated("nope", "/patches/some.patch")
+			Expect(err).To(MatchError("Could not create a worktree at \"nope\": exit status 128\nfatal: invalid reference: nope"))
+			Expect(runner.CombinedOutputCall.Receives.Commands).To(HaveLen(1))
+		})
+	})
+})
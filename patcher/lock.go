@@ -0,0 +1,63 @@
+package patcher
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const lockFile = "knit.lock"
+
+var errLockHeld = errors.New("lock is held")
+
+// Lock takes an exclusive lock on a file in the repository's git directory so
+// that two knit runs cannot interleave their git commands on one checkout. It
+// fails immediately if another run holds the lock.
+func (r Repo) Lock() (func(), error) {
+	if err := r.requireLocal("Lock"); err != nil {
+		return nil, err
+	}
+
+	output, err := r.combinedOutput(Command{
+		Args: []string{"rev-parse", "--git-dir"},
+		Dir:  r.repo,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	gitDir := strings.TrimSpace(string(output))
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(r.repo, gitDir)
+	}
+
+	file, err := os.OpenFile(filepath.Join(gitDir, lockFile), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := lockExclusive(file)
+	if err != nil {
+		file.Close()
+
+		if err == errLockHeld {
+			return nil, fmt.Errorf("Repository %q is locked by another knit run", r.repo)
+		}
+
+		return nil, err
+	}
+
+	return func() {
+		unlock()
+		file.Close()
+	}, nil
+}
+
+// WithAutoLock makes every mutating operation hold the lock taken by Lock
+// while it runs.
+func (r Repo) WithAutoLock() Repo {
+	r.autoLock = true
+	return r
+}
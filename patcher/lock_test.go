@@ -0,0 +1,100 @@
+package patcher_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pivotal-cf/knit/patcher"
+	"github.com/pivotal-cf/knit/patcher/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Lock", func() {
+	var (
+		runner   *fakes.CommandRunner
+		repoPath string
+		r        patcher.Repo
+	)
+
+	BeforeEach(func() {
+		var err error
+		repoPath, err = ioutil.TempDir("", "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.Mkdir(filepath.Join(repoPath, ".git"), 0755)).To(Succeed())
+
+		runner = &fakes.CommandRunner{}
+		runner.CombinedOutputCall.Stub = func(patcher.Command) ([]byte, error) {
+			return []byte(".git\n"), nil
+		}
+
+		r = patcher.NewRepo(runner, repoPath, "testbot", "foo@example.com")
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(repoPath)).To(Succeed())
+	})
+
+	It("creates a lock file in the git directory", func() {
+		unlock, err := r.Lock()
+		Expect(err).NotTo(HaveOccurred())
+		defer unlock()
+
+		Expect(filepath.Join(repoPath, ".git", "knit.lock")).To(BeAnExistingFile())
+	})
+
+	Context("when another run holds the lock", func() {
+		It("fails fast", func() {
+			unlock, err := r.Lock()
+			Expect(err).NotTo(HaveOccurred())
+			defer unlock()
+
+			_, err = r.Lock()
+			Expect(err).To(MatchError(ContainSubstring("is locked by another knit run")))
+		})
+	})
+
+	Context("when the lock is released", func() {
+		It("can be taken again", func() {
+			unlock, err := r.Lock()
+			Expect(err).NotTo(HaveOccurred())
+			unlock()
+
+			unlock, err = r.Lock()
+			Expect(err).NotTo(HaveOccurred())
+			unlock()
+		})
+	})
+
+	Describe("WithAutoLock", func() {
+		It("holds the lock while mutating operations run", func() {
+			var lockErr error
+			runner.RunCall.Stub = func(patcher.Command) error {
+				_, lockErr = r.Lock()
+				return nil
+			}
+
+			err := r.WithAutoLock().ApplyPatch("some.patch")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lockErr).To(MatchError(ContainSubstring("is locked by another knit run")))
+
+			unlock, err := r.Lock()
+			Expect(err).NotTo(HaveOccurred())
+			unlock()
+		})
+
+		Context("when the lock is held elsewhere", func() {
+			It("does not run the operation", func() {
+				unlock, err := r.Lock()
+				Expect(err).NotTo(HaveOccurred())
+				defer unlock()
+
+				err = r.WithAutoLock().ApplyPatch("some.patch")
+				Expect(err).To(MatchError(ContainSubstring("is locked by another knit run")))
+				Expect(runner.RunCall.Receives.Commands).To(BeEmpty())
+			})
+		})
+	})
+})
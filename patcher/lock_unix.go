@@ -0,0 +1,23 @@
+//go:build !windows
+// +build !windows
+
+package patcher
+
+import (
+	"os"
+	"syscall"
+)
+
+func lockExclusive(file *os.File) (func(), error) {
+	err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return nil, errLockHeld
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return func() {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+	}, nil
+}
@@ -0,0 +1,13 @@
+//go:build windows
+// +build windows
+
+package patcher
+
+import (
+	"errors"
+	"os"
+)
+
+func lockExclusive(file *os.File) (func(), error) {
+	return nil, errors.New("Locking a repository is not supported on Windows")
+}
@@ -0,0 +1,140 @@
+package patcher
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type Commit struct {
+	SHA     string
+	Subject string
+}
+
+type NotAncestorError struct {
+	From string
+	To   string
+}
+
+func (e NotAncestorError) Error() string {
+	return fmt.Sprintf("%s is not an ancestor of %s", e.From, e.To)
+}
+
+// SubmoduleLog returns the commits a bump of the submodule from fromSHA to
+// toSHA would bring in, newest first.
+func (r Repo) SubmoduleLog(path, fromSHA, toSHA string) ([]Commit, error) {
+	pathToSubmodule := filepath.Join(r.repo, path)
+
+	output, err := r.combinedOutput(Command{
+		Args: []string{"rev-list", fmt.Sprintf("%s..%s", toSHA, fromSHA)},
+		Dir:  pathToSubmodule,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(splitLines(output)) > 0 {
+		return nil, NotAncestorError{From: fromSHA, To: toSHA}
+	}
+
+	output, err = r.combinedOutput(Command{
+		Args: []string{"log", "--oneline", "--no-decorate", fmt.Sprintf("%s..%s", fromSHA, toSHA)},
+		Dir:  pathToSubmodule,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return parseOnelineLog(output), nil
+}
+
+// FirstParentLog returns the superproject commits between fromRef and toRef,
+// newest first, following only first parents so that merged-in upstream
+// history is left out.
+func (r Repo) FirstParentLog(fromRef, toRef string) ([]Commit, error) {
+	output, err := r.combinedOutput(Command{
+		Args: []string{"log", "--first-parent", "--pretty=format:%H %s", fmt.Sprintf("%s..%s", fromRef, toRef)},
+		Dir:  r.repo,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return parseOnelineLog(output), nil
+}
+
+// SubmoduleCommitsAffecting returns the commits in the submodule at path that
+// touched file, newest first, e.g. to find the fix for a CVE to bump to.
+func (r Repo) SubmoduleCommitsAffecting(path, file string) ([]Commit, error) {
+	initialized, err := r.IsSubmoduleInitialized(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !initialized {
+		return nil, fmt.Errorf("Submodule %q is not initialized", path)
+	}
+
+	output, err := r.combinedOutput(Command{
+		Args: []string{"log", "--oneline", "--no-decorate", "--", file},
+		Dir:  filepath.Join(r.repo, path),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return parseOnelineLog(output), nil
+}
+
+func parseOnelineLog(output []byte) []Commit {
+	var commits []Commit
+	for _, line := range splitLines(output) {
+		parts := strings.SplitN(line, " ", 2)
+
+		commit := Commit{SHA: parts[0]}
+		if len(parts) == 2 {
+			commit.Subject = parts[1]
+		}
+
+		commits = append(commits, commit)
+	}
+
+	return commits
+}
+
+type ReflogEntry struct {
+	SHA     string
+	Action  string
+	Message string
+}
+
+// Reflog returns the last n moves of HEAD, newest first. Action is the kind
+// of move git recorded, e.g. "checkout" or "reset", and Message the rest.
+func (r Repo) Reflog(n int) ([]ReflogEntry, error) {
+	output, err := r.combinedOutput(Command{
+		Args: []string{"reflog", "-n", strconv.Itoa(n), "--format=%H %gs"},
+		Dir:  r.repo,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []ReflogEntry
+	for _, line := range splitLines(output) {
+		fields := strings.SplitN(line, " ", 2)
+
+		entry := ReflogEntry{SHA: fields[0]}
+		if len(fields) == 2 {
+			entry.Message = fields[1]
+			if parts := strings.SplitN(fields[1], ": ", 2); len(parts) == 2 {
+				entry.Action = parts[0]
+				entry.Message = parts[1]
+			}
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
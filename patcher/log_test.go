@@ -0,0 +1,205 @@
+package patcher_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pivotal-cf/knit/patcher"
+	"github.com/pivotal-cf/knit/patcher/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Log", func() {
+	var (
+		runner *fakes.CommandRunner
+		r      patcher.Repo
+	)
+
+	BeforeEach(func() {
+		runner = &fakes.CommandRunner{}
+		r = patcher.NewRepo(runner, "/some/repo", "testbot", "foo@example.com")
+	})
+
+	Describe("SubmoduleLog", func() {
+		It("returns the commits introduced between the shas", func() {
+			runner.CombinedOutputCall.Returns.Errors = []error{nil, nil}
+			runner.CombinedOutputCall.Returns.Outputs = [][]byte{
+				[]byte(""),
+				[]byte("bbbbbbb second change\naaaaaaa first change\n"),
+			}
+
+			commits, err := r.SubmoduleLog("src/some/path", "from-sha", "to-sha")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(commits).To(Equal([]patcher.Commit{
+				{SHA: "bbbbbbb", Subject: "second change"},
+				{SHA: "aaaaaaa", Subject: "first change"},
+			}))
+
+			Expect(runner.CombinedOutputCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{"rev-list", "to-sha..from-sha"},
+					Dir:  filepath.Join("/some/repo", "src/some/path"),
+				},
+				patcher.Command{
+					Args: []string{"log", "--oneline", "--no-decorate", "from-sha..to-sha"},
+					Dir:  filepath.Join("/some/repo", "src/some/path"),
+				},
+			}))
+		})
+
+		Context("when the from sha is not an ancestor of the to sha", func() {
+			It("returns a NotAncestorError", func() {
+				runner.CombinedOutputCall.Returns.Errors = []error{nil}
+				runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte("ccccccc\n")}
+
+				_, err := r.SubmoduleLog("src/some/path", "from-sha", "to-sha")
+				Expect(err).To(Equal(patcher.NotAncestorError{From: "from-sha", To: "to-sha"}))
+				Expect(err).To(MatchError("from-sha is not an ancestor of to-sha"))
+			})
+		})
+
+		Context("when git fails", func() {
+			It("returns an error", func() {
+				runner.CombinedOutputCall.Returns.Errors = []error{errors.New("meow")}
+				runner.CombinedOutputCall.Returns.Outputs = [][]byte{nil}
+
+				_, err := r.SubmoduleLog("src/some/path", "from-sha", "to-sha")
+				Expect(err).To(MatchError("meow"))
+			})
+		})
+	})
+
+	Describe("FirstParentLog", func() {
+		It("returns the first-parent commits between the refs", func() {
+			runner.CombinedOutputCall.Returns.Errors = []error{nil}
+			runner.CombinedOutputCall.Returns.Outputs = [][]byte{
+				[]byte("bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb Knit bump of src/some/path\naaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa Knit patch of src/other"),
+			}
+
+			commits, err := r.FirstParentLog("from-ref", "to-ref")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(commits).To(Equal([]patcher.Commit{
+				{SHA: "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb", Subject: "Knit bump of src/some/path"},
+				{SHA: "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", Subject: "Knit patch of src/other"},
+			}))
+
+			Expect(runner.CombinedOutputCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{"log", "--first-parent", "--pretty=format:%H %s", "from-ref..to-ref"},
+					Dir:  "/some/repo",
+				},
+			}))
+		})
+
+		Context("when git fails", func() {
+			It("returns an error", func() {
+				runner.CombinedOutputCall.Returns.Errors = []error{errors.New("meow")}
+				runner.CombinedOutputCall.Returns.Outputs = [][]byte{nil}
+
+				_, err := r.FirstParentLog("from-ref", "to-ref")
+				Expect(err).To(MatchError("meow"))
+			})
+		})
+	})
+
+	Describe("SubmoduleCommitsAffecting", func() {
+		var repoPath string
+
+		BeforeEach(func() {
+			var err error
+			repoPath, err = ioutil.TempDir("", "")
+			Expect(err).NotTo(HaveOccurred())
+
+			err = os.MkdirAll(filepath.Join(repoPath, "src", "some-submodule"), 0755)
+			Expect(err).NotTo(HaveOccurred())
+
+			err = ioutil.WriteFile(filepath.Join(repoPath, "src", "some-submodule", ".git"), []byte("gitdir: ../../.git/modules/some-submodule"), 0644)
+			Expect(err).NotTo(HaveOccurred())
+
+			r = patcher.NewRepo(runner, repoPath, "testbot", "foo@example.com")
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(repoPath)).To(Succeed())
+		})
+
+		It("returns the submodule commits that touched the file", func() {
+			runner.CombinedOutputCall.Returns.Errors = []error{nil, nil}
+			runner.CombinedOutputCall.Returns.Outputs = [][]byte{
+				[]byte(" abc123 src/some-submodule (heads/master)\n"),
+				[]byte("bbbbbbb Fix CVE-2017-1000\naaaaaaa Add parser\n"),
+			}
+
+			commits, err := r.SubmoduleCommitsAffecting("src/some-submodule", "lib/parser.go")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(commits).To(Equal([]patcher.Commit{
+				{SHA: "bbbbbbb", Subject: "Fix CVE-2017-1000"},
+				{SHA: "aaaaaaa", Subject: "Add parser"},
+			}))
+
+			Expect(runner.CombinedOutputCall.Receives.Commands[1]).To(Equal(patcher.Command{
+				Args: []string{"log", "--oneline", "--no-decorate", "--", "lib/parser.go"},
+				Dir:  filepath.Join(repoPath, "src", "some-submodule"),
+			}))
+		})
+
+		Context("when the submodule is not initialized", func() {
+			It("returns an error", func() {
+				runner.CombinedOutputCall.Returns.Errors = []error{nil}
+				runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte("-abc123 src/some-submodule\n")}
+
+				_, err := r.SubmoduleCommitsAffecting("src/some-submodule", "lib/parser.go")
+				Expect(err).To(MatchError(`Submodule "src/some-submodule" is not initialized`))
+			})
+		})
+
+		Context("when git log fails", func() {
+			It("returns an error", func() {
+				runner.CombinedOutputCall.Returns.Errors = []error{nil, errors.New("meow")}
+				runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte(" abc123 src/some-submodule\n"), nil}
+
+				_, err := r.SubmoduleCommitsAffecting("src/some-submodule", "lib/parser.go")
+				Expect(err).To(MatchError("meow"))
+			})
+		})
+	})
+
+	Describe("Reflog", func() {
+		It("returns the recent moves of HEAD", func() {
+			runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte(`aaa111 reset: moving to HEAD~1
+bbb222 commit: Knit patch of src/some-submodule
+ccc333 checkout: moving from master to 1.9.2
+`)}
+			runner.CombinedOutputCall.Returns.Errors = []error{nil}
+
+			entries, err := r.Reflog(3)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entries).To(Equal([]patcher.ReflogEntry{
+				{SHA: "aaa111", Action: "reset", Message: "moving to HEAD~1"},
+				{SHA: "bbb222", Action: "commit", Message: "Knit patch of src/some-submodule"},
+				{SHA: "ccc333", Action: "checkout", Message: "moving from master to 1.9.2"},
+			}))
+
+			Expect(runner.CombinedOutputCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{"reflog", "-n", "3", "--format=%H %gs"},
+					Dir:  "/some/repo",
+				},
+			}))
+		})
+
+		Context("when the reflog cannot be read", func() {
+			It("returns an error", func() {
+				runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte("")}
+				runner.CombinedOutputCall.Returns.Errors = []error{errors.New("meow")}
+
+				_, err := r.Reflog(3)
+				Expect(err).To(MatchError("meow"))
+			})
+		})
+	})
+})
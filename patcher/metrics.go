@@ -0,0 +1,52 @@
+package patcher
+
+import (
+	"fmt"
+	"time"
+	"unicode"
+)
+
+// Metrics receives a sample for every mutating Repo operation. Operation
+// names are converted to snake case, so ApplyPatch reports:
+//
+//	knit_apply_patch_total             incremented when the operation succeeds
+//	knit_apply_patch_failures_total    incremented when the operation fails
+//	knit_apply_patch_duration_seconds  observed with the operation's duration
+//
+// The operation names are the same ones passed to hooks.
+type Metrics interface {
+	Inc(name string)
+	Observe(name string, v float64)
+}
+
+func (r Repo) WithMetrics(metrics Metrics) Repo {
+	r.metrics = metrics
+	return r
+}
+
+func (r Repo) recordOperation(operation string, duration time.Duration, err error) {
+	if r.metrics == nil {
+		return
+	}
+
+	name := metricName(operation)
+	if err != nil {
+		r.metrics.Inc(fmt.Sprintf("%s_failures_total", name))
+	} else {
+		r.metrics.Inc(fmt.Sprintf("%s_total", name))
+	}
+
+	r.metrics.Observe(fmt.Sprintf("%s_duration_seconds", name), duration.Seconds())
+}
+
+func metricName(operation string) string {
+	name := []rune("knit")
+	for _, c := range operation {
+		if unicode.IsUpper(c) {
+			name = append(name, '_')
+		}
+		name = append(name, unicode.ToLower(c))
+	}
+
+	return string(name)
+}
@@ -0,0 +1,64 @@
+package patcher_test
+
+import (
+	"errors"
+
+	"github.com/pivotal-cf/knit/patcher"
+	"github.com/pivotal-cf/knit/patcher/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type recordingMetrics struct {
+	counters     []string
+	observations map[string]float64
+}
+
+func (m *recordingMetrics) Inc(name string) {
+	m.counters = append(m.counters, name)
+}
+
+func (m *recordingMetrics) Observe(name string, v float64) {
+	m.observations[name] = v
+}
+
+var _ = Describe("WithMetrics", func() {
+	var (
+		runner  *fakes.CommandRunner
+		metrics *recordingMetrics
+		r       patcher.Repo
+	)
+
+	BeforeEach(func() {
+		runner = &fakes.CommandRunner{}
+		metrics = &recordingMetrics{observations: map[string]float64{}}
+		r = patcher.NewRepo(runner, "/some/repo", "testbot", "foo@example.com").WithMetrics(metrics)
+	})
+
+	It("counts successful operations and observes their duration", func() {
+		err := r.ApplyPatch("some.patch")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(metrics.counters).To(Equal([]string{"knit_apply_patch_total"}))
+		Expect(metrics.observations).To(HaveKey("knit_apply_patch_duration_seconds"))
+		Expect(metrics.observations["knit_apply_patch_duration_seconds"]).To(BeNumerically(">=", 0))
+	})
+
+	It("counts failed operations separately", func() {
+		runner.RunCall.Returns.Errors = []error{errors.New("meow")}
+
+		err := r.RemoveSubmodule("src/some-submodule")
+		Expect(err).To(MatchError("meow"))
+
+		Expect(metrics.counters).To(Equal([]string{"knit_remove_submodule_failures_total"}))
+		Expect(metrics.observations).To(HaveKey("knit_remove_submodule_duration_seconds"))
+	})
+
+	Context("when no metrics sink is set", func() {
+		It("does not record anything", func() {
+			err := patcher.NewRepo(runner, "/some/repo", "testbot", "foo@example.com").ApplyPatch("some.patch")
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,100 @@
+package patcher
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	defaultPatchDownloadMaxSize = 10 * 1024 * 1024
+	defaultPatchDownloadTimeout = time.Minute
+)
+
+type PatchDownloadOptions struct {
+	Client  *http.Client
+	Headers map[string]string
+	MaxSize int64
+	Timeout time.Duration
+}
+
+func (r Repo) WithPatchDownloadOptions(options PatchDownloadOptions) Repo {
+	r.patchDownloadOptions = options
+	return r
+}
+
+func (r Repo) ApplyPatchFromURL(url string) error {
+	if err := r.requireLocal("ApplyPatchFromURL"); err != nil {
+		return err
+	}
+
+	patchFile, err := ioutil.TempFile(r.tempDir, "knit-patch")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(patchFile.Name())
+
+	err = r.downloadPatch(url, patchFile)
+	patchFile.Close()
+	if err != nil {
+		return err
+	}
+
+	return r.WithPatchResolver(nil).ApplyPatch(patchFile.Name())
+}
+
+func (r Repo) downloadPatch(url string, w io.Writer) error {
+	options := r.patchDownloadOptions
+
+	client := options.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	maxSize := options.MaxSize
+	if maxSize <= 0 {
+		maxSize = defaultPatchDownloadMaxSize
+	}
+
+	timeout := options.Timeout
+	if timeout <= 0 {
+		timeout = defaultPatchDownloadTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+
+	for key, value := range options.Headers {
+		request.Header.Set(key, value)
+	}
+
+	response, err := client.Do(request.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("could not download patch %q: %s", url, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("could not download patch %q: unexpected status %s", url, response.Status)
+	}
+
+	written, err := io.Copy(w, io.LimitReader(response.Body, maxSize+1))
+	if err != nil {
+		return fmt.Errorf("could not download patch %q: %s", url, err)
+	}
+
+	if written > maxSize {
+		return fmt.Errorf("could not download patch %q: patch exceeds the maximum size of %d bytes", url, maxSize)
+	}
+
+	return nil
+}
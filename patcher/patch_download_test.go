@@ -0,0 +1,124 @@
+package patcher_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pivotal-cf/knit/patcher"
+	"github.com/pivotal-cf/knit/patcher/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ApplyPatchFromURL", func() {
+	var (
+		runner         *fakes.CommandRunner
+		r              patcher.Repo
+		server         *httptest.Server
+		receivedHeader string
+		appliedPatch   string
+	)
+
+	BeforeEach(func() {
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			receivedHeader = req.Header.Get("Authorization")
+
+			switch req.URL.Path {
+			case "/some.patch":
+				w.Write([]byte("some patch contents"))
+			case "/large.patch":
+				w.Write([]byte(strings.Repeat("x", 64)))
+			case "/slow.patch":
+				time.Sleep(100 * time.Millisecond)
+				w.Write([]byte("some patch contents"))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+
+		runner = &fakes.CommandRunner{}
+		runner.RunCall.Stub = func(command patcher.Command) error {
+			contents, err := ioutil.ReadFile(command.Args[len(command.Args)-1])
+			Expect(err).NotTo(HaveOccurred())
+			appliedPatch = string(contents)
+			return nil
+		}
+
+		r = patcher.NewRepo(runner, "/some/repo", "testbot", "foo@example.com")
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("downloads the patch and applies it with git am", func() {
+		err := r.WithPatchDownloadOptions(patcher.PatchDownloadOptions{
+			Headers: map[string]string{"Authorization": "token some-token"},
+		}).ApplyPatchFromURL(server.URL + "/some.patch")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(receivedHeader).To(Equal("token some-token"))
+		Expect(appliedPatch).To(Equal("some patch contents"))
+		Expect(runner.RunCall.Receives.Commands).To(HaveLen(1))
+		Expect(runner.RunCall.Receives.Commands[0].Args).To(ContainElement("am"))
+	})
+
+	Context("when a temp directory is configured", func() {
+		var tempDir string
+
+		BeforeEach(func() {
+			var err error
+			tempDir, err = ioutil.TempDir("", "")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(tempDir)).To(Succeed())
+		})
+
+		It("stages the patch there and removes it afterwards", func() {
+			err := r.WithTempDir(tempDir).ApplyPatchFromURL(server.URL + "/some.patch")
+			Expect(err).NotTo(HaveOccurred())
+
+			patchPath := runner.RunCall.Receives.Commands[0].Args[len(runner.RunCall.Receives.Commands[0].Args)-1]
+			Expect(filepath.Dir(patchPath)).To(Equal(tempDir))
+			Expect(patchPath).NotTo(BeAnExistingFile())
+		})
+	})
+
+	Context("failure cases", func() {
+		Context("when the server responds with an error", func() {
+			It("returns an error without applying anything", func() {
+				err := r.ApplyPatchFromURL(server.URL + "/missing.patch")
+				Expect(err).To(MatchError(ContainSubstring("unexpected status 404 Not Found")))
+				Expect(runner.RunCall.Count).To(Equal(0))
+			})
+		})
+
+		Context("when the patch exceeds the maximum size", func() {
+			It("returns an error without applying anything", func() {
+				err := r.WithPatchDownloadOptions(patcher.PatchDownloadOptions{
+					MaxSize: 32,
+				}).ApplyPatchFromURL(server.URL + "/large.patch")
+				Expect(err).To(MatchError(ContainSubstring("patch exceeds the maximum size of 32 bytes")))
+				Expect(runner.RunCall.Count).To(Equal(0))
+			})
+		})
+
+		Context("when the download times out", func() {
+			It("returns an error without applying anything", func() {
+				err := r.WithPatchDownloadOptions(patcher.PatchDownloadOptions{
+					Timeout: 10 * time.Millisecond,
+				}).ApplyPatchFromURL(server.URL + "/slow.patch")
+				Expect(err).To(MatchError(ContainSubstring("could not download patch")))
+				Expect(runner.RunCall.Count).To(Equal(0))
+			})
+		})
+	})
+})
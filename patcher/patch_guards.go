@@ -0,0 +1,159 @@
+package patcher
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	pathpkg "path"
+	"strings"
+)
+
+// PolicyViolationError is returned when a patch changed files matching
+// ApplyPatchOptions.ForbiddenPaths. The patch has been reverted.
+type PolicyViolationError struct {
+	Patch string
+	Paths []string
+}
+
+func (e PolicyViolationError) Error() string {
+	return fmt.Sprintf("Patch %q changes forbidden paths and was reverted: %s", e.Patch, strings.Join(e.Paths, ", "))
+}
+
+// PatchTooBroadError is returned when a patch changed more files than
+// ApplyPatchOptions.MaxFilesChanged allows. The patch has been reverted.
+type PatchTooBroadError struct {
+	Patch        string
+	FilesChanged int
+	Max          int
+}
+
+func (e PatchTooBroadError) Error() string {
+	return fmt.Sprintf("Patch %q changes %d files, more than the maximum of %d, and was reverted", e.Patch, e.FilesChanged, e.Max)
+}
+
+// patchGuard records the files a patch changes and the commit it is about to
+// be applied on, so that the patch can be checked and reverted afterwards.
+type patchGuard struct {
+	head  string
+	paths []string
+}
+
+func (r Repo) startPatchGuard(patch string) (patchGuard, error) {
+	paths, err := r.patchPaths(patch)
+	if err != nil {
+		return patchGuard{}, err
+	}
+
+	guard := patchGuard{paths: paths}
+	if r.deferCommits {
+		return guard, nil
+	}
+
+	stdout := &bytes.Buffer{}
+	err = r.run(Command{
+		Args:   []string{"rev-parse", "HEAD"},
+		Dir:    r.repo,
+		Stdout: stdout,
+	})
+	if err != nil {
+		return patchGuard{}, err
+	}
+
+	guard.head = strings.TrimSpace(stdout.String())
+	return guard, nil
+}
+
+func (r Repo) finishPatchGuard(guard patchGuard, patch string, options ApplyPatchOptions) error {
+	forbidden, err := matchingPaths(guard.paths, options.ForbiddenPaths)
+	if err != nil {
+		return err
+	}
+
+	var violation error
+	switch {
+	case options.MaxFilesChanged > 0 && len(guard.paths) > options.MaxFilesChanged:
+		violation = PatchTooBroadError{Patch: patch, FilesChanged: len(guard.paths), Max: options.MaxFilesChanged}
+	case len(forbidden) > 0:
+		violation = PolicyViolationError{Patch: patch, Paths: forbidden}
+	default:
+		return nil
+	}
+
+	// --keep leaves alone any uncommitted edits the patch did not touch.
+	revert := Command{
+		Args: []string{"reset", "--keep", guard.head},
+		Dir:  r.repo,
+	}
+	if r.deferCommits {
+		revert.Args = []string{"apply", "--index", "-R", patch}
+	}
+
+	if err := r.run(revert); err != nil {
+		return fmt.Errorf("Patch %q failed a post-apply check and could not be reverted: %s", patch, err)
+	}
+
+	return violation
+}
+
+// patchPaths returns every path the patch changes, including the old side of
+// renames, which git apply --numstat does not list.
+func (r Repo) patchPaths(patch string) ([]string, error) {
+	stdout := &bytes.Buffer{}
+	err := r.run(Command{
+		Args:   []string{"apply", "--numstat", "-z", patch},
+		Dir:    r.repo,
+		Stdout: stdout,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	contents, err := ioutil.ReadFile(patch)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		paths []string
+		seen  = map[string]bool{}
+	)
+	add := func(path string) {
+		if path != "" && !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+
+	for _, record := range strings.Split(stdout.String(), "\x00") {
+		if fields := strings.SplitN(record, "\t", 3); len(fields) == 3 {
+			add(fields[2])
+		}
+	}
+
+	for _, line := range strings.Split(string(contents), "\n") {
+		if strings.HasPrefix(line, "rename from ") {
+			add(strings.TrimPrefix(line, "rename from "))
+		}
+	}
+
+	return paths, nil
+}
+
+func matchingPaths(paths, globs []string) ([]string, error) {
+	var matches []string
+	for _, path := range paths {
+		for _, glob := range globs {
+			matched, err := pathpkg.Match(glob, path)
+			if err != nil {
+				return nil, err
+			}
+
+			if matched {
+				matches = append(matches, path)
+				break
+			}
+		}
+	}
+
+	return matches, nil
+}
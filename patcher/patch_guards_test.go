@@ -0,0 +1,218 @@
+package patcher_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/pivotal-cf/knit/patcher"
+	"github.com/pivotal-cf/knit/patcher/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func numstat(paths ...string) string {
+	var records []string
+	for _, path := range paths {
+		records = append(records, fmt.Sprintf("1\t0\t%s\x00", path))
+	}
+	return strings.Join(records, "")
+}
+
+var _ = Describe("ApplyPatchWithOptions with ForbiddenPaths", func() {
+	const patch = "fixtures/ci_change.patch"
+
+	var (
+		runner  *fakes.CommandRunner
+		r       patcher.Repo
+		options patcher.ApplyPatchOptions
+		changed string
+	)
+
+	BeforeEach(func() {
+		changed = numstat("src/app/main.go")
+		runner = &fakes.CommandRunner{}
+		runner.RunCall.Stub = func(command patcher.Command) error {
+			switch command.Args[0] {
+			case "rev-parse":
+				fmt.Fprintln(command.Stdout, "abc123")
+			case "apply":
+				if command.Stdout != nil {
+					fmt.Fprint(command.Stdout, changed)
+				}
+			}
+			return nil
+		}
+
+		r = patcher.NewRepo(runner, "/some/repo", "testbot", "foo@example.com")
+		options = patcher.ApplyPatchOptions{ForbiddenPaths: []string{".ci/*", "scripts/sign-*"}}
+	})
+
+	It("applies patches that stay within the allowed paths", func() {
+		_, err := r.ApplyPatchWithOptions(patch, options)
+		Expect(err).NotTo(HaveOccurred())
+
+		commands := runner.RunCall.Receives.Commands
+		Expect(commands).To(HaveLen(3))
+		Expect(commands[0].Args).To(Equal([]string{"apply", "--numstat", "-z", patch}))
+		Expect(commands[1].Args).To(Equal([]string{"rev-parse", "HEAD"}))
+		Expect(commands[2].Args).To(ContainElement("am"))
+	})
+
+	Context("when the patch changes a forbidden path", func() {
+		BeforeEach(func() {
+			changed = numstat("src/app/main.go", ".ci/pipeline.yml", "scripts/sign-release")
+		})
+
+		It("reverts the patch and reports the offending paths", func() {
+			_, err := r.ApplyPatchWithOptions(patch, options)
+			Expect(err).To(Equal(patcher.PolicyViolationError{
+				Patch: patch,
+				Paths: []string{".ci/pipeline.yml", "scripts/sign-release"},
+			}))
+			Expect(err).To(MatchError(fmt.Sprintf("Patch %q changes forbidden paths and was reverted: .ci/pipeline.yml, scripts/sign-release", patch)))
+
+			commands := runner.RunCall.Receives.Commands
+			Expect(commands[len(commands)-1]).To(Equal(patcher.Command{
+				Args: []string{"reset", "--keep", "abc123"},
+				Dir:  "/some/repo",
+			}))
+		})
+
+		Context("when commits are deferred", func() {
+			It("checks every path in the patch, even ones already staged, and reverts only the patch", func() {
+				_, err := r.WithDeferredCommits().ApplyPatchWithOptions(patch, options)
+				Expect(err).To(Equal(patcher.PolicyViolationError{
+					Patch: patch,
+					Paths: []string{".ci/pipeline.yml", "scripts/sign-release"},
+				}))
+
+				commands := runner.RunCall.Receives.Commands
+				Expect(commands[len(commands)-1]).To(Equal(patcher.Command{
+					Args: []string{"apply", "--index", "-R", patch},
+					Dir:  "/some/repo",
+				}))
+			})
+		})
+	})
+
+	Context("when the patch renames a forbidden path away", func() {
+		It("reports the old path", func() {
+			changed = numstat("config/new.yml", "scripts/old.sh")
+
+			_, err := r.ApplyPatchWithOptions("fixtures/rename_and_delete.patch", patcher.ApplyPatchOptions{ForbiddenPaths: []string{"config/old.yml"}})
+			Expect(err).To(Equal(patcher.PolicyViolationError{
+				Patch: "fixtures/rename_and_delete.patch",
+				Paths: []string{"config/old.yml"},
+			}))
+		})
+	})
+})
+
+var _ = Describe("ApplyPatchWithOptions with MaxFilesChanged", func() {
+	const patch = "fixtures/ci_change.patch"
+
+	var (
+		runner  *fakes.CommandRunner
+		r       patcher.Repo
+		changed string
+		failure error
+	)
+
+	BeforeEach(func() {
+		changed = numstat("a.go", "b.go")
+		failure = nil
+		runner = &fakes.CommandRunner{}
+		runner.RunCall.Stub = func(command patcher.Command) error {
+			switch command.Args[0] {
+			case "rev-parse":
+				fmt.Fprintln(command.Stdout, "abc123")
+			case "apply":
+				fmt.Fprint(command.Stdout, changed)
+			case "reset":
+				return failure
+			}
+			return nil
+		}
+
+		r = patcher.NewRepo(runner, "/some/repo", "testbot", "foo@example.com")
+	})
+
+	It("keeps patches within the limit", func() {
+		_, err := r.ApplyPatchWithOptions(patch, patcher.ApplyPatchOptions{MaxFilesChanged: 2})
+		Expect(err).NotTo(HaveOccurred())
+
+		commands := runner.RunCall.Receives.Commands
+		Expect(commands[len(commands)-1].Args).To(ContainElement("am"))
+	})
+
+	It("does not read the patch's paths when there is no limit", func() {
+		_, err := r.ApplyPatchWithOptions(patch, patcher.ApplyPatchOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(runner.RunCall.Receives.Commands).To(HaveLen(1))
+	})
+
+	Context("when the patch changes too many files", func() {
+		BeforeEach(func() {
+			changed = numstat("a.go", "b.go", "c.go")
+		})
+
+		It("reverts the patch", func() {
+			_, err := r.ApplyPatchWithOptions(patch, patcher.ApplyPatchOptions{MaxFilesChanged: 2})
+			Expect(err).To(Equal(patcher.PatchTooBroadError{Patch: patch, FilesChanged: 3, Max: 2}))
+			Expect(err).To(MatchError(fmt.Sprintf("Patch %q changes 3 files, more than the maximum of 2, and was reverted", patch)))
+
+			commands := runner.RunCall.Receives.Commands
+			Expect(commands[len(commands)-1]).To(Equal(patcher.Command{
+				Args: []string{"reset", "--keep", "abc123"},
+				Dir:  "/some/repo",
+			}))
+		})
+
+		Context("when the revert fails", func() {
+			It("returns an error", func() {
+				failure = errors.New("meow")
+
+				_, err := r.ApplyPatchWithOptions(patch, patcher.ApplyPatchOptions{MaxFilesChanged: 2})
+				Expect(err).To(MatchError(fmt.Sprintf("Patch %q failed a post-apply check and could not be reverted: meow", patch)))
+			})
+		})
+	})
+})
+
+var _ = Describe("ApplyPatchWithOptions rejecting a patch in a dirty checkout", func() {
+	var (
+		repo      gitRepo
+		patchPath string
+	)
+
+	BeforeEach(func() {
+		repo = newGitRepo()
+		repo.writeFile("a", "a\n")
+		repo.writeFile("b", "b\n")
+		repo.writeFile("notes", "notes\n")
+		repo.commit("Initial")
+
+		repo.writeFile("a", "a2\n")
+		repo.writeFile("b", "b2\n")
+		repo.commit("Broad")
+		patchPath = repo.formatPatch("-1")
+		repo.git("reset", "-q", "--hard", "HEAD^")
+
+		repo.writeFile("notes", "uncommitted notes\n")
+	})
+
+	AfterEach(func() {
+		repo.remove()
+	})
+
+	It("keeps the uncommitted edits", func() {
+		_, err := repo.repo().ApplyPatchWithOptions(patchPath, patcher.ApplyPatchOptions{MaxFilesChanged: 1})
+		Expect(err).To(Equal(patcher.PatchTooBroadError{Patch: patchPath, FilesChanged: 2, Max: 1}))
+
+		Expect(repo.git("log", "-1", "--format=%s")).To(Equal("Initial\n"))
+		Expect(repo.git("status", "--porcelain")).To(Equal(" M notes\n"))
+		Expect(repo.readFile("notes")).To(Equal("uncommitted notes\n"))
+	})
+})
@@ -0,0 +1,797 @@
+package patcher
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type ApplyPatchOptions struct {
+	// SkipIfApplied skips patches whose changes are already present in the
+	// working tree, making a patch set safe to replay.
+	SkipIfApplied bool
+
+	// NotesRef, when set, records the patch filename and checksum as a git
+	// note under that ref on the commit the patch produced.
+	NotesRef string
+
+	// Strategy, when set to "ours" or "theirs", applies the patch with a 3-way
+	// merge and resolves every conflicting hunk in favour of the repository or
+	// the patch respectively, like merge -X. This silently discards the losing
+	// side's changes, so only use it where that resolution is known to be
+	// acceptable.
+	Strategy string
+
+	// Encoding sets i18n.commitEncoding for the am, for patches whose author
+	// or subject are in a legacy encoding such as ISO-8859-1.
+	Encoding string
+
+	// ReportFuzz dry-runs the patch first and reports every hunk that only
+	// applies at an offset or with fuzz in ApplyPatchResult.FuzzWarnings. A
+	// patch that fails the dry run is not applied.
+	ReportFuzz bool
+
+	// Fuzz lets a hunk apply with up to that many of the context lines at
+	// either end of it not matching, like patch --fuzz. git has no fuzz factor
+	// of its own, so this assumes the usual three lines of context and passes
+	// -C<3-Fuzz> to apply and am.
+	Fuzz int
+
+	// AutoStash stashes a dirty working tree, including untracked files,
+	// before applying the patch and pops it afterwards. If the pop conflicts
+	// the working tree is reset, the changes stay in the stash and a
+	// StashPopError is returned.
+	AutoStash bool
+
+	// ForbiddenPaths lists globs, using path.Match semantics, of files the
+	// patch must not change. A patch that changes any of them is reverted and
+	// a PolicyViolationError is returned.
+	ForbiddenPaths []string
+
+	// MaxFilesChanged, when positive, reverts a patch that changes more files
+	// than this and returns a PatchTooBroadError.
+	MaxFilesChanged int
+
+	// FixWhitespace applies the patch with --whitespace=fix, so whitespace
+	// errors are corrected rather than left for core.whitespace checks. The
+	// corrected lines are reported in ApplyPatchResult.WhitespaceFixes.
+	FixWhitespace bool
+
+	// SigningKey signs the commit am produces with that key, and NoSigning
+	// leaves it unsigned, whatever commit.gpgSign is set to. Neither has any
+	// effect when commits are deferred.
+	SigningKey string
+	NoSigning  bool
+}
+
+// WhitespaceFix is a whitespace error git corrected while applying a patch.
+// Line is the line number within the patch.
+type WhitespaceFix struct {
+	Line    int
+	Problem string
+}
+
+// FuzzWarning is a hunk that did not apply where the patch placed it. Offset
+// is how many lines it moved and Fuzz how many context lines at either end
+// were ignored to make it fit.
+type FuzzWarning struct {
+	File   string
+	Hunk   int
+	Line   int
+	Offset int
+	Fuzz   int
+}
+
+type PatchEncodingError struct {
+	Patch  string
+	Reason string
+}
+
+func (e PatchEncodingError) Error() string {
+	return fmt.Sprintf("Patch %q has an encoding problem: %s", e.Patch, e.Reason)
+}
+
+type ApplyPatchResult struct {
+	Skipped         bool
+	Resolved        []string
+	FuzzWarnings    []FuzzWarning
+	WhitespaceFixes []WhitespaceFix
+}
+
+func (r Repo) ApplyPatchWithOptions(patch string, options ApplyPatchOptions) (ApplyPatchResult, error) {
+	var result ApplyPatchResult
+	err := r.withHooks("ApplyPatch", patch, func() error {
+		if r.patchResolver != nil {
+			return r.withResolvedPatch(patch, func(path string) error {
+				var err error
+				result, err = r.applyPatch(patch, path, options)
+				return err
+			})
+		}
+
+		if err := r.verifyPatchSignature(patch, patch); err != nil {
+			return err
+		}
+
+		var err error
+		result, err = r.applyPatch(patch, patch, options)
+		return err
+	})
+
+	return result, err
+}
+
+// applyPatch applies the patch named by ref from path, which differs from ref
+// when the patch was resolved through a PatchResolver.
+func (r Repo) applyPatch(ref, patch string, options ApplyPatchOptions) (ApplyPatchResult, error) {
+	if err := r.requireLocalOptions(options); err != nil {
+		return ApplyPatchResult{}, err
+	}
+
+	// A patch path names a file on the host the commands run on, so the
+	// checks that read the patch directly are only made on a local checkout.
+	local := remoteHost(r.runner) == ""
+
+	if local {
+		if err := checkEmptyPatch(patch); err != nil {
+			return ApplyPatchResult{}, err
+		}
+	}
+
+	if options.SkipIfApplied && r.patchAlreadyApplied(patch) {
+		r.logf("Skipping %s: already applied", ref)
+		return ApplyPatchResult{Skipped: true}, nil
+	}
+
+	if options.AutoStash {
+		options.AutoStash = false
+
+		var result ApplyPatchResult
+		err := r.withAutoStash(func() error {
+			var err error
+			result, err = r.applyPatch(ref, patch, options)
+			return err
+		})
+
+		return result, err
+	}
+
+	if options.Strategy != "" && options.Strategy != "ours" && options.Strategy != "theirs" {
+		return ApplyPatchResult{}, fmt.Errorf("Unknown conflict resolution strategy %q, expected \"ours\" or \"theirs\"", options.Strategy)
+	}
+
+	if options.SigningKey != "" && options.NoSigning {
+		return ApplyPatchResult{}, fmt.Errorf("Patch %q cannot be applied both signed and unsigned", patch)
+	}
+
+	if local {
+		if err := checkPatchEncoding(patch, options.Encoding); err != nil {
+			return ApplyPatchResult{}, err
+		}
+
+		if err := checkCombinedDiff(patch); err != nil {
+			return ApplyPatchResult{}, err
+		}
+	}
+
+	if err := checkFuzz(options.Fuzz); err != nil {
+		return ApplyPatchResult{}, err
+	}
+
+	var result ApplyPatchResult
+	if options.ReportFuzz {
+		contents, err := ioutil.ReadFile(patch)
+		if err != nil {
+			return ApplyPatchResult{}, err
+		}
+
+		output, err := r.combinedOutput(Command{
+			Args: append(append([]string{"apply", "--check", "--verbose"}, contextArgs(options.Fuzz)...), patch),
+			Dir:  r.repo,
+		})
+		if err != nil {
+			return ApplyPatchResult{}, fmt.Errorf("Patch %q does not apply: %s\n%s", patch, err, strings.TrimSpace(string(output)))
+		}
+
+		result.FuzzWarnings = parseFuzzWarnings(string(contents), output)
+	}
+
+	args := append(contextArgs(options.Fuzz), patch)
+	if options.Strategy != "" {
+		args = append(contextArgs(options.Fuzz), "--3way", patch)
+	}
+
+	if options.FixWhitespace {
+		args = append([]string{"--whitespace=fix"}, args...)
+	}
+
+	signing := r.signingArgs(options)
+	args = append(signing, args...)
+
+	guarded := len(options.ForbiddenPaths) > 0 || options.MaxFilesChanged > 0
+
+	var guard patchGuard
+	if guarded {
+		var err error
+		guard, err = r.startPatchGuard(patch)
+		if err != nil {
+			return ApplyPatchResult{}, err
+		}
+	}
+
+	removed, before := r.expectedRemovals(patch)
+
+	patchArgs := r.patchArgs(args...)
+	if options.Encoding != "" {
+		patchArgs = append([]string{"-c", fmt.Sprintf("i18n.commitEncoding=%s", options.Encoding)}, patchArgs...)
+	}
+
+	applyCommand := Command{
+		Args: patchArgs,
+		Dir:  r.repo,
+	}
+
+	var err error
+	if options.FixWhitespace {
+		var output []byte
+		output, err = r.combinedOutput(applyCommand)
+		result.WhitespaceFixes = parseWhitespaceFixes(output)
+		if err != nil {
+			err = fmt.Errorf("%s\n%s", err, strings.TrimSpace(string(output)))
+		}
+	} else {
+		err = r.run(applyCommand)
+	}
+	skippedEmpty := false
+	for err != nil && !r.deferCommits && r.amStoppedOnEmptyPatch() {
+		skippedEmpty = true
+		position := r.amPosition()
+		err = r.run(Command{
+			Args: r.withIdentity(append(append([]string{"am"}, signing...), "--skip")...),
+			Dir:  r.repo,
+		})
+		if err != nil && r.amPosition() == position {
+			break
+		}
+	}
+
+	// Skipping an empty mail goes on to apply the rest of the mbox, and
+	// whatever that committed is checked like any other applied patch.
+	if err == nil && skippedEmpty && !r.amCommitted() {
+		return ApplyPatchResult{Skipped: true}, nil
+	}
+
+	if err != nil {
+		if options.Strategy == "" {
+			return ApplyPatchResult{}, err
+		}
+
+		result.Resolved, err = r.resolveConflicts(options.Strategy, signing, err)
+		if err != nil {
+			return ApplyPatchResult{}, err
+		}
+	}
+
+	// The guard goes first so that a patch it rejects is reverted, whatever
+	// else is wrong with it.
+	if guarded {
+		if err := r.finishPatchGuard(guard, patch, options); err != nil {
+			return ApplyPatchResult{}, err
+		}
+	}
+
+	if err := r.verifyPathChanges(patch, before, removed); err != nil {
+		return ApplyPatchResult{}, err
+	}
+
+	if options.NotesRef != "" && !r.deferCommits {
+		if err := r.addPatchNote(ref, patch, options.NotesRef); err != nil {
+			return ApplyPatchResult{}, fmt.Errorf("Patch %q was applied but could not be recorded as a note: %s", patch, err)
+		}
+	}
+
+	return result, nil
+}
+
+// requireLocalOptions refuses the options that read or write files of the
+// checkout directly when the repo's commands run on another host.
+func (r Repo) requireLocalOptions(options ApplyPatchOptions) error {
+	var option string
+	switch {
+	case options.ReportFuzz:
+		option = "ReportFuzz"
+	case options.Strategy != "":
+		option = "Strategy"
+	case len(options.ForbiddenPaths) > 0:
+		option = "ForbiddenPaths"
+	case options.MaxFilesChanged > 0:
+		option = "MaxFilesChanged"
+	case options.NotesRef != "":
+		option = "NotesRef"
+	default:
+		return nil
+	}
+
+	return r.requireLocal(fmt.Sprintf("ApplyPatchOptions.%s", option))
+}
+
+func (r Repo) signingArgs(options ApplyPatchOptions) []string {
+	switch {
+	case r.deferCommits:
+		return nil
+	case options.SigningKey != "":
+		return []string{fmt.Sprintf("--gpg-sign=%s", options.SigningKey)}
+	case options.NoSigning:
+		return []string{"--no-gpg-sign"}
+	}
+
+	return nil
+}
+
+// resolveConflicts settles each conflicted path in the direction of strategy
+// and moves the am on. git am does not remember signing flags across a stop,
+// so signing is passed to the continue again.
+func (r Repo) resolveConflicts(strategy string, signing []string, applyErr error) ([]string, error) {
+	conflicts, err := r.Conflicts()
+	if err != nil || len(conflicts) == 0 {
+		r.abortAm()
+		return nil, applyErr
+	}
+
+	for _, path := range conflicts {
+		if err := r.resolveConflict(strategy, path); err != nil {
+			r.abortAm()
+			return nil, err
+		}
+	}
+
+	err = r.run(Command{
+		Args: append([]string{"add", "--"}, conflicts...),
+		Dir:  r.repo,
+	})
+	if err != nil {
+		r.abortAm()
+		return nil, err
+	}
+
+	if r.deferCommits {
+		return conflicts, nil
+	}
+
+	// A resolution that leaves nothing of the patch cannot be continued; am
+	// only moves past it with --skip.
+	next := "--continue"
+	if r.run(Command{Args: []string{"diff", "--cached", "--quiet", "HEAD"}, Dir: r.repo}) == nil {
+		next = "--skip"
+	}
+
+	err = r.run(Command{
+		Args: r.withIdentity(append(append([]string{"am"}, signing...), next)...),
+		Dir:  r.repo,
+	})
+	if err != nil {
+		r.abortAm()
+		return nil, err
+	}
+
+	return conflicts, nil
+}
+
+// resolveConflict resolves the conflicting hunks of path in favour of strategy
+// the way merge -X ours/theirs would, keeping the hunks that merged cleanly.
+// A path missing from one side, e.g. deleted by the patch, is taken whole.
+func (r Repo) resolveConflict(strategy, path string) error {
+	stdout := &bytes.Buffer{}
+	err := r.run(Command{
+		Args:   []string{"checkout-index", "--stage=all", "--temp", "--", path},
+		Dir:    r.repo,
+		Stdout: stdout,
+	})
+	if err != nil {
+		return err
+	}
+
+	stages := strings.Fields(strings.SplitN(stdout.String(), "\t", 2)[0])
+	if len(stages) != 3 {
+		return fmt.Errorf("Could not read the conflicting versions of %q", path)
+	}
+
+	for _, stage := range stages {
+		if stage != "." {
+			defer os.Remove(filepath.Join(r.repo, stage))
+		}
+	}
+
+	base, ours, theirs := stages[0], stages[1], stages[2]
+	if base == "." || ours == "." || theirs == "." {
+		if (strategy == "ours" && ours == ".") || (strategy == "theirs" && theirs == ".") {
+			return r.run(Command{
+				Args: []string{"rm", "-q", "--", path},
+				Dir:  r.repo,
+			})
+		}
+
+		return r.run(Command{
+			Args: []string{"checkout", fmt.Sprintf("--%s", strategy), "--", path},
+			Dir:  r.repo,
+		})
+	}
+
+	file, err := os.Create(filepath.Join(r.repo, path))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return r.run(Command{
+		Args:   []string{"merge-file", "-p", fmt.Sprintf("--%s", strategy), ours, base, theirs},
+		Dir:    r.repo,
+		Stdout: file,
+	})
+}
+
+func (r Repo) abortAm() {
+	if !r.deferCommits {
+		r.run(Command{
+			Args: []string{"am", "--abort"},
+			Dir:  r.repo,
+		})
+	}
+}
+
+func (r Repo) addPatchNote(ref, patch, notesRef string) error {
+	contents, err := ioutil.ReadFile(patch)
+	if err != nil {
+		return err
+	}
+
+	note := fmt.Sprintf("Knit patch: %s\nSHA1: %x", filepath.Base(ref), sha1.Sum(contents))
+
+	return r.run(Command{
+		Args: r.withIdentity("notes", fmt.Sprintf("--ref=%s", notesRef), "add", "-f", "-m", note, "HEAD"),
+		Dir:  r.repo,
+	})
+}
+
+func (r Repo) patchAlreadyApplied(patch string) bool {
+	_, err := r.combinedOutput(Command{
+		Args: []string{"apply", "--reverse", "--check", patch},
+		Dir:  r.repo,
+	})
+
+	return err == nil
+}
+
+// ApplyPatchKeepingConflictMarkers applies the patch with git apply --3way
+// and never commits. Regions that do not apply cleanly are left in the
+// working tree with conflict markers, and their paths are returned so they
+// can be resolved in an editor and committed with CommitAll.
+func (r Repo) ApplyPatchKeepingConflictMarkers(patch string) ([]string, error) {
+	var conflicts []string
+	err := r.withPatchHooks("ApplyPatchKeepingConflictMarkers", patch, func() error {
+		output, err := r.combinedOutput(Command{
+			Args: []string{"apply", "--3way", patch},
+			Dir:  r.repo,
+		})
+		if err == nil {
+			return nil
+		}
+
+		conflicts, _ = r.Conflicts()
+		if len(conflicts) == 0 {
+			return fmt.Errorf("Patch %q could not be applied: %s\n%s", patch, err, strings.TrimRight(string(output), "\n"))
+		}
+
+		return nil
+	})
+
+	return conflicts, err
+}
+
+// ApplyPatchInteractive applies the patch with a 3-way merge and hands the
+// contents of any conflicted files, conflict markers included, to resolve.
+// The contents it returns are written back and the am is continued. If
+// resolve fails, or leaves a conflict unresolved, the am is aborted.
+func (r Repo) ApplyPatchInteractive(patch string, resolve func(conflicts map[string][]byte) (map[string][]byte, error)) error {
+	return r.withPatchHooks("ApplyPatchInteractive", patch, func() error {
+		if err := r.requireLocal("ApplyPatchInteractive"); err != nil {
+			return err
+		}
+
+		applyErr := r.run(Command{
+			Args: r.patchArgs("--3way", patch),
+			Dir:  r.repo,
+		})
+		if applyErr == nil {
+			return nil
+		}
+
+		paths, err := r.Conflicts()
+		if err != nil || len(paths) == 0 {
+			return applyErr
+		}
+
+		err = r.resolveInteractively(paths, resolve)
+		if err != nil {
+			if !r.deferCommits {
+				r.run(Command{
+					Args: []string{"am", "--abort"},
+					Dir:  r.repo,
+				})
+			}
+
+			return fmt.Errorf("Conflicts in patch %q were not resolved: %s", patch, err)
+		}
+
+		if r.deferCommits {
+			return nil
+		}
+
+		return r.run(Command{
+			Args: r.withIdentity("am", "--continue"),
+			Dir:  r.repo,
+		})
+	})
+}
+
+func (r Repo) resolveInteractively(paths []string, resolve func(conflicts map[string][]byte) (map[string][]byte, error)) error {
+	conflicts := map[string][]byte{}
+	for _, path := range paths {
+		contents, err := ioutil.ReadFile(filepath.Join(r.repo, path))
+		if err != nil {
+			return err
+		}
+
+		conflicts[path] = contents
+	}
+
+	resolved, err := resolve(conflicts)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		contents, ok := resolved[path]
+		if !ok {
+			return fmt.Errorf("no resolution for %s", path)
+		}
+
+		err := ioutil.WriteFile(filepath.Join(r.repo, path), contents, 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	return r.run(Command{
+		Args: append([]string{"add", "--"}, paths...),
+		Dir:  r.repo,
+	})
+}
+
+// checkPatchEncoding inspects the mail headers of patch for problems git am
+// would otherwise turn into mojibake or an unhelpful failure. Patches that
+// cannot be read are left for git am to report.
+func checkPatchEncoding(patch, encoding string) error {
+	contents, err := ioutil.ReadFile(patch)
+	if err != nil {
+		return nil
+	}
+
+	var (
+		headers          []string
+		charset          string
+		transferEncoding string
+	)
+
+	for _, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			break
+		}
+
+		headers = append(headers, line)
+		lower := strings.ToLower(line)
+		switch {
+		case strings.HasPrefix(lower, "content-type:"):
+			if index := strings.Index(lower, "charset="); index != -1 {
+				charset = strings.Trim(line[index+len("charset="):], `"; `)
+			}
+		case strings.HasPrefix(lower, "content-transfer-encoding:"):
+			transferEncoding = strings.TrimSpace(lower[len("content-transfer-encoding:"):])
+		}
+	}
+
+	switch transferEncoding {
+	case "", "7bit", "8bit", "binary", "quoted-printable", "base64":
+	default:
+		return PatchEncodingError{Patch: patch, Reason: fmt.Sprintf("unsupported Content-Transfer-Encoding %q", transferEncoding)}
+	}
+
+	if charset == "" && encoding == "" && !utf8.ValidString(strings.Join(headers, "\n")) {
+		return PatchEncodingError{Patch: patch, Reason: "its headers are not valid UTF-8 and it declares no charset; set ApplyPatchOptions.Encoding to the encoding it was written in"}
+	}
+
+	return nil
+}
+
+func parseWhitespaceFixes(output []byte) []WhitespaceFix {
+	problem := regexp.MustCompile(`^.*:(\d+): ([^:]+)\.$`)
+
+	var fixes []WhitespaceFix
+	for _, line := range splitLines(output) {
+		matches := problem.FindStringSubmatch(line)
+		if matches == nil {
+			continue
+		}
+
+		fix := WhitespaceFix{Problem: matches[2]}
+		fix.Line, _ = strconv.Atoi(matches[1])
+		fixes = append(fixes, fix)
+	}
+
+	return fixes
+}
+
+const defaultContextLines = 3
+
+func checkFuzz(fuzz int) error {
+	if fuzz < 0 || fuzz > defaultContextLines {
+		return fmt.Errorf("Fuzz must be between 0 and %d, got %d", defaultContextLines, fuzz)
+	}
+
+	return nil
+}
+
+// contextArgs returns the -C option that lets hunks apply with fuzz.
+func contextArgs(fuzz int) []string {
+	if fuzz == 0 {
+		return nil
+	}
+
+	return []string{fmt.Sprintf("-C%d", defaultContextLines-fuzz)}
+}
+
+type patchHunk struct {
+	newStart int
+	leading  int
+	trailing int
+}
+
+// parsePatchHunks returns the hunks of every file in a patch, keyed by the
+// file's new name, with the number of context lines before and after the
+// changes in each.
+func parsePatchHunks(contents string) map[string][]patchHunk {
+	header := regexp.MustCompile(`^diff --git a/.* b/(.*)$`)
+	hunkHeader := regexp.MustCompile(`^@@ -\d+(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+	lineCount := func(count string) int {
+		if count == "" {
+			return 1
+		}
+
+		n, _ := strconv.Atoi(count)
+		return n
+	}
+
+	var (
+		hunks            = map[string][]patchHunk{}
+		file             string
+		current          *patchHunk
+		oldLeft, newLeft int
+		changed          bool
+	)
+
+	for _, line := range strings.Split(contents, "\n") {
+		if oldLeft > 0 || newLeft > 0 {
+			switch {
+			case strings.HasPrefix(line, "+"):
+				newLeft--
+				changed = true
+				current.trailing = 0
+			case strings.HasPrefix(line, "-"):
+				oldLeft--
+				changed = true
+				current.trailing = 0
+			case strings.HasPrefix(line, "\\"):
+			default:
+				oldLeft--
+				newLeft--
+				if changed {
+					current.trailing++
+				} else {
+					current.leading++
+				}
+			}
+			continue
+		}
+
+		if matches := header.FindStringSubmatch(line); matches != nil {
+			file = matches[1]
+			continue
+		}
+
+		if matches := hunkHeader.FindStringSubmatch(line); matches != nil {
+			hunk := patchHunk{}
+			hunk.newStart, _ = strconv.Atoi(matches[2])
+			hunks[file] = append(hunks[file], hunk)
+
+			current = &hunks[file][len(hunks[file])-1]
+			oldLeft, newLeft = lineCount(matches[1]), lineCount(matches[3])
+			changed = false
+		}
+	}
+
+	return hunks
+}
+
+// parseFuzzWarnings reads the output of git apply --verbose. git reports a
+// hunk that needed fuzz as applying with reduced context, at an offset that
+// counts the dropped context lines, so both are worked out against the hunks
+// in the patch.
+func parseFuzzWarnings(contents string, output []byte) []FuzzWarning {
+	checking := regexp.MustCompile(`^Checking patch (.*)\.\.\.$`)
+	hunk := regexp.MustCompile(`^Hunk #(\d+) succeeded at (\d+) \(offset (-?\d+) lines?\)\.$`)
+	reduced := regexp.MustCompile(`^Context reduced to \((\d+)/(\d+)\) to apply fragment at (\d+)$`)
+
+	var (
+		hunks    = parsePatchHunks(contents)
+		warnings []FuzzWarning
+		file     string
+	)
+
+	for _, line := range splitLines(output) {
+		if matches := checking.FindStringSubmatch(line); matches != nil {
+			file = matches[1]
+			continue
+		}
+
+		if matches := hunk.FindStringSubmatch(line); matches != nil {
+			warning := FuzzWarning{File: file}
+			warning.Hunk, _ = strconv.Atoi(matches[1])
+			warning.Line, _ = strconv.Atoi(matches[2])
+			warning.Offset, _ = strconv.Atoi(matches[3])
+			warnings = append(warnings, warning)
+			continue
+		}
+
+		matches := reduced.FindStringSubmatch(line)
+		if matches == nil {
+			continue
+		}
+
+		leading, _ := strconv.Atoi(matches[1])
+		trailing, _ := strconv.Atoi(matches[2])
+		at, _ := strconv.Atoi(matches[3])
+
+		last := len(warnings) - 1
+		if last < 0 || warnings[last].File != file || warnings[last].Line != at {
+			for i, h := range hunks[file] {
+				if h.newStart+h.leading-leading == at {
+					warnings = append(warnings, FuzzWarning{File: file, Hunk: i + 1, Line: at})
+					break
+				}
+			}
+			last = len(warnings) - 1
+		}
+
+		if last < 0 || warnings[last].File != file || warnings[last].Line != at || warnings[last].Hunk > len(hunks[file]) {
+			continue
+		}
+
+		h := hunks[file][warnings[last].Hunk-1]
+		warnings[last].Fuzz = h.leading - leading
+		if h.trailing-trailing > warnings[last].Fuzz {
+			warnings[last].Fuzz = h.trailing - trailing
+		}
+		warnings[last].Offset = at - (h.newStart + h.leading - leading)
+	}
+
+	return warnings
+}
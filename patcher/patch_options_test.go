@@ -0,0 +1,706 @@
+package patcher_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pivotal-cf/knit/patcher"
+	"github.com/pivotal-cf/knit/patcher/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ApplyPatchWithOptions", func() {
+	var (
+		runner *fakes.CommandRunner
+		r      patcher.Repo
+	)
+
+	BeforeEach(func() {
+		runner = &fakes.CommandRunner{}
+		r = patcher.NewRepo(runner, "/some/repo", "testbot", "foo@example.com")
+	})
+
+	It("applies the patch with git am", func() {
+		result, err := r.ApplyPatchWithOptions("some-dir/something.patch", patcher.ApplyPatchOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(patcher.ApplyPatchResult{}))
+
+		Expect(runner.CombinedOutputCall.Receives.Commands).To(BeEmpty())
+		Expect(runner.RunCall.Receives.Commands).To(Equal([]patcher.Command{
+			patcher.Command{
+				Args: []string{
+					"-c", "user.name=testbot",
+					"-c", "user.email=foo@example.com",
+					"am",
+					"some-dir/something.patch",
+				},
+				Dir: "/some/repo",
+			},
+		}))
+	})
+
+	Context("when skipping already-applied patches", func() {
+		var options patcher.ApplyPatchOptions
+
+		BeforeEach(func() {
+			options = patcher.ApplyPatchOptions{SkipIfApplied: true}
+		})
+
+		Context("when the patch is already applied", func() {
+			It("skips the patch", func() {
+				result, err := r.ApplyPatchWithOptions("some-dir/something.patch", options)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result.Skipped).To(BeTrue())
+
+				Expect(runner.CombinedOutputCall.Receives.Commands).To(Equal([]patcher.Command{
+					patcher.Command{
+						Args: []string{"apply", "--reverse", "--check", "some-dir/something.patch"},
+						Dir:  "/some/repo",
+					},
+				}))
+				Expect(runner.RunCall.Receives.Commands).To(BeEmpty())
+			})
+
+			It("reports the skip", func() {
+				stdout := bytes.NewBuffer([]byte{})
+
+				_, err := r.WithStdout(stdout).ApplyPatchWithOptions("some-dir/something.patch", options)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(stdout.String()).To(Equal("Skipping some-dir/something.patch: already applied\n"))
+			})
+		})
+
+		Context("when the patch is not yet applied", func() {
+			It("applies the patch", func() {
+				runner.CombinedOutputCall.Returns.Errors = []error{errors.New("patch does not apply")}
+				runner.CombinedOutputCall.Returns.Outputs = [][]byte{nil}
+
+				result, err := r.ApplyPatchWithOptions("some-dir/something.patch", options)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result.Skipped).To(BeFalse())
+				Expect(runner.RunCall.Count).To(Equal(1))
+			})
+		})
+	})
+
+	Context("when a conflict resolution strategy is set", func() {
+		It("applies the patch with a 3-way merge", func() {
+			_, err := r.ApplyPatchWithOptions("some-dir/something.patch", patcher.ApplyPatchOptions{Strategy: "theirs"})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(runner.RunCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{
+						"-c", "user.name=testbot",
+						"-c", "user.email=foo@example.com",
+						"am",
+						"--3way",
+						"some-dir/something.patch",
+					},
+					Dir: "/some/repo",
+				},
+			}))
+		})
+
+		Context("when the patch conflicts", func() {
+			var (
+				runCommands   []string
+				continueError error
+				matchesHead   bool
+			)
+
+			BeforeEach(func() {
+				runCommands = nil
+				continueError = nil
+				matchesHead = false
+
+				runner.RunCall.Stub = func(command patcher.Command) error {
+					args := command.Args
+					if args[0] == "-c" {
+						args = args[4:]
+					}
+					runCommands = append(runCommands, strings.Join(args, " "))
+
+					switch {
+					case args[0] == "am" && args[len(args)-1] == "some-dir/something.patch":
+						return errors.New("patch failed")
+					case args[0] == "checkout-index":
+						fmt.Fprintf(command.Stdout, ". .merge_file_a .merge_file_b\t%s\n", args[len(args)-1])
+					case args[0] == "diff" && !matchesHead:
+						return errors.New("exit status 1")
+					case args[0] == "am" && args[len(args)-1] == "--continue":
+						return continueError
+					}
+					return nil
+				}
+				runner.CombinedOutputCall.Returns.Errors = []error{nil}
+				runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte("some-file.txt\nother-file.txt\n")}
+			})
+
+			It("resolves the conflicts in the chosen direction and continues", func() {
+				result, err := r.ApplyPatchWithOptions("some-dir/something.patch", patcher.ApplyPatchOptions{Strategy: "ours"})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result.Resolved).To(Equal([]string{"some-file.txt", "other-file.txt"}))
+
+				Expect(runCommands[1:]).To(Equal([]string{
+					"checkout-index --stage=all --temp -- some-file.txt",
+					"checkout --ours -- some-file.txt",
+					"checkout-index --stage=all --temp -- other-file.txt",
+					"checkout --ours -- other-file.txt",
+					"add -- some-file.txt other-file.txt",
+					"diff --cached --quiet HEAD",
+					"am --continue",
+				}))
+			})
+
+			It("passes the signing flags on to the continue", func() {
+				_, err := r.ApplyPatchWithOptions("some-dir/something.patch", patcher.ApplyPatchOptions{Strategy: "ours", SigningKey: "ABCD1234"})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(runCommands[0]).To(Equal("am --gpg-sign=ABCD1234 --3way some-dir/something.patch"))
+				Expect(runCommands[len(runCommands)-1]).To(Equal("am --gpg-sign=ABCD1234 --continue"))
+			})
+
+			Context("when the resolution leaves HEAD unchanged", func() {
+				It("skips the patch instead of continuing", func() {
+					matchesHead = true
+
+					_, err := r.ApplyPatchWithOptions("some-dir/something.patch", patcher.ApplyPatchOptions{Strategy: "ours"})
+					Expect(err).NotTo(HaveOccurred())
+					Expect(runCommands[len(runCommands)-1]).To(Equal("am --skip"))
+				})
+
+				It("keeps signing turned off for the patches after it", func() {
+					matchesHead = true
+
+					_, err := r.ApplyPatchWithOptions("some-dir/something.patch", patcher.ApplyPatchOptions{Strategy: "ours", NoSigning: true})
+					Expect(err).NotTo(HaveOccurred())
+					Expect(runCommands[len(runCommands)-1]).To(Equal("am --no-gpg-sign --skip"))
+				})
+			})
+
+			Context("when the am cannot be continued", func() {
+				It("aborts the am", func() {
+					continueError = errors.New("no changes")
+
+					_, err := r.ApplyPatchWithOptions("some-dir/something.patch", patcher.ApplyPatchOptions{Strategy: "ours"})
+					Expect(err).To(MatchError("no changes"))
+					Expect(runCommands[len(runCommands)-1]).To(Equal("am --abort"))
+				})
+			})
+		})
+
+		Context("when the patch fails without conflicts", func() {
+			It("aborts the am and returns the error", func() {
+				runner.RunCall.Returns.Errors = []error{errors.New("meow")}
+
+				_, err := r.ApplyPatchWithOptions("some-dir/something.patch", patcher.ApplyPatchOptions{Strategy: "ours"})
+				Expect(err).To(MatchError("meow"))
+				Expect(runner.RunCall.Receives.Commands[1:]).To(Equal([]patcher.Command{
+					patcher.Command{
+						Args: []string{"am", "--abort"},
+						Dir:  "/some/repo",
+					},
+				}))
+			})
+		})
+
+		Context("when the strategy is unknown", func() {
+			It("returns an error without applying the patch", func() {
+				_, err := r.ApplyPatchWithOptions("some-dir/something.patch", patcher.ApplyPatchOptions{Strategy: "recursive"})
+				Expect(err).To(MatchError(`Unknown conflict resolution strategy "recursive", expected "ours" or "theirs"`))
+				Expect(runner.RunCall.Receives.Commands).To(BeEmpty())
+			})
+		})
+	})
+
+	Context("when checking the patch encoding", func() {
+		var patchPath string
+
+		BeforeEach(func() {
+			tempDir, err := ioutil.TempDir("", "")
+			Expect(err).NotTo(HaveOccurred())
+			patchPath = filepath.Join(tempDir, "something.patch")
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(filepath.Dir(patchPath))).To(Succeed())
+		})
+
+		writePatch := func(headers string) {
+			err := ioutil.WriteFile(patchPath, []byte(headers+"\n\n---\n some diff\n"), 0644)
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		It("applies patches with a declared charset", func() {
+			writePatch("From: Jos\xe9 <jose@example.com>\nContent-Type: text/plain; charset=ISO-8859-1")
+
+			_, err := r.ApplyPatchWithOptions(patchPath, patcher.ApplyPatchOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(runner.RunCall.Count).To(Equal(1))
+		})
+
+		Context("when the headers are not UTF-8 and no charset is declared", func() {
+			BeforeEach(func() {
+				writePatch("From: Jos\xe9 <jose@example.com>\nSubject: [PATCH] Fix things")
+			})
+
+			It("returns a PatchEncodingError without applying the patch", func() {
+				_, err := r.ApplyPatchWithOptions(patchPath, patcher.ApplyPatchOptions{})
+				Expect(err).To(BeAssignableToTypeOf(patcher.PatchEncodingError{}))
+				Expect(err).To(MatchError(ContainSubstring("headers are not valid UTF-8")))
+				Expect(runner.RunCall.Receives.Commands).To(BeEmpty())
+			})
+
+			Context("when an encoding is given", func() {
+				It("applies the patch with that commit encoding", func() {
+					_, err := r.ApplyPatchWithOptions(patchPath, patcher.ApplyPatchOptions{Encoding: "ISO-8859-1"})
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(runner.RunCall.Receives.Commands).To(Equal([]patcher.Command{
+						patcher.Command{
+							Args: []string{
+								"-c", "i18n.commitEncoding=ISO-8859-1",
+								"-c", "user.name=testbot",
+								"-c", "user.email=foo@example.com",
+								"am",
+								patchPath,
+							},
+							Dir: "/some/repo",
+						},
+					}))
+				})
+			})
+		})
+
+		Context("when the transfer encoding is not supported", func() {
+			It("returns a PatchEncodingError", func() {
+				writePatch("From: Someone <someone@example.com>\nContent-Transfer-Encoding: x-uuencode")
+
+				_, err := r.ApplyPatchWithOptions(patchPath, patcher.ApplyPatchOptions{})
+				Expect(err).To(MatchError(fmt.Sprintf(`Patch %q has an encoding problem: unsupported Content-Transfer-Encoding "x-uuencode"`, patchPath)))
+			})
+		})
+	})
+
+	Context("when reporting fuzz", func() {
+		var patchPath string
+
+		BeforeEach(func() {
+			tempDir, err := ioutil.TempDir("", "")
+			Expect(err).NotTo(HaveOccurred())
+
+			patchPath = filepath.Join(tempDir, "something.patch")
+			Expect(ioutil.WriteFile(patchPath, []byte(`Subject: [PATCH] Change things
+
+---
+diff --git a/some-file.txt b/some-file.txt
+--- a/some-file.txt
++++ b/some-file.txt
+@@ -10,7 +10,7 @@
+ one
+ two
+ three
+-four
++FOUR
+ five
+ six
+ seven
+diff --git a/other-file.txt b/other-file.txt
+--- a/other-file.txt
++++ b/other-file.txt
+@@ -3,7 +3,7 @@
+ 3
+ 4
+ 5
+-6
++six
+ 7
+ 8
+ 9
+`), 0644)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(filepath.Dir(patchPath))).To(Succeed())
+		})
+
+		It("returns the hunks that applied at an offset or with fuzz", func() {
+			runner.CombinedOutputCall.Returns.Errors = []error{nil}
+			runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte(`Checking patch some-file.txt...
+Hunk #1 succeeded at 12 (offset 2 lines).
+Checking patch other-file.txt...
+Hunk #1 succeeded at 4 (offset 2 lines).
+Context reduced to (2/2) to apply fragment at 4
+`)}
+
+			result, err := r.ApplyPatchWithOptions(patchPath, patcher.ApplyPatchOptions{ReportFuzz: true, Fuzz: 1})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.FuzzWarnings).To(Equal([]patcher.FuzzWarning{
+				{File: "some-file.txt", Hunk: 1, Line: 12, Offset: 2},
+				{File: "other-file.txt", Hunk: 1, Line: 4, Fuzz: 1},
+			}))
+
+			Expect(runner.CombinedOutputCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{"apply", "--check", "--verbose", "-C2", patchPath},
+					Dir:  "/some/repo",
+				},
+			}))
+			Expect(runner.RunCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{
+						"-c", "user.name=testbot",
+						"-c", "user.email=foo@example.com",
+						"am",
+						"-C2",
+						patchPath,
+					},
+					Dir: "/some/repo",
+				},
+			}))
+		})
+
+		Context("when the patch applies exactly", func() {
+			It("returns no warnings", func() {
+				runner.CombinedOutputCall.Returns.Errors = []error{nil}
+				runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte("Checking patch some-file.txt...\nApplied patch some-file.txt cleanly.\n")}
+
+				result, err := r.ApplyPatchWithOptions(patchPath, patcher.ApplyPatchOptions{ReportFuzz: true})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result.FuzzWarnings).To(BeEmpty())
+			})
+		})
+
+		Context("when the dry run fails", func() {
+			It("returns the error without applying the patch", func() {
+				runner.CombinedOutputCall.Returns.Errors = []error{errors.New("exit status 1")}
+				runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte("error: patch failed: some-file.txt:10\n")}
+
+				_, err := r.ApplyPatchWithOptions(patchPath, patcher.ApplyPatchOptions{ReportFuzz: true})
+				Expect(err).To(MatchError(fmt.Sprintf("Patch %q does not apply: exit status 1\nerror: patch failed: some-file.txt:10", patchPath)))
+				Expect(runner.RunCall.Receives.Commands).To(BeEmpty())
+			})
+		})
+
+		Context("when the fuzz is out of range", func() {
+			It("returns an error", func() {
+				_, err := r.ApplyPatchWithOptions(patchPath, patcher.ApplyPatchOptions{Fuzz: 4})
+				Expect(err).To(MatchError("Fuzz must be between 0 and 3, got 4"))
+				Expect(runner.RunCall.Receives.Commands).To(BeEmpty())
+			})
+		})
+	})
+
+	Context("when recording the patch as a note", func() {
+		var (
+			patchPath string
+			options   patcher.ApplyPatchOptions
+		)
+
+		BeforeEach(func() {
+			tempDir, err := ioutil.TempDir("", "")
+			Expect(err).NotTo(HaveOccurred())
+
+			patchPath = filepath.Join(tempDir, "something.patch")
+			err = ioutil.WriteFile(patchPath, []byte("some patch contents"), 0644)
+			Expect(err).NotTo(HaveOccurred())
+
+			options = patcher.ApplyPatchOptions{NotesRef: "knit"}
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(filepath.Dir(patchPath))).To(Succeed())
+		})
+
+		It("adds a note with the patch name and checksum to the new commit", func() {
+			_, err := r.ApplyPatchWithOptions(patchPath, options)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(runner.RunCall.Receives.Commands).To(HaveLen(2))
+			Expect(runner.RunCall.Receives.Commands[1]).To(Equal(patcher.Command{
+				Args: []string{
+					"-c", "user.name=testbot",
+					"-c", "user.email=foo@example.com",
+					"notes", "--ref=knit", "add", "-f",
+					"-m", "Knit patch: something.patch\nSHA1: 4977dac5f971edf3e8550578d774a444e5881132",
+					"HEAD",
+				},
+				Dir: "/some/repo",
+			}))
+		})
+
+		Context("when adding the note fails", func() {
+			It("returns an error explaining the patch was still applied", func() {
+				runner.RunCall.Returns.Errors = []error{nil, errors.New("meow")}
+
+				_, err := r.ApplyPatchWithOptions(patchPath, options)
+				Expect(err).To(MatchError(ContainSubstring("was applied but could not be recorded as a note: meow")))
+			})
+		})
+	})
+
+	Context("when git am fails", func() {
+		It("returns an error", func() {
+			runner.RunCall.Returns.Errors = []error{errors.New("meow")}
+
+			_, err := r.ApplyPatchWithOptions("some-dir/something.patch", patcher.ApplyPatchOptions{})
+			Expect(err).To(MatchError("meow"))
+		})
+	})
+})
+
+var _ = Describe("ApplyPatchKeepingConflictMarkers", func() {
+	var (
+		runner *fakes.CommandRunner
+		r      patcher.Repo
+	)
+
+	BeforeEach(func() {
+		runner = &fakes.CommandRunner{}
+		r = patcher.NewRepo(runner, "/some/repo", "testbot", "foo@example.com")
+	})
+
+	It("applies the patch with a 3-way merge without committing", func() {
+		conflicts, err := r.ApplyPatchKeepingConflictMarkers("some-dir/something.patch")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conflicts).To(BeEmpty())
+
+		Expect(runner.CombinedOutputCall.Receives.Commands).To(Equal([]patcher.Command{
+			patcher.Command{
+				Args: []string{"apply", "--3way", "some-dir/something.patch"},
+				Dir:  "/some/repo",
+			},
+		}))
+		Expect(runner.RunCall.Receives.Commands).To(BeEmpty())
+	})
+
+	Context("when the patch conflicts", func() {
+		It("leaves the markers in place and returns the conflicted paths", func() {
+			runner.CombinedOutputCall.Returns.Errors = []error{errors.New("exit status 1"), nil}
+			runner.CombinedOutputCall.Returns.Outputs = [][]byte{
+				[]byte("error: patch failed: some-file.txt:3\nApplied patch to 'some-file.txt' with conflicts.\n"),
+				[]byte("some-file.txt\n"),
+			}
+
+			conflicts, err := r.ApplyPatchKeepingConflictMarkers("some-dir/something.patch")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(conflicts).To(Equal([]string{"some-file.txt"}))
+		})
+	})
+
+	Context("when the patch cannot be applied at all", func() {
+		It("returns an error with git's output", func() {
+			runner.CombinedOutputCall.Returns.Errors = []error{errors.New("exit status 128"), nil}
+			runner.CombinedOutputCall.Returns.Outputs = [][]byte{
+				[]byte("error: repository lacks the necessary blob to perform 3-way merge.\n"),
+				[]byte(""),
+			}
+
+			_, err := r.ApplyPatchKeepingConflictMarkers("some-dir/something.patch")
+			Expect(err).To(MatchError("Patch \"some-dir/something.patch\" could not be applied: exit status 128\nerror: repository lacks the necessary blob to perform 3-way merge."))
+		})
+	})
+})
+
+var _ = Describe("ApplyPatchInteractive", func() {
+	var (
+		runner   *fakes.CommandRunner
+		repoPath string
+		r        patcher.Repo
+		am       patcher.Command
+	)
+
+	BeforeEach(func() {
+		var err error
+		repoPath, err = ioutil.TempDir("", "")
+		Expect(err).NotTo(HaveOccurred())
+
+		err = ioutil.WriteFile(filepath.Join(repoPath, "Gemfile.lock"), []byte("<<<<<<< ours\na\n=======\nb\n>>>>>>> theirs\n"), 0644)
+		Expect(err).NotTo(HaveOccurred())
+
+		runner = &fakes.CommandRunner{}
+		runner.RunCall.Returns.Errors = []error{errors.New("patch does not apply")}
+		runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte("Gemfile.lock\n")}
+		runner.CombinedOutputCall.Returns.Errors = []error{nil}
+
+		r = patcher.NewRepo(runner, repoPath, "testbot", "foo@example.com")
+		am = patcher.Command{
+			Args: []string{"-c", "user.name=testbot", "-c", "user.email=foo@example.com", "am", "--3way", "some.patch"},
+			Dir:  repoPath,
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(repoPath)).To(Succeed())
+	})
+
+	It("writes the resolved contents, stages them and continues the am", func() {
+		var received map[string][]byte
+		err := r.ApplyPatchInteractive("some.patch", func(conflicts map[string][]byte) (map[string][]byte, error) {
+			received = conflicts
+			return map[string][]byte{"Gemfile.lock": []byte("regenerated\n")}, nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(received).To(Equal(map[string][]byte{
+			"Gemfile.lock": []byte("<<<<<<< ours\na\n=======\nb\n>>>>>>> theirs\n"),
+		}))
+		Expect(ioutil.ReadFile(filepath.Join(repoPath, "Gemfile.lock"))).To(Equal([]byte("regenerated\n")))
+
+		Expect(runner.RunCall.Receives.Commands).To(Equal([]patcher.Command{
+			am,
+			patcher.Command{
+				Args: []string{"add", "--", "Gemfile.lock"},
+				Dir:  repoPath,
+			},
+			patcher.Command{
+				Args: []string{"-c", "user.name=testbot", "-c", "user.email=foo@example.com", "am", "--continue"},
+				Dir:  repoPath,
+			},
+		}))
+	})
+
+	It("does not call resolve when the patch applies cleanly", func() {
+		runner.RunCall.Returns.Errors = nil
+
+		err := r.ApplyPatchInteractive("some.patch", func(map[string][]byte) (map[string][]byte, error) {
+			Fail("resolve should not be called")
+			return nil, nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(runner.RunCall.Receives.Commands).To(Equal([]patcher.Command{am}))
+	})
+
+	Context("when resolve fails", func() {
+		It("aborts the am", func() {
+			err := r.ApplyPatchInteractive("some.patch", func(map[string][]byte) (map[string][]byte, error) {
+				return nil, errors.New("lockfile could not be regenerated")
+			})
+			Expect(err).To(MatchError(`Conflicts in patch "some.patch" were not resolved: lockfile could not be regenerated`))
+
+			Expect(runner.RunCall.Receives.Commands).To(Equal([]patcher.Command{
+				am,
+				patcher.Command{
+					Args: []string{"am", "--abort"},
+					Dir:  repoPath,
+				},
+			}))
+		})
+	})
+
+	Context("when resolve leaves a conflict unresolved", func() {
+		It("aborts the am", func() {
+			err := r.ApplyPatchInteractive("some.patch", func(map[string][]byte) (map[string][]byte, error) {
+				return map[string][]byte{}, nil
+			})
+			Expect(err).To(MatchError(`Conflicts in patch "some.patch" were not resolved: no resolution for Gemfile.lock`))
+			Expect(runner.RunCall.Receives.Commands[1].Args).To(Equal([]string{"am", "--abort"}))
+		})
+	})
+})
+
+var _ = Describe("ApplyPatchWithOptions with FixWhitespace", func() {
+	var (
+		runner *fakes.CommandRunner
+		r      patcher.Repo
+	)
+
+	BeforeEach(func() {
+		runner = &fakes.CommandRunner{}
+		runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte(`Applying: Add some feature
+.git/rebase-apply/patch:14: trailing whitespace.
++some line 
+.git/rebase-apply/patch:20: space before tab in indent.
++ 	indented
+warning: 2 lines applied after fixing whitespace errors.
+`)}
+		runner.CombinedOutputCall.Returns.Errors = []error{nil}
+
+		r = patcher.NewRepo(runner, "/some/repo", "testbot", "foo@example.com")
+	})
+
+	It("fixes whitespace while applying and reports the fixed lines", func() {
+		result, err := r.ApplyPatchWithOptions("some.patch", patcher.ApplyPatchOptions{FixWhitespace: true})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(runner.CombinedOutputCall.Receives.Commands).To(Equal([]patcher.Command{
+			patcher.Command{
+				Args: []string{"-c", "user.name=testbot", "-c", "user.email=foo@example.com", "am", "--whitespace=fix", "some.patch"},
+				Dir:  "/some/repo",
+			},
+		}))
+		Expect(runner.RunCall.Receives.Commands).To(BeEmpty())
+
+		Expect(result.WhitespaceFixes).To(Equal([]patcher.WhitespaceFix{
+			{Line: 14, Problem: "trailing whitespace"},
+			{Line: 20, Problem: "space before tab in indent"},
+		}))
+	})
+
+	Context("when the am fails", func() {
+		It("returns an error with git's output", func() {
+			runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte("Applying: Add some feature\nerror: patch failed: file:1\n")}
+			runner.CombinedOutputCall.Returns.Errors = []error{errors.New("patch does not apply")}
+
+			_, err := r.ApplyPatchWithOptions("some.patch", patcher.ApplyPatchOptions{FixWhitespace: true})
+			Expect(err).To(MatchError("patch does not apply\nApplying: Add some feature\nerror: patch failed: file:1"))
+		})
+	})
+})
+
+var _ = Describe("ApplyPatchWithOptions with commit signing", func() {
+	var (
+		runner *fakes.CommandRunner
+		r      patcher.Repo
+	)
+
+	BeforeEach(func() {
+		runner = &fakes.CommandRunner{}
+		r = patcher.NewRepo(runner, "/some/repo", "testbot", "foo@example.com")
+	})
+
+	It("signs the commit with the given key", func() {
+		_, err := r.ApplyPatchWithOptions("some.patch", patcher.ApplyPatchOptions{SigningKey: "ABCD1234"})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(runner.RunCall.Receives.Commands[0].Args).To(Equal([]string{
+			"-c", "user.name=testbot",
+			"-c", "user.email=foo@example.com",
+			"am", "--gpg-sign=ABCD1234", "some.patch",
+		}))
+	})
+
+	It("can turn signing off", func() {
+		_, err := r.ApplyPatchWithOptions("some.patch", patcher.ApplyPatchOptions{NoSigning: true})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(runner.RunCall.Receives.Commands[0].Args).To(Equal([]string{
+			"-c", "user.name=testbot",
+			"-c", "user.email=foo@example.com",
+			"am", "--no-gpg-sign", "some.patch",
+		}))
+	})
+
+	It("does not pass signing flags to git apply when commits are deferred", func() {
+		_, err := r.WithDeferredCommits().ApplyPatchWithOptions("some.patch", patcher.ApplyPatchOptions{SigningKey: "ABCD1234"})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(runner.RunCall.Receives.Commands[0].Args).To(Equal([]string{"apply", "--index", "some.patch"}))
+	})
+
+	Context("when both a key and no signing are requested", func() {
+		It("returns an error", func() {
+			_, err := r.ApplyPatchWithOptions("some.patch", patcher.ApplyPatchOptions{SigningKey: "ABCD1234", NoSigning: true})
+			Expect(err).To(MatchError(`Patch "some.patch" cannot be applied both signed and unsigned`))
+			Expect(runner.RunCall.Receives.Commands).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,60 @@
+package patcher
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// PatchResolver opens the patch named by ref, e.g. a digest in a registry.
+type PatchResolver interface {
+	Resolve(ref string) (io.ReadCloser, error)
+}
+
+// FilePatchResolver treats refs as paths on disk, which is how patches are
+// found when no resolver is configured.
+type FilePatchResolver struct{}
+
+func (FilePatchResolver) Resolve(ref string) (io.ReadCloser, error) {
+	return os.Open(ref)
+}
+
+// WithPatchResolver makes ApplyPatch and PatchSubmodule look their patch
+// arguments up through resolver instead of reading them from disk.
+func (r Repo) WithPatchResolver(resolver PatchResolver) Repo {
+	r.patchResolver = resolver
+	return r
+}
+
+// withResolvedPatch stages the patch named by ref on disk and verifies its
+// signature before calling f with the staged path. Anything that reports on
+// the patch should keep using ref.
+func (r Repo) withResolvedPatch(ref string, f func(path string) error) error {
+	if err := r.requireLocal("Resolving a patch"); err != nil {
+		return err
+	}
+
+	patch, err := r.patchResolver.Resolve(ref)
+	if err != nil {
+		return err
+	}
+	defer patch.Close()
+
+	patchFile, err := ioutil.TempFile(r.tempDir, "knit-patch")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(patchFile.Name())
+
+	_, err = io.Copy(patchFile, patch)
+	patchFile.Close()
+	if err != nil {
+		return err
+	}
+
+	if err := r.verifyPatchSignature(ref, patchFile.Name()); err != nil {
+		return err
+	}
+
+	return f(patchFile.Name())
+}
@@ -0,0 +1,171 @@
+package patcher_test
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pivotal-cf/knit/patcher"
+	"github.com/pivotal-cf/knit/patcher/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakePatchResolver struct {
+	patches  map[string]string
+	resolved []string
+}
+
+func (f *fakePatchResolver) Resolve(ref string) (io.ReadCloser, error) {
+	f.resolved = append(f.resolved, ref)
+
+	patch, ok := f.patches[ref]
+	if !ok {
+		return nil, errors.New("manifest unknown")
+	}
+
+	return ioutil.NopCloser(strings.NewReader(patch)), nil
+}
+
+var _ = Describe("WithPatchResolver", func() {
+	var (
+		runner   *fakes.CommandRunner
+		resolver *fakePatchResolver
+		r        patcher.Repo
+	)
+
+	BeforeEach(func() {
+		runner = &fakes.CommandRunner{}
+		resolver = &fakePatchResolver{
+			patches: map[string]string{
+				"registry.example.com/patches@sha256:abc": "some patch contents",
+			},
+		}
+		r = patcher.NewRepo(runner, "/some/repo", "testbot", "foo@example.com").WithPatchResolver(resolver)
+	})
+
+	Describe("ApplyPatch", func() {
+		It("applies the resolved patch", func() {
+			var applied string
+			runner.RunCall.Stub = func(command patcher.Command) error {
+				contents, err := ioutil.ReadFile(command.Args[len(command.Args)-1])
+				applied = string(contents)
+				return err
+			}
+
+			err := r.ApplyPatch("registry.example.com/patches@sha256:abc")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(resolver.resolved).To(Equal([]string{"registry.example.com/patches@sha256:abc"}))
+			Expect(applied).To(Equal("some patch contents"))
+		})
+
+		It("cleans up the staged patch", func() {
+			err := r.ApplyPatch("registry.example.com/patches@sha256:abc")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(runner.RunCall.Receives.Commands[0].Args[len(runner.RunCall.Receives.Commands[0].Args)-1]).NotTo(BeAnExistingFile())
+		})
+
+		It("records the ref in the patch note", func() {
+			_, err := r.ApplyPatchWithOptions("registry.example.com/patches@sha256:abc", patcher.ApplyPatchOptions{NotesRef: "knit"})
+			Expect(err).NotTo(HaveOccurred())
+
+			notes := runner.RunCall.Receives.Commands[len(runner.RunCall.Receives.Commands)-1]
+			Expect(notes.Args).To(ContainElement(fmt.Sprintf("Knit patch: patches@sha256:abc\nSHA1: %x", sha1.Sum([]byte("some patch contents")))))
+		})
+
+		Context("when signatures are verified", func() {
+			var gpgRunner *fakes.CommandRunner
+
+			BeforeEach(func() {
+				gpgRunner = &fakes.CommandRunner{}
+				r = r.WithSignatureVerification(gpgRunner, "/keys/trusted.gpg")
+			})
+
+			It("resolves the signature next to the ref and checks the staged patch against it", func() {
+				resolver.patches["registry.example.com/patches@sha256:abc.asc"] = "some signature"
+
+				var signature, patch string
+				gpgRunner.CombinedOutputCall.Stub = func(command patcher.Command) ([]byte, error) {
+					signatureContents, err := ioutil.ReadFile(command.Args[len(command.Args)-2])
+					Expect(err).NotTo(HaveOccurred())
+					patchContents, err := ioutil.ReadFile(command.Args[len(command.Args)-1])
+					Expect(err).NotTo(HaveOccurred())
+
+					signature, patch = string(signatureContents), string(patchContents)
+					return nil, nil
+				}
+
+				err := r.ApplyPatch("registry.example.com/patches@sha256:abc")
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(resolver.resolved).To(Equal([]string{
+					"registry.example.com/patches@sha256:abc",
+					"registry.example.com/patches@sha256:abc.asc",
+				}))
+				Expect(signature).To(Equal("some signature"))
+				Expect(patch).To(Equal("some patch contents"))
+				Expect(runner.RunCall.Count).To(Equal(1))
+			})
+
+			Context("when the ref has no signature", func() {
+				It("returns a SignatureError naming the ref", func() {
+					err := r.ApplyPatch("registry.example.com/patches@sha256:abc")
+					Expect(err).To(MatchError(`Patch "registry.example.com/patches@sha256:abc" failed signature verification: no signature found at registry.example.com/patches@sha256:abc.asc: manifest unknown`))
+					Expect(runner.RunCall.Receives.Commands).To(BeEmpty())
+				})
+			})
+		})
+
+		Context("when the patch cannot be resolved", func() {
+			It("returns an error", func() {
+				err := r.ApplyPatch("registry.example.com/patches@sha256:missing")
+				Expect(err).To(MatchError("manifest unknown"))
+				Expect(runner.RunCall.Receives.Commands).To(BeEmpty())
+			})
+		})
+	})
+
+	Describe("PatchSubmodule", func() {
+		It("streams the resolved patch into git am in the submodule", func() {
+			err := r.PatchSubmodule("src/some/submodule", "registry.example.com/patches@sha256:abc")
+			Expect(err).NotTo(HaveOccurred())
+
+			command := runner.RunCall.Receives.Commands[0]
+			Expect(command.Args).To(Equal([]string{
+				"-c", "user.name=testbot",
+				"-c", "user.email=foo@example.com",
+				"am",
+			}))
+			Expect(command.Dir).To(Equal(filepath.Join("/some/repo", "src/some/submodule")))
+			Expect(command.Stdin).NotTo(BeNil())
+		})
+	})
+
+	Describe("FilePatchResolver", func() {
+		It("opens the ref as a path", func() {
+			patchFile, err := ioutil.TempFile("", "")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.Remove(patchFile.Name())
+
+			_, err = patchFile.WriteString("some patch contents")
+			Expect(err).NotTo(HaveOccurred())
+			patchFile.Close()
+
+			patch, err := patcher.FilePatchResolver{}.Resolve(patchFile.Name())
+			Expect(err).NotTo(HaveOccurred())
+			defer patch.Close()
+
+			contents, err := ioutil.ReadAll(patch)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(contents)).To(Equal("some patch contents"))
+		})
+	})
+})
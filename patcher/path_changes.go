@@ -0,0 +1,139 @@
+package patcher
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+type PathMismatchError struct {
+	Patch string
+	Paths []string
+}
+
+func (e PathMismatchError) Error() string {
+	return fmt.Sprintf("Patch %q applied but did not remove or rename away: %s", e.Patch, strings.Join(e.Paths, ", "))
+}
+
+// expectedRemovals returns the paths the patch deletes or renames away, and
+// the commit to compare against once it has applied. Nothing is returned for
+// a patch that removes nothing, or one that cannot be read locally.
+func (r Repo) expectedRemovals(patch string) ([]string, string) {
+	if remoteHost(r.runner) != "" {
+		return nil, ""
+	}
+
+	contents, err := ioutil.ReadFile(patch)
+	if err != nil {
+		return nil, ""
+	}
+
+	paths := removedPaths(string(contents))
+	if len(paths) == 0 {
+		return nil, ""
+	}
+
+	stdout := &bytes.Buffer{}
+	err = r.run(Command{
+		Args:   []string{"rev-parse", "-q", "--verify", "HEAD"},
+		Dir:    r.repo,
+		Stdout: stdout,
+	})
+	if err != nil {
+		return nil, ""
+	}
+
+	return paths, strings.TrimSpace(stdout.String())
+}
+
+// verifyPathChanges checks that every path the patch deletes or renames away
+// shows up as deleted between before and what was applied, which git am does
+// not guarantee for patches generated without rename headers.
+func (r Repo) verifyPathChanges(patch, before string, removed []string) error {
+	if len(removed) == 0 {
+		return nil
+	}
+
+	args := []string{"diff", "--name-status", "--no-renames", "-z", before, "HEAD"}
+	if r.deferCommits {
+		args = []string{"diff", "--cached", "--name-status", "--no-renames", "-z", before}
+	}
+
+	stdout := &bytes.Buffer{}
+	err := r.run(Command{
+		Args:   args,
+		Dir:    r.repo,
+		Stdout: stdout,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Nothing was recorded when the patch ended up skipped.
+	if stdout.Len() == 0 && !r.deferCommits {
+		return nil
+	}
+
+	deleted := map[string]bool{}
+	fields := strings.Split(strings.TrimRight(stdout.String(), "\x00"), "\x00")
+	for i := 0; i+1 < len(fields); i += 2 {
+		if fields[i] == "D" {
+			deleted[fields[i+1]] = true
+		}
+	}
+
+	var remaining []string
+	for _, path := range removed {
+		if !deleted[path] {
+			remaining = append(remaining, path)
+		}
+	}
+
+	if len(remaining) > 0 {
+		return PathMismatchError{Patch: patch, Paths: remaining}
+	}
+
+	return nil
+}
+
+// removedPaths returns the paths the patch deletes or renames away and does
+// not create again, e.g. in a later patch of the same mbox.
+func removedPaths(patch string) []string {
+	var (
+		removed []string
+		added   = map[string]bool{}
+		current string
+	)
+
+	for _, line := range strings.Split(patch, "\n") {
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			current = ""
+			if index := strings.LastIndex(line, " b/"); index != -1 {
+				current = strings.TrimPrefix(line[len("diff --git "):index], "a/")
+			}
+		case strings.HasPrefix(line, "rename from "):
+			removed = append(removed, strings.TrimPrefix(line, "rename from "))
+		case strings.HasPrefix(line, "rename to "):
+			added[strings.TrimPrefix(line, "rename to ")] = true
+		case strings.HasPrefix(line, "new file mode "), line == "--- /dev/null":
+			if current != "" {
+				added[current] = true
+			}
+		case strings.HasPrefix(line, "deleted file mode "), line == "+++ /dev/null":
+			if current != "" && (len(removed) == 0 || removed[len(removed)-1] != current) {
+				removed = append(removed, current)
+			}
+		}
+	}
+
+	var paths []string
+	for _, path := range removed {
+		if !added[path] {
+			paths = append(paths, path)
+		}
+	}
+
+	return paths
+}
@@ -0,0 +1,101 @@
+package patcher_test
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pivotal-cf/knit/patcher"
+	"github.com/pivotal-cf/knit/patcher/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ApplyPatch path changes", func() {
+	const patch = "fixtures/rename_and_delete.patch"
+
+	var (
+		runner     *fakes.CommandRunner
+		r          patcher.Repo
+		nameStatus string
+	)
+
+	BeforeEach(func() {
+		runner = &fakes.CommandRunner{}
+		runner.RunCall.Stub = func(command patcher.Command) error {
+			switch command.Args[0] {
+			case "rev-parse":
+				fmt.Fprintln(command.Stdout, "before-sha")
+			case "diff":
+				fmt.Fprint(command.Stdout, nameStatus)
+			}
+			return nil
+		}
+
+		r = patcher.NewRepo(runner, "/some/repo", "testbot", "foo@example.com")
+	})
+
+	Context("when the renamed and deleted paths are gone", func() {
+		It("succeeds", func() {
+			nameStatus = strings.Join([]string{"D", "config/old.yml", "A", "config/new.yml", "D", "scripts/old.sh", ""}, "\x00")
+
+			err := r.ApplyPatch(patch)
+			Expect(err).NotTo(HaveOccurred())
+
+			commands := runner.RunCall.Receives.Commands
+			Expect(commands[0].Args).To(Equal([]string{"rev-parse", "-q", "--verify", "HEAD"}))
+			Expect(commands[2].Args).To(Equal([]string{"diff", "--name-status", "--no-renames", "-z", "before-sha", "HEAD"}))
+		})
+	})
+
+	Context("when a path the patch removes is still there", func() {
+		It("returns a PathMismatchError", func() {
+			nameStatus = strings.Join([]string{"A", "config/new.yml", ""}, "\x00")
+
+			err := r.ApplyPatch(patch)
+			Expect(err).To(Equal(patcher.PathMismatchError{
+				Patch: patch,
+				Paths: []string{"config/old.yml", "scripts/old.sh"},
+			}))
+			Expect(err).To(MatchError(fmt.Sprintf("Patch %q applied but did not remove or rename away: config/old.yml, scripts/old.sh", patch)))
+		})
+	})
+
+	Context("when the patch also changes a forbidden path", func() {
+		It("reverts the patch and returns the PolicyViolationError", func() {
+			nameStatus = strings.Join([]string{"A", "config/new.yml", ""}, "\x00")
+			stub := runner.RunCall.Stub
+			runner.RunCall.Stub = func(command patcher.Command) error {
+				if command.Args[0] == "apply" {
+					fmt.Fprint(command.Stdout, strings.Join([]string{"1\t0\tconfig/new.yml", "0\t1\tscripts/old.sh", ""}, "\x00"))
+					return nil
+				}
+				return stub(command)
+			}
+
+			_, err := r.ApplyPatchWithOptions(patch, patcher.ApplyPatchOptions{ForbiddenPaths: []string{"scripts/*"}})
+			Expect(err).To(Equal(patcher.PolicyViolationError{
+				Patch: patch,
+				Paths: []string{"scripts/old.sh"},
+			}))
+
+			var args [][]string
+			for _, command := range runner.RunCall.Receives.Commands {
+				args = append(args, command.Args)
+			}
+			Expect(args).To(ContainElement([]string{"reset", "--keep", "before-sha"}))
+		})
+	})
+
+	Context("when commits are deferred", func() {
+		It("compares the index with the starting commit", func() {
+			nameStatus = strings.Join([]string{"D", "config/old.yml", "A", "config/new.yml", "D", "scripts/old.sh", ""}, "\x00")
+
+			err := r.WithDeferredCommits().ApplyPatch(patch)
+			Expect(err).NotTo(HaveOccurred())
+
+			commands := runner.RunCall.Receives.Commands
+			Expect(commands[2].Args).To(Equal([]string{"diff", "--cached", "--name-status", "--no-renames", "-z", "before-sha"}))
+		})
+	})
+})
@@ -0,0 +1,79 @@
+package patcher
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const (
+	PreflightClean    = "clean"
+	PreflightThreeWay = "3way"
+	PreflightFails    = "fails"
+)
+
+type PreflightResult struct {
+	Patch  string
+	Status string
+}
+
+// PreflightSeries reports whether each patch in the series would apply
+// cleanly, only with a 3-way merge, or not at all, without touching the
+// working tree or index. Patches are checked against a throwaway index that
+// each applicable patch is added to, so later patches see the earlier ones.
+func (r Repo) PreflightSeries(patches []string) ([]PreflightResult, error) {
+	if err := r.requireLocal("PreflightSeries"); err != nil {
+		return nil, err
+	}
+
+	indexDir, err := ioutil.TempDir(r.tempDir, "knit-preflight")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(indexDir)
+
+	env := []string{fmt.Sprintf("GIT_INDEX_FILE=%s", filepath.Join(indexDir, "index"))}
+
+	_, err = r.combinedOutput(Command{
+		Args: []string{"read-tree", "HEAD"},
+		Dir:  r.repo,
+		Env:  env,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var results []PreflightResult
+	for _, patch := range patches {
+		status := PreflightFails
+		for _, attempt := range []struct {
+			status string
+			args   []string
+		}{
+			{status: PreflightClean, args: []string{"apply", "--cached"}},
+			{status: PreflightThreeWay, args: []string{"apply", "--cached", "--3way"}},
+		} {
+			_, err := r.combinedOutput(Command{
+				Args: append(attempt.args, "--check", patch),
+				Dir:  r.repo,
+				Env:  env,
+			})
+			if err != nil {
+				continue
+			}
+
+			status = attempt.status
+			r.combinedOutput(Command{
+				Args: append(attempt.args, patch),
+				Dir:  r.repo,
+				Env:  env,
+			})
+			break
+		}
+
+		results = append(results, PreflightResult{Patch: patch, Status: status})
+	}
+
+	return results, nil
+}
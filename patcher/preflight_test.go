@@ -0,0 +1,74 @@
+package patcher_test
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/pivotal-cf/knit/patcher"
+	"github.com/pivotal-cf/knit/patcher/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PreflightSeries", func() {
+	var (
+		runner *fakes.CommandRunner
+		r      patcher.Repo
+	)
+
+	BeforeEach(func() {
+		runner = &fakes.CommandRunner{}
+		runner.CombinedOutputCall.Stub = func(command patcher.Command) ([]byte, error) {
+			args := strings.Join(command.Args, " ")
+			switch {
+			case strings.Contains(args, "--check conflicting.patch") && !strings.Contains(args, "--3way"),
+				strings.Contains(args, "--check broken.patch"):
+				return []byte("error: patch failed"), errors.New("exit status 1")
+			}
+			return []byte{}, nil
+		}
+
+		r = patcher.NewRepo(runner, "/some/repo", "testbot", "foo@example.com")
+	})
+
+	It("classifies each patch in the series", func() {
+		results, err := r.PreflightSeries([]string{"clean.patch", "conflicting.patch", "broken.patch"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(results).To(Equal([]patcher.PreflightResult{
+			{Patch: "clean.patch", Status: patcher.PreflightClean},
+			{Patch: "conflicting.patch", Status: patcher.PreflightThreeWay},
+			{Patch: "broken.patch", Status: patcher.PreflightFails},
+		}))
+	})
+
+	It("only touches a throwaway index", func() {
+		_, err := r.PreflightSeries([]string{"clean.patch"})
+		Expect(err).NotTo(HaveOccurred())
+
+		commands := runner.CombinedOutputCall.Receives.Commands
+		Expect(commands).To(HaveLen(3))
+		Expect(commands[0].Args).To(Equal([]string{"read-tree", "HEAD"}))
+		Expect(commands[1].Args).To(Equal([]string{"apply", "--cached", "--check", "clean.patch"}))
+		Expect(commands[2].Args).To(Equal([]string{"apply", "--cached", "clean.patch"}))
+
+		for _, command := range commands {
+			Expect(command.Env).To(HaveLen(1))
+			Expect(command.Env[0]).To(MatchRegexp(`^GIT_INDEX_FILE=.*knit-preflight.*/index$`))
+			Expect(command.Env[0]).To(Equal(commands[0].Env[0]))
+		}
+
+		Expect(runner.RunCall.Receives.Commands).To(BeEmpty())
+	})
+
+	Context("when HEAD cannot be read into the throwaway index", func() {
+		It("returns an error", func() {
+			runner.CombinedOutputCall.Stub = func(patcher.Command) ([]byte, error) {
+				return nil, errors.New("meow")
+			}
+
+			_, err := r.PreflightSeries([]string{"clean.patch"})
+			Expect(err).To(MatchError("meow"))
+		})
+	})
+})
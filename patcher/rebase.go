@@ -0,0 +1,89 @@
+package patcher
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var ErrNoRebaseInProgress = errors.New("No rebase in progress")
+
+// ErrAmInProgress is returned by RebaseStatus when the rebase-apply state
+// belongs to a git am rather than a rebase.
+var ErrAmInProgress = errors.New("A git am is in progress, not a rebase")
+
+type RebaseState struct {
+	OntoSHA    string
+	CurrentSHA string
+	Unmerged   []string
+	Done       int
+	Total      int
+}
+
+// RebaseStatus describes the rebase the repository is stopped in, reading the
+// state git keeps under rebase-merge or rebase-apply in the git directory.
+func (r Repo) RebaseStatus() (RebaseState, error) {
+	if err := r.requireLocal("RebaseStatus"); err != nil {
+		return RebaseState{}, err
+	}
+
+	output, err := r.combinedOutput(Command{
+		Args: []string{"rev-parse", "--git-dir"},
+		Dir:  r.repo,
+	})
+	if err != nil {
+		return RebaseState{}, err
+	}
+
+	gitDir := strings.TrimSpace(string(output))
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(r.repo, gitDir)
+	}
+
+	stateFiles := []struct {
+		dir, current, done, total string
+	}{
+		{dir: "rebase-merge", current: "stopped-sha", done: "msgnum", total: "end"},
+		{dir: "rebase-apply", current: "original-commit", done: "next", total: "last"},
+	}
+
+	for _, files := range stateFiles {
+		stateDir := filepath.Join(gitDir, files.dir)
+		if _, err := os.Stat(stateDir); os.IsNotExist(err) {
+			continue
+		}
+
+		// git am shares rebase-apply with rebase and marks it with applying.
+		if _, err := os.Stat(filepath.Join(stateDir, "applying")); err == nil {
+			return RebaseState{}, ErrAmInProgress
+		}
+
+		state := RebaseState{
+			OntoSHA:    readStateFile(stateDir, "onto"),
+			CurrentSHA: readStateFile(stateDir, files.current),
+		}
+		state.Done, _ = strconv.Atoi(readStateFile(stateDir, files.done))
+		state.Total, _ = strconv.Atoi(readStateFile(stateDir, files.total))
+
+		state.Unmerged, err = r.Conflicts()
+		if err != nil {
+			return RebaseState{}, err
+		}
+
+		return state, nil
+	}
+
+	return RebaseState{}, ErrNoRebaseInProgress
+}
+
+func readStateFile(dir, name string) string {
+	contents, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(contents))
+}
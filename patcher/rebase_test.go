@@ -0,0 +1,128 @@
+package patcher_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pivotal-cf/knit/patcher"
+	"github.com/pivotal-cf/knit/patcher/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RebaseStatus", func() {
+	var (
+		runner   *fakes.CommandRunner
+		repoPath string
+		r        patcher.Repo
+	)
+
+	BeforeEach(func() {
+		var err error
+		repoPath, err = ioutil.TempDir("", "")
+		Expect(err).NotTo(HaveOccurred())
+
+		runner = &fakes.CommandRunner{}
+		runner.CombinedOutputCall.Returns.Errors = []error{nil, nil}
+		runner.CombinedOutputCall.Returns.Outputs = [][]byte{
+			[]byte(".git\n"),
+			[]byte("some-file.txt\nsrc/module-one\n"),
+		}
+
+		r = patcher.NewRepo(runner, repoPath, "testbot", "foo@example.com")
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(repoPath)).To(Succeed())
+	})
+
+	writeState := func(dir string, files map[string]string) {
+		stateDir := filepath.Join(repoPath, ".git", dir)
+		Expect(os.MkdirAll(stateDir, 0755)).To(Succeed())
+
+		for name, contents := range files {
+			Expect(ioutil.WriteFile(filepath.Join(stateDir, name), []byte(contents+"\n"), 0644)).To(Succeed())
+		}
+	}
+
+	Context("when an interactive or merge rebase is stopped", func() {
+		It("reads the state from rebase-merge", func() {
+			writeState("rebase-merge", map[string]string{
+				"onto":        "onto-sha",
+				"stopped-sha": "current-sha",
+				"msgnum":      "2",
+				"end":         "5",
+			})
+
+			state, err := r.RebaseStatus()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(state).To(Equal(patcher.RebaseState{
+				OntoSHA:    "onto-sha",
+				CurrentSHA: "current-sha",
+				Unmerged:   []string{"some-file.txt", "src/module-one"},
+				Done:       2,
+				Total:      5,
+			}))
+
+			Expect(runner.CombinedOutputCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{"rev-parse", "--git-dir"},
+					Dir:  repoPath,
+				},
+				patcher.Command{
+					Args: []string{"diff", "--name-only", "--diff-filter=U"},
+					Dir:  repoPath,
+				},
+			}))
+		})
+	})
+
+	Context("when an am-based rebase is stopped", func() {
+		It("reads the state from rebase-apply", func() {
+			writeState("rebase-apply", map[string]string{
+				"onto":            "onto-sha",
+				"original-commit": "current-sha",
+				"next":            "3",
+				"last":            "4",
+			})
+
+			state, err := r.RebaseStatus()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(state.CurrentSHA).To(Equal("current-sha"))
+			Expect(state.Done).To(Equal(3))
+			Expect(state.Total).To(Equal(4))
+		})
+	})
+
+	Context("when git am is stopped", func() {
+		It("returns ErrAmInProgress", func() {
+			writeState("rebase-apply", map[string]string{
+				"applying": "",
+				"next":     "1",
+				"last":     "2",
+			})
+
+			_, err := r.RebaseStatus()
+			Expect(err).To(Equal(patcher.ErrAmInProgress))
+		})
+	})
+
+	Context("when no rebase is in progress", func() {
+		It("returns ErrNoRebaseInProgress", func() {
+			_, err := r.RebaseStatus()
+			Expect(err).To(Equal(patcher.ErrNoRebaseInProgress))
+		})
+	})
+
+	Context("when the git directory cannot be found", func() {
+		It("returns an error", func() {
+			runner.CombinedOutputCall.Returns.Errors = []error{errors.New("meow")}
+
+			_, err := r.RebaseStatus()
+			Expect(err).To(MatchError("meow"))
+		})
+	})
+})
@@ -0,0 +1,71 @@
+package patcher
+
+import (
+	"fmt"
+	"strings"
+)
+
+type RefKind string
+
+const (
+	RefBranch RefKind = "branch"
+	RefTag    RefKind = "tag"
+	RefCommit RefKind = "commit"
+)
+
+type RefInfo struct {
+	SHA  string
+	Kind RefKind
+}
+
+// ResolveRef reports the commit ref points at and whether ref names a branch,
+// a tag or just a commit. Names that are both a tag and a branch resolve to
+// the tag, matching git's own precedence.
+func (r Repo) ResolveRef(ref string) (RefInfo, error) {
+	output, err := r.combinedOutput(Command{
+		Args: []string{"rev-parse", "--verify", "--quiet", fmt.Sprintf("%s^{commit}", ref)},
+		Dir:  r.repo,
+	})
+	if err != nil {
+		return RefInfo{}, fmt.Errorf("Ref %q does not exist", ref)
+	}
+
+	info := RefInfo{
+		SHA:  strings.TrimSpace(string(output)),
+		Kind: RefCommit,
+	}
+
+	output, err = r.combinedOutput(Command{
+		Args: []string{"show-ref", ref},
+		Dir:  r.repo,
+	})
+	if err != nil {
+		return info, nil
+	}
+
+	candidates := []struct {
+		prefix string
+		kind   RefKind
+	}{
+		{prefix: "refs/tags/", kind: RefTag},
+		{prefix: "refs/heads/", kind: RefBranch},
+		{prefix: "refs/remotes/", kind: RefBranch},
+	}
+
+	for _, candidate := range candidates {
+		for _, line := range splitLines(output) {
+			fields := strings.Fields(line)
+			if len(fields) != 2 {
+				continue
+			}
+
+			name := fields[1]
+			if name == candidate.prefix+ref || (name == ref && strings.HasPrefix(name, candidate.prefix)) {
+				info.Kind = candidate.kind
+				return info, nil
+			}
+		}
+	}
+
+	return info, nil
+}
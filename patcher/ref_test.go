@@ -0,0 +1,98 @@
+package patcher_test
+
+import (
+	"errors"
+
+	"github.com/pivotal-cf/knit/patcher"
+	"github.com/pivotal-cf/knit/patcher/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ResolveRef", func() {
+	var (
+		runner *fakes.CommandRunner
+		r      patcher.Repo
+	)
+
+	BeforeEach(func() {
+		runner = &fakes.CommandRunner{}
+		r = patcher.NewRepo(runner, "/some/repo", "testbot", "foo@example.com")
+	})
+
+	resolvesTo := func(showRef string) {
+		runner.CombinedOutputCall.Returns.Errors = []error{nil, nil}
+		runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte("abc123\n"), []byte(showRef)}
+	}
+
+	It("identifies branches", func() {
+		resolvesTo("abc123 refs/heads/main\n")
+
+		info, err := r.ResolveRef("main")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info).To(Equal(patcher.RefInfo{SHA: "abc123", Kind: patcher.RefBranch}))
+
+		Expect(runner.CombinedOutputCall.Receives.Commands).To(Equal([]patcher.Command{
+			patcher.Command{
+				Args: []string{"rev-parse", "--verify", "--quiet", "main^{commit}"},
+				Dir:  "/some/repo",
+			},
+			patcher.Command{
+				Args: []string{"show-ref", "main"},
+				Dir:  "/some/repo",
+			},
+		}))
+	})
+
+	It("identifies remote branches", func() {
+		resolvesTo("abc123 refs/remotes/origin/main\n")
+
+		info, err := r.ResolveRef("origin/main")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Kind).To(Equal(patcher.RefBranch))
+	})
+
+	It("identifies tags, preferring them over branches of the same name", func() {
+		resolvesTo("abc123 refs/heads/v1.9.2\ndef456 refs/tags/v1.9.2\n")
+
+		info, err := r.ResolveRef("v1.9.2")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Kind).To(Equal(patcher.RefTag))
+	})
+
+	It("identifies fully qualified refs", func() {
+		resolvesTo("abc123 refs/heads/main\n")
+
+		info, err := r.ResolveRef("refs/heads/main")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Kind).To(Equal(patcher.RefBranch))
+	})
+
+	It("treats shas as commits", func() {
+		runner.CombinedOutputCall.Returns.Errors = []error{nil, errors.New("exit status 1")}
+		runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte("abc123\n"), nil}
+
+		info, err := r.ResolveRef("abc123")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info).To(Equal(patcher.RefInfo{SHA: "abc123", Kind: patcher.RefCommit}))
+	})
+
+	It("does not mistake a branch whose name only ends with the ref", func() {
+		resolvesTo("abc123 refs/heads/feature/main\n")
+
+		info, err := r.ResolveRef("main")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Kind).To(Equal(patcher.RefCommit))
+	})
+
+	Context("when the ref does not exist", func() {
+		It("returns an error", func() {
+			runner.CombinedOutputCall.Returns.Errors = []error{errors.New("exit status 1")}
+			runner.CombinedOutputCall.Returns.Outputs = [][]byte{nil}
+
+			_, err := r.ResolveRef("missing")
+			Expect(err).To(MatchError(`Ref "missing" does not exist`))
+		})
+	})
+})
@@ -0,0 +1,59 @@
+package patcher
+
+import (
+	"fmt"
+	"strings"
+)
+
+type NoSuchRemoteError struct {
+	Remote string
+}
+
+func (e NoSuchRemoteError) Error() string {
+	return fmt.Sprintf("Remote %q does not exist", e.Remote)
+}
+
+func (r Repo) RemoteURL(remote string) (string, error) {
+	output, err := r.combinedOutput(Command{
+		Args: []string{"remote", "get-url", remote},
+		Dir:  r.repo,
+	})
+	if err != nil {
+		if strings.Contains(string(output), "No such remote") {
+			return "", NoSuchRemoteError{Remote: remote}
+		}
+
+		return "", err
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
+
+func (r Repo) SetRemoteURL(remote, url string) error {
+	return r.run(Command{
+		Args: []string{"remote", "set-url", remote, url},
+		Dir:  r.repo,
+	})
+}
+
+// EnsureRemote adds the remote if it does not exist, or points it at url if
+// it does. It does nothing when the remote already has that url.
+func (r Repo) EnsureRemote(name, url string) error {
+	currentURL, err := r.RemoteURL(name)
+	if err != nil {
+		if _, ok := err.(NoSuchRemoteError); !ok {
+			return err
+		}
+
+		return r.run(Command{
+			Args: []string{"remote", "add", name, url},
+			Dir:  r.repo,
+		})
+	}
+
+	if currentURL == url {
+		return nil
+	}
+
+	return r.SetRemoteURL(name, url)
+}
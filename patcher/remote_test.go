@@ -0,0 +1,137 @@
+package patcher_test
+
+import (
+	"errors"
+
+	"github.com/pivotal-cf/knit/patcher"
+	"github.com/pivotal-cf/knit/patcher/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Remote", func() {
+	var (
+		runner *fakes.CommandRunner
+		r      patcher.Repo
+	)
+
+	BeforeEach(func() {
+		runner = &fakes.CommandRunner{}
+		r = patcher.NewRepo(runner, "/some/repo", "testbot", "foo@example.com")
+	})
+
+	Describe("RemoteURL", func() {
+		It("returns the url of the remote", func() {
+			runner.CombinedOutputCall.Returns.Errors = []error{nil}
+			runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte("https://example.com/repo.git\n")}
+
+			url, err := r.RemoteURL("origin")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(url).To(Equal("https://example.com/repo.git"))
+
+			Expect(runner.CombinedOutputCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{"remote", "get-url", "origin"},
+					Dir:  "/some/repo",
+				},
+			}))
+		})
+
+		Context("when the remote does not exist", func() {
+			It("returns a NoSuchRemoteError", func() {
+				runner.CombinedOutputCall.Returns.Errors = []error{errors.New("exit status 2")}
+				runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte("error: No such remote 'upstream'\n")}
+
+				_, err := r.RemoteURL("upstream")
+				Expect(err).To(Equal(patcher.NoSuchRemoteError{Remote: "upstream"}))
+				Expect(err).To(MatchError(`Remote "upstream" does not exist`))
+			})
+		})
+
+		Context("when git fails for another reason", func() {
+			It("returns the error", func() {
+				runner.CombinedOutputCall.Returns.Errors = []error{errors.New("meow")}
+				runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte("fatal: not a git repository\n")}
+
+				_, err := r.RemoteURL("origin")
+				Expect(err).To(MatchError("meow"))
+			})
+		})
+	})
+
+	Describe("SetRemoteURL", func() {
+		It("points the remote at the new url", func() {
+			err := r.SetRemoteURL("origin", "https://mirror.example.com/repo.git")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(runner.RunCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{"remote", "set-url", "origin", "https://mirror.example.com/repo.git"},
+					Dir:  "/some/repo",
+				},
+			}))
+		})
+
+		Context("when setting the url fails", func() {
+			It("returns an error", func() {
+				runner.RunCall.Returns.Errors = []error{errors.New("meow")}
+
+				err := r.SetRemoteURL("origin", "https://mirror.example.com/repo.git")
+				Expect(err).To(MatchError("meow"))
+			})
+		})
+	})
+
+	Describe("EnsureRemote", func() {
+		It("adds the remote when it does not exist", func() {
+			runner.CombinedOutputCall.Returns.Errors = []error{errors.New("exit status 2")}
+			runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte("error: No such remote 'mirror'\n")}
+
+			err := r.EnsureRemote("mirror", "https://mirror.example.com/repo.git")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(runner.RunCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{"remote", "add", "mirror", "https://mirror.example.com/repo.git"},
+					Dir:  "/some/repo",
+				},
+			}))
+		})
+
+		It("updates the url when it differs", func() {
+			runner.CombinedOutputCall.Returns.Errors = []error{nil}
+			runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte("https://old.example.com/repo.git\n")}
+
+			err := r.EnsureRemote("mirror", "https://mirror.example.com/repo.git")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(runner.RunCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{"remote", "set-url", "mirror", "https://mirror.example.com/repo.git"},
+					Dir:  "/some/repo",
+				},
+			}))
+		})
+
+		It("does nothing when the remote already matches", func() {
+			runner.CombinedOutputCall.Returns.Errors = []error{nil}
+			runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte("https://mirror.example.com/repo.git\n")}
+
+			err := r.EnsureRemote("mirror", "https://mirror.example.com/repo.git")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(runner.RunCall.Receives.Commands).To(BeEmpty())
+		})
+
+		Context("when the remote cannot be read", func() {
+			It("returns an error", func() {
+				runner.CombinedOutputCall.Returns.Errors = []error{errors.New("meow")}
+				runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte("fatal: not a git repository\n")}
+
+				err := r.EnsureRemote("mirror", "https://mirror.example.com/repo.git")
+				Expect(err).To(MatchError("meow"))
+				Expect(runner.RunCall.Receives.Commands).To(BeEmpty())
+			})
+		})
+	})
+})
@@ -1,264 +1,313 @@
 package patcher
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	pathpkg "path"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const (
-	modulePrefix          = "path = "
 	submoduleMessageRegex = `^.*is in submodule '(.*)'`
+	gitlinkMode           = "160000"
+	committerEmailRegex   = `^[^@\s<>]+@[^@\s<>]+$`
+	gitVersionRegex       = `git version (\d+)\.(\d+)`
+	unmatchedPathRegex    = `pathspec '(.*)' did not match`
+	objectIDRegex         = `^([0-9a-f]{40}|[0-9a-f]{64})$`
+	baseVersionFile       = ".knit-base"
+	defaultSubmoduleJobs  = 4
 )
 
-type commandRunner interface {
-	Run(command Command) (err error)
-	CombinedOutput(command Command) ([]byte, error)
-}
+type Hook func(operation, path string) error
 
 type Repo struct {
-	runner         commandRunner
+	runner         Runner
 	repo           string
 	committerName  string
 	committerEmail string
+	authorName     string
+	authorEmail    string
+	preHook        Hook
+	postHook       Hook
+	metrics        Metrics
+	messagePrefix  string
+	gpgRunner      Runner
+	patchResolver  PatchResolver
+	keyring        string
+
+	combineNestedBumps bool
+	deferCommits       bool
+	useSwitch          bool
+	autoLock           bool
+	renormalize        bool
+	tempDir            string
+	indexLockTimeout   time.Duration
+	submoduleJobs      int
+	stdout             io.Writer
+
+	patchDownloadOptions PatchDownloadOptions
 }
 
-func NewRepo(commandRunner commandRunner, repo string, committerName, committerEmail string) Repo {
+func NewRepo(commandRunner Runner, repo string, committerName, committerEmail string) Repo {
 	return Repo{
 		runner:         commandRunner,
 		repo:           repo,
 		committerName:  committerName,
 		committerEmail: committerEmail,
+		messagePrefix:  "Knit",
 	}
 }
 
-func (r Repo) Checkout(checkoutRef string) error {
-	commands := []Command{
-		Command{
-			Args: []string{"checkout", checkoutRef},
-			Dir:  r.repo,
-		},
-		Command{
-			Args: []string{"clean", "-ffd"},
-			Dir:  r.repo,
-		},
-		Command{
-			Args: []string{"submodule", "init"},
-			Dir:  r.repo,
-		},
-		Command{
-			Args: []string{"submodule", "foreach", "--recursive", "git submodule sync"},
-			Dir:  r.repo,
-		},
-		Command{
-			Args: []string{"submodule", "update", "--init", "--recursive", "--force", "--jobs=4"},
-			Dir:  r.repo,
-		},
-		Command{
-			Args: []string{"submodule", "foreach", "--recursive", "git clean -ffd"},
-			Dir:  r.repo,
-		},
+// NewRepoE behaves like NewRepo but rejects an empty or implausible committer
+// identity up front rather than when git refuses the first commit.
+func NewRepoE(commandRunner Runner, repo string, committerName, committerEmail string) (Repo, error) {
+	if strings.TrimSpace(committerName) == "" {
+		return Repo{}, errors.New("committer name must not be empty")
 	}
 
-	for _, command := range commands {
-		if err := r.runner.Run(command); err != nil {
-			return err
-		}
+	if !regexp.MustCompile(committerEmailRegex).MatchString(committerEmail) {
+		return Repo{}, fmt.Errorf("committer email %q is not a valid email address", committerEmail)
 	}
 
-	return nil
+	return NewRepo(commandRunner, repo, committerName, committerEmail), nil
 }
 
-func (r Repo) ApplyPatch(patch string) error {
-	command := Command{
-		Args: []string{
-			"-c", fmt.Sprintf("user.name=%s", r.committerName),
-			"-c", fmt.Sprintf("user.email=%s", r.committerEmail),
-			"am",
-			patch,
-		},
-		Dir: r.repo,
+// NewRepoFromConfig reads the committer identity from the repository's git
+// config, falling back to the GIT_AUTHOR_NAME and GIT_AUTHOR_EMAIL variables.
+func NewRepoFromConfig(commandRunner Runner, repo string) (Repo, error) {
+	committerName := gitConfigValue(commandRunner, repo, "user.name")
+	if committerName == "" {
+		committerName = os.Getenv("GIT_AUTHOR_NAME")
+	}
+
+	if committerName == "" {
+		return Repo{}, errors.New("could not determine committer name: set git config user.name or GIT_AUTHOR_NAME")
+	}
+
+	committerEmail := gitConfigValue(commandRunner, repo, "user.email")
+	if committerEmail == "" {
+		committerEmail = os.Getenv("GIT_AUTHOR_EMAIL")
+	}
+
+	if committerEmail == "" {
+		return Repo{}, errors.New("could not determine committer email: set git config user.email or GIT_AUTHOR_EMAIL")
 	}
 
-	err := r.runner.Run(command)
+	return NewRepoE(commandRunner, repo, committerName, committerEmail)
+}
+
+func gitConfigValue(commandRunner Runner, repo, key string) string {
+	output, err := commandRunner.CombinedOutput(Command{
+		Args: []string{"config", "--get", key},
+		Dir:  repo,
+	})
 	if err != nil {
-		return err
+		return ""
 	}
 
-	return nil
+	return strings.TrimSpace(string(output))
 }
 
-func (r Repo) AddSubmodule(path, url, ref, branch string) error {
-	var submoduleAddArgs []string
-	pathToSubmodule := filepath.Join(r.repo, path)
+// WithAuthor returns a copy of the Repo whose knit commits are attributed to
+// the given author while the configured identity remains the committer.
+// Either value left empty falls back to the committer's.
+func (r Repo) WithAuthor(authorName, authorEmail string) Repo {
+	r.authorName = authorName
+	r.authorEmail = authorEmail
+	return r
+}
 
-	if branch != "" {
-		submoduleAddArgs = []string{"submodule", "add", "--force", "-b", branch, url, path}
-	} else {
-		submoduleAddArgs = []string{"submodule", "add", "--force", url, path}
+// WithHooks registers callbacks run around each mutating operation with the
+// operation name and the path or ref it acts on. An error from the pre-hook
+// prevents the operation from running; an error from the post-hook is
+// returned once the operation has completed.
+func (r Repo) WithHooks(preHook, postHook Hook) Repo {
+	r.preHook = preHook
+	r.postHook = postHook
+	return r
+}
+
+// WithRunner returns a copy of the repo that runs its commands through runner,
+// e.g. to wrap the existing runner with tracing or retries.
+func (r Repo) WithRunner(runner Runner) Repo {
+	r.runner = runner
+	return r
+}
+
+// WithMessagePrefix replaces "Knit" at the start of the commit messages for
+// additions, removals, bumps and patches, e.g. to rebrand or localize them.
+func (r Repo) WithMessagePrefix(prefix string) (Repo, error) {
+	if strings.TrimSpace(prefix) == "" {
+		return Repo{}, errors.New("message prefix must not be empty")
 	}
 
-	commands := []Command{
-		Command{
-			Args: submoduleAddArgs,
-			Dir:  r.repo,
-		},
-		Command{
-			Args: []string{"checkout", ref},
-			Dir:  pathToSubmodule,
-		},
-		Command{
-			Args: []string{"submodule", "foreach", "--recursive", "git submodule sync"},
-			Dir:  pathToSubmodule,
-		},
-		Command{
-			Args: []string{"submodule", "update", "--init", "--recursive", "--force", "--jobs=4"},
-			Dir:  pathToSubmodule,
-		},
-		Command{
-			Args: []string{"submodule", "foreach", "--recursive", "git clean -ffd"},
-			Dir:  r.repo,
-		},
-		Command{
-			Args: []string{"clean", "-ffd"},
-			Dir:  pathToSubmodule,
-		},
-		Command{
-			Args: []string{"add", "-A", path},
-			Dir:  r.repo,
-		},
-		Command{
-			Args: []string{
-				"-c", fmt.Sprintf("user.name=%s", r.committerName),
-				"-c", fmt.Sprintf("user.email=%s", r.committerEmail),
-				"commit",
-				"-m", fmt.Sprintf("Knit addition of %s", path),
-				"--no-verify",
-			},
-			Dir: r.repo,
-		},
+	if strings.ContainsAny(prefix, "\r\n") {
+		return Repo{}, fmt.Errorf("message prefix %q must not contain newlines", prefix)
 	}
 
-	for _, command := range commands {
-		if err := r.runner.Run(command); err != nil {
-			return err
-		}
+	r.messagePrefix = prefix
+	return r, nil
+}
+
+// WithCombinedNestedBumps describes a bump of a submodule of a submodule with a
+// single superproject commit naming the full nested path. The enclosing
+// submodule still needs its own commit for its gitlink to move; that commit is
+// only reachable through the superproject's.
+func (r Repo) WithCombinedNestedBumps() Repo {
+	r.combineNestedBumps = true
+	return r
+}
+
+// WithDeferredCommits returns a copy of the repo whose operations stage their
+// changes in the superproject without committing them, so that CommitAll can
+// record a whole run as a single commit.
+func (r Repo) WithDeferredCommits() Repo {
+	r.deferCommits = true
+	return r
+}
+
+// WithRenormalize returns a copy of the repo that runs git add --renormalize
+// before each commit it makes itself, so committed content follows
+// .gitattributes. Commits created by git am from a patch are recorded as the
+// patch has them. This can touch many files unrelated to the change being
+// committed.
+func (r Repo) WithRenormalize() Repo {
+	r.renormalize = true
+	return r
+}
+
+// WithSwitch switches branches with git switch rather than git checkout when
+// the installed git supports it (2.23 and later). Detached checkouts of other
+// refs still use git checkout.
+func (r Repo) WithSwitch() Repo {
+	output, err := r.combinedOutput(Command{
+		Args: []string{"version"},
+		Dir:  r.repo,
+	})
+	if err != nil {
+		return r
 	}
 
-	return nil
+	matches := regexp.MustCompile(gitVersionRegex).FindStringSubmatch(string(output))
+	if matches == nil {
+		return r
+	}
+
+	major, _ := strconv.Atoi(matches[1])
+	minor, _ := strconv.Atoi(matches[2])
+	r.useSwitch = major > 2 || (major == 2 && minor >= 23)
+
+	return r
 }
 
-func (r Repo) RemoveSubmodule(path string) error {
-	submoduleDeinitArgs := []string{"submodule", "deinit", "-f", path}
-	submoduleRemoveArgs := []string{"rm", "-f", path}
+// WithTempDir sets where patches and archives are staged on disk. The system
+// temp directory is used by default.
+func (r Repo) WithTempDir(dir string) Repo {
+	r.tempDir = dir
+	return r
+}
 
-	commands := []Command{
-		Command{
-			Args: submoduleDeinitArgs,
-			Dir:  r.repo,
-		},
-		Command{
-			Args: submoduleRemoveArgs,
-			Dir:  r.repo,
-		},
-		Command{
-			Args: []string{
-				"-c", fmt.Sprintf("user.name=%s", r.committerName),
-				"-c", fmt.Sprintf("user.email=%s", r.committerEmail),
-				"commit",
-				"-m", fmt.Sprintf("Knit removal of submodule '%s'", path),
-				"--no-verify",
-			},
-			Dir: r.repo,
-		},
+// WithSubmoduleJobs sets how many submodules git submodule update fetches at
+// once. Four are fetched at a time by default.
+func (r Repo) WithSubmoduleJobs(jobs int) Repo {
+	r.submoduleJobs = jobs
+	return r
+}
+
+func (r Repo) submoduleJobsArg() string {
+	jobs := r.submoduleJobs
+	if jobs <= 0 {
+		jobs = defaultSubmoduleJobs
 	}
 
-	for _, command := range commands {
-		if err := r.runner.Run(command); err != nil {
-			return err
-		}
+	return fmt.Sprintf("--jobs=%d", jobs)
+}
+
+// WithStdout sets where knit reports what it did beyond running git, such as
+// patches skipped because they were already applied. Nothing is reported by
+// default.
+func (r Repo) WithStdout(stdout io.Writer) Repo {
+	r.stdout = stdout
+	return r
+}
+
+func (r Repo) logf(format string, args ...interface{}) {
+	if r.stdout != nil {
+		fmt.Fprintf(r.stdout, format+"\n", args...)
 	}
+}
 
-	return nil
+func (r Repo) Checkout(checkoutRef string) error {
+	return r.withHooks("Checkout", checkoutRef, func() error {
+		return r.checkout(r.checkoutArgs(checkoutRef))
+	})
 }
 
-func (r Repo) BumpSubmodule(path, sha string) error {
-	pathToSubmodule := filepath.Join(r.repo, path)
-	pathToRepo := r.repo
+// CheckoutDetached moves the repository to sha with a detached HEAD, like
+// Checkout, but without git's detached HEAD advice. Use Checkout when the ref
+// may be a branch or tag.
+func (r Repo) CheckoutDetached(sha string) error {
+	return r.withHooks("CheckoutDetached", sha, func() error {
+		return r.checkout([]string{"-c", "advice.detachedHead=false", "checkout", "--detach", sha})
+	})
+}
+
+func (r Repo) checkout(checkoutArgs []string) error {
+	err := r.run(Command{
+		Args: checkoutArgs,
+		Dir:  r.repo,
+	})
+	if err != nil {
+		return err
+	}
 
-	re := regexp.MustCompile(`(src/.*)/(src/.*)`)
-	matches := re.FindStringSubmatch(path)
-	if len(matches) == 3 {
-		pathToRepo = filepath.Join(r.repo, matches[1])
-		path = matches[2]
+	err = r.deinitStaleSubmodules()
+	if err != nil {
+		return err
 	}
 
 	commands := []Command{
 		Command{
-			Args: []string{"fetch"},
-			Dir:  pathToSubmodule,
-		},
-		Command{
-			Args: []string{"checkout", sha},
-			Dir:  pathToSubmodule,
+			Args: []string{"clean", "-ffd"},
+			Dir:  r.repo,
 		},
 		Command{
 			Args: []string{"submodule", "init"},
-			Dir:  pathToSubmodule,
+			Dir:  r.repo,
 		},
 		Command{
-			Args: []string{"submodule", "sync"},
-			Dir:  pathToSubmodule,
+			Args: []string{"submodule", "foreach", "--recursive", "git submodule sync"},
+			Dir:  r.repo,
 		},
+	}
+
+	shallowUpdates, err := r.shallowSubmoduleUpdates()
+	if err != nil {
+		return err
+	}
+	commands = append(commands, shallowUpdates...)
+
+	commands = append(commands,
 		Command{
-			Args: []string{"submodule", "update", "--init", "--recursive", "--force", "--jobs=4"},
-			Dir:  pathToSubmodule,
+			Args: []string{"submodule", "update", "--init", "--recursive", "--force", r.submoduleJobsArg()},
+			Dir:  r.repo,
 		},
 		Command{
 			Args: []string{"submodule", "foreach", "--recursive", "git clean -ffd"},
 			Dir:  r.repo,
 		},
-		Command{
-			Args: []string{"clean", "-ffd"},
-			Dir:  pathToSubmodule,
-		},
-		Command{
-			Args: []string{"add", "-A", path},
-			Dir:  pathToRepo,
-		},
-		Command{
-			Args: []string{
-				"-c", fmt.Sprintf("user.name=%s", r.committerName),
-				"-c", fmt.Sprintf("user.email=%s", r.committerEmail),
-				"commit",
-				"-m", fmt.Sprintf("Knit bump of %s", path),
-				"--no-verify",
-			},
-			Dir: pathToRepo,
-		},
-	}
-
-	if len(matches) == 3 {
-		commands = append(commands, Command{
-			Args: []string{"add", "-A", matches[1]},
-			Dir:  r.repo,
-		}, Command{
-			Args: []string{
-				"-c", fmt.Sprintf("user.name=%s", r.committerName),
-				"-c", fmt.Sprintf("user.email=%s", r.committerEmail),
-				"commit",
-				"-m", fmt.Sprintf("Knit bump of %s", matches[1]),
-				"--no-verify",
-			},
-			Dir: r.repo,
-		})
-	}
+	)
 
 	for _, command := range commands {
-		if err := r.runner.Run(command); err != nil {
+		if err := r.run(command); err != nil {
 			return err
 		}
 	}
@@ -266,125 +315,1305 @@ func (r Repo) BumpSubmodule(path, sha string) error {
 	return nil
 }
 
-func (r Repo) PatchSubmodule(path, fullPathToPatch string) error {
-	applyCommand := Command{
-		Args: []string{
-			"-c", fmt.Sprintf("user.name=%s", r.committerName),
-			"-c", fmt.Sprintf("user.email=%s", r.committerEmail),
-			"am", fullPathToPatch,
-		},
-		Dir: filepath.Join(r.repo, path),
+// shallowSubmoduleUpdates clones and updates the submodules marked shallow in
+// .gitmodules to their configured depth, ahead of the full submodule update.
+func (r Repo) shallowSubmoduleUpdates() ([]Command, error) {
+	modules, err := r.gitmodules()
+	if err != nil {
+		return nil, err
 	}
 
-	if err := r.runner.Run(applyCommand); err != nil {
-		return err
+	var commands []Command
+	for _, module := range modules {
+		if module.fetchDepth() == 0 || module.path == "" {
+			continue
+		}
+
+		commands = append(commands, Command{
+			Args: []string{"submodule", "update", "--init", "--force", fmt.Sprintf("--depth=%d", module.fetchDepth()), "--", module.path},
+			Dir:  r.repo,
+		})
 	}
 
-	addCommand := Command{
-		Args: []string{"add", "-A", path},
-		Dir:  r.repo,
+	return commands, nil
+}
+
+// SetSubmoduleShallow marks the submodule at path as shallow in .gitmodules,
+// fetched to depth commits by Checkout and BumpSubmodule, and commits the
+// change.
+func (r Repo) SetSubmoduleShallow(path string, depth int) error {
+	if depth < 1 {
+		return fmt.Errorf("Invalid shallow depth %d for submodule %q", depth, path)
 	}
 
-	if output, err := r.runner.CombinedOutput(addCommand); err != nil {
-		re := regexp.MustCompile(submoduleMessageRegex)
-		submodulePath := re.FindStringSubmatch(string(output))[1]
-		absoluteSubmodulePath := filepath.Join(r.repo, submodulePath)
+	return r.withHooks("SetSubmoduleShallow", path, func() error {
+		name, err := r.submoduleName(path)
+		if err != nil {
+			return err
+		}
 
 		commands := []Command{
 			Command{
-				Args: []string{"add", "-A", "."},
-				Dir:  absoluteSubmodulePath,
+				Args: []string{"config", "-f", ".gitmodules", fmt.Sprintf("submodule.%s.shallow", name), "true"},
+				Dir:  r.repo,
+			},
+			Command{
+				Args: []string{"config", "-f", ".gitmodules", fmt.Sprintf("submodule.%s.depth", name), strconv.Itoa(depth)},
+				Dir:  r.repo,
 			},
 			Command{
-				Args: []string{
-					"-c", fmt.Sprintf("user.name=%s", r.committerName),
-					"-c", fmt.Sprintf("user.email=%s", r.committerEmail),
-					"commit",
-					"-m", fmt.Sprintf("Knit submodule patch of %s", submodulePath),
-					"--no-verify",
-				},
-				Dir: absoluteSubmodulePath,
+				Args: []string{"add", ".gitmodules"},
+				Dir:  r.repo,
 			},
 		}
+		commands = append(commands, r.commitCommands(r.repo, fmt.Sprintf("%s shallow fetch of '%s' at depth %d", r.messagePrefix, path, depth))...)
 
 		for _, command := range commands {
-			if err := r.runner.Run(command); err != nil {
+			if err := r.run(command); err != nil {
 				return err
 			}
 		}
-	}
 
-	commitCommands := []Command{
-		Command{
-			Args: []string{"add", "-A", "."},
-			Dir:  r.repo,
-		},
-		Command{
-			Args: []string{
-				"-c", fmt.Sprintf("user.name=%s", r.committerName),
-				"-c", fmt.Sprintf("user.email=%s", r.committerEmail),
-				"commit",
-				"-m", fmt.Sprintf("Knit patch of %s", path),
-				"--no-verify",
+		return nil
+	})
+}
+
+// SoftCheckout moves the repository to checkoutRef like Checkout, but without
+// cleaning untracked files or forcing the submodule update. Local changes are
+// kept, and git refuses to switch rather than overwrite them.
+func (r Repo) SoftCheckout(checkoutRef string) error {
+	return r.withHooks("SoftCheckout", checkoutRef, func() error {
+		commands := []Command{
+			Command{
+				Args: r.checkoutArgs(checkoutRef),
+				Dir:  r.repo,
 			},
-			Dir: r.repo,
-		},
-	}
+			Command{
+				Args: []string{"submodule", "init"},
+				Dir:  r.repo,
+			},
+			Command{
+				Args: []string{"submodule", "foreach", "--recursive", "git submodule sync"},
+				Dir:  r.repo,
+			},
+			Command{
+				Args: []string{"submodule", "update", "--init", "--recursive", r.submoduleJobsArg()},
+				Dir:  r.repo,
+			},
+		}
 
-	for _, command := range commitCommands {
-		if err := r.runner.Run(command); err != nil {
-			return err
+		for _, command := range commands {
+			if err := r.run(command); err != nil {
+				return err
+			}
 		}
-	}
 
-	return nil
+		return nil
+	})
 }
 
-func (r Repo) CheckoutBranch(name string) error {
-	err := r.runner.Run(Command{
-		Args: []string{"rev-parse", "--verify", fmt.Sprintf("refs/heads/%s", name)},
-		Dir:  r.repo,
-	})
-	if err == nil {
-		return fmt.Errorf("Branch %q already exists. Please delete it before trying again", name)
+// AbsorbGitDirs moves submodule git directories that still live inside the
+// submodule checkouts into the superproject's .git/modules, as newer git does.
+func (r Repo) AbsorbGitDirs() error {
+	modulePaths, err := r.gitmodulesPaths()
+	if err != nil {
+		return err
+	}
+
+	if len(modulePaths) == 0 {
+		return nil
 	}
 
-	err = r.runner.Run(Command{
-		Args: []string{"checkout", "-b", name},
+	return r.run(Command{
+		Args: []string{"submodule", "absorbgitdirs"},
 		Dir:  r.repo,
 	})
+}
+
+// DeinitAllSubmodules unregisters and empties every submodule so that the next
+// Checkout initializes them from scratch. Follow it with Clean for a full reset.
+func (r Repo) DeinitAllSubmodules() error {
+	modulePaths, err := r.gitmodulesPaths()
 	if err != nil {
 		return err
 	}
 
-	return nil
-}
+	if len(modulePaths) == 0 {
+		return nil
+	}
+
+	return r.run(Command{
+		Args: []string{"submodule", "deinit", "--all", "--force"},
+		Dir:  r.repo,
+	})
+}
+
+// CheckoutPaths restores paths in the working tree and index to their contents
+// at ref, leaving the rest of the tree alone.
+func (r Repo) CheckoutPaths(ref string, paths ...string) error {
+	output, err := r.combinedOutput(Command{
+		Args: append([]string{"checkout", ref, "--"}, paths...),
+		Dir:  r.repo,
+	})
+	if err != nil {
+		if matches := regexp.MustCompile(unmatchedPathRegex).FindStringSubmatch(string(output)); matches != nil {
+			return fmt.Errorf("Path %q does not exist in %s", matches[1], ref)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// Clean removes untracked files from the repository and all of its
+// submodules, the same way Checkout does.
+func (r Repo) Clean() error {
+	commands := []Command{
+		Command{
+			Args: []string{"clean", "-ffd"},
+			Dir:  r.repo,
+		},
+		Command{
+			Args: []string{"submodule", "foreach", "--recursive", "git clean -ffd"},
+			Dir:  r.repo,
+		},
+	}
+
+	for _, command := range commands {
+		if err := r.run(command); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r Repo) FetchTags() error {
+	return r.run(Command{
+		Args: []string{"fetch", "--tags"},
+		Dir:  r.repo,
+	})
+}
+
+func (r Repo) SubmoduleSync() error {
+	modulePaths, err := r.gitmodulesPaths()
+	if err != nil {
+		return err
+	}
+
+	if len(modulePaths) == 0 {
+		return nil
+	}
+
+	return r.run(Command{
+		Args: []string{"submodule", "sync", "--recursive"},
+		Dir:  r.repo,
+	})
+}
+
+// UpdateSubmodules initializes and force-updates only the given submodules,
+// recursively. Every path must be listed in .gitmodules.
+func (r Repo) UpdateSubmodules(paths ...string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+
+	for _, path := range paths {
+		if _, err := r.submoduleName(path); err != nil {
+			return err
+		}
+	}
+
+	return r.run(Command{
+		Args: append([]string{"submodule", "update", "--init", "--recursive", "--force", r.submoduleJobsArg(), "--"}, paths...),
+		Dir:  r.repo,
+	})
+}
+
+func (r Repo) ApplyPatch(patch string) error {
+	_, err := r.ApplyPatchWithOptions(patch, ApplyPatchOptions{})
+	return err
+}
+
+// ApplyCached applies patch to the index only, leaving the working tree and
+// HEAD untouched so the result can be reviewed with git diff --cached.
+func (r Repo) ApplyCached(patch string) error {
+	return r.withPatchHooks("ApplyCached", patch, func() error {
+		output, err := r.combinedOutput(Command{
+			Args: []string{"apply", "--cached", patch},
+			Dir:  r.repo,
+		})
+		if err != nil {
+			return fmt.Errorf("Patch %q does not apply to the index: %s\n%s", patch, err, strings.TrimSpace(string(output)))
+		}
+
+		return nil
+	})
+}
+
+// ApplyPatchAmend applies patch and folds it into HEAD with commit --amend,
+// keeping HEAD's message, rather than recording a new commit.
+func (r Repo) ApplyPatchAmend(patch string) error {
+	return r.withPatchHooks("ApplyPatchAmend", patch, func() error {
+		err := r.run(Command{
+			Args: []string{"rev-parse", "--verify", "--quiet", "HEAD"},
+			Dir:  r.repo,
+		})
+		if err != nil {
+			return fmt.Errorf("Cannot amend patch %q into HEAD: the repository has no commits", patch)
+		}
+
+		commands := []Command{
+			Command{
+				Args: []string{"apply", "--index", patch},
+				Dir:  r.repo,
+			},
+			Command{
+				Args: r.withIdentity("commit", "--amend", "--no-edit", "--no-verify"),
+				Dir:  r.repo,
+			},
+		}
+
+		for _, command := range commands {
+			if err := r.run(command); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+type PatchEntry struct {
+	Path      string
+	ThreeWay  bool
+	Strip     int
+	Directory string
+}
+
+// ApplyManifest applies each entry in order with its own git am options,
+// stopping at the first entry that fails to apply.
+func (r Repo) ApplyManifest(entries []PatchEntry) error {
+	for i, entry := range entries {
+		var args []string
+		if entry.ThreeWay {
+			args = append(args, "--3way")
+		}
+
+		if entry.Strip > 0 {
+			args = append(args, fmt.Sprintf("-p%d", entry.Strip))
+		}
+
+		if entry.Directory != "" {
+			args = append(args, fmt.Sprintf("--directory=%s", entry.Directory))
+		}
+
+		err := r.withPatchHooks("ApplyManifest", entry.Path, func() error {
+			return r.run(Command{
+				Args: r.patchArgs(append(args, entry.Path)...),
+				Dir:  r.repo,
+			})
+		})
+		if err != nil {
+			return fmt.Errorf("could not apply manifest entry %d (%q): %s", i, entry.Path, err)
+		}
+	}
+
+	return nil
+}
+
+func (r Repo) AddSubmodule(path, url, ref, branch string) error {
+	return r.withHooks("AddSubmodule", path, func() error {
+		return r.addSubmodule(path, url, ref, branch)
+	})
+}
+
+func (r Repo) addSubmodule(path, url, ref, branch string) error {
+	var submoduleAddArgs []string
+	pathToSubmodule := filepath.Join(r.repo, path)
+
+	if branch != "" {
+		submoduleAddArgs = []string{"submodule", "add", "--force", "-b", branch, url, path}
+	} else {
+		submoduleAddArgs = []string{"submodule", "add", "--force", url, path}
+	}
+
+	commands := []Command{
+		Command{
+			Args: submoduleAddArgs,
+			Dir:  r.repo,
+		},
+	}
+
+	if ref != "" {
+		commands = append(commands, Command{
+			Args: []string{"checkout", ref},
+			Dir:  pathToSubmodule,
+		})
+	}
+
+	commands = append(commands, []Command{
+		Command{
+			Args: []string{"submodule", "foreach", "--recursive", "git submodule sync"},
+			Dir:  pathToSubmodule,
+		},
+		Command{
+			Args: []string{"submodule", "update", "--init", "--recursive", "--force", r.submoduleJobsArg()},
+			Dir:  pathToSubmodule,
+		},
+		Command{
+			Args: []string{"submodule", "foreach", "--recursive", "git clean -ffd"},
+			Dir:  r.repo,
+		},
+		Command{
+			Args: []string{"clean", "-ffd"},
+			Dir:  pathToSubmodule,
+		},
+		Command{
+			Args: []string{"add", "-A", path},
+			Dir:  r.repo,
+		},
+	}...)
+	commands = append(commands, r.commitCommands(r.repo, fmt.Sprintf("%s addition of %s", r.messagePrefix, path))...)
+
+	for _, command := range commands {
+		if err := r.run(command); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r Repo) RemoveSubmodule(path string) error {
+	return r.withHooks("RemoveSubmodule", path, func() error {
+		return r.removeSubmodule(path)
+	})
+}
+
+func (r Repo) removeSubmodule(path string) error {
+	submoduleDeinitArgs := []string{"submodule", "deinit", "-f", path}
+	submoduleRemoveArgs := []string{"rm", "-f", path}
+
+	commands := []Command{
+		Command{
+			Args: submoduleDeinitArgs,
+			Dir:  r.repo,
+		},
+		Command{
+			Args: submoduleRemoveArgs,
+			Dir:  r.repo,
+		},
+	}
+	commands = append(commands, r.commitCommands(r.repo, fmt.Sprintf("%s removal of submodule '%s'", r.messagePrefix, path))...)
+
+	for _, command := range commands {
+		if err := r.run(command); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type DirtySubmoduleError struct {
+	Path    string
+	Changes []string
+}
+
+func (e DirtySubmoduleError) Error() string {
+	return fmt.Sprintf("Submodule %q has uncommitted changes:\n%s", e.Path, strings.Join(e.Changes, "\n"))
+}
+
+type BumpSubmoduleOptions struct {
+	// Force bumps the submodule even if it has uncommitted changes, which are
+	// discarded by the checkout.
+	Force bool
+
+	// CommitRange, when positive, lists the submodule commits the bump pulls
+	// in, up to that many, in the body of the commit message.
+	CommitRange int
+}
+
+func (r Repo) BumpSubmodule(path, sha string) error {
+	return r.BumpSubmoduleWithOptions(path, sha, BumpSubmoduleOptions{})
+}
+
+func (r Repo) BumpSubmoduleWithOptions(path, sha string, options BumpSubmoduleOptions) error {
+	if !options.Force {
+		if err := r.VerifyNoUncommittedSubmoduleChanges(path); err != nil {
+			return err
+		}
+	}
+
+	return r.withHooks("BumpSubmodule", path, func() error {
+		return r.bumpSubmodule(path, sha, "", options.CommitRange)
+	})
+}
+
+// bumpCommitLog returns a message body listing the commits between the
+// gitlink recorded for path in pathToRepo and sha, at most limit of them.
+func (r Repo) bumpCommitLog(pathToRepo, path, sha string, limit int) (string, error) {
+	output, err := r.combinedOutput(Command{
+		Args: []string{"rev-parse", fmt.Sprintf("HEAD:%s", path)},
+		Dir:  pathToRepo,
+	})
+	if err != nil {
+		return "", fmt.Errorf("Could not find the current commit of submodule %q: %s", path, err)
+	}
+	oldSHA := strings.TrimSpace(string(output))
+
+	output, err = r.combinedOutput(Command{
+		Args: []string{"log", "--oneline", fmt.Sprintf("%s..%s", oldSHA, sha)},
+		Dir:  filepath.Join(pathToRepo, path),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	commits := splitLines(output)
+	if len(commits) == 0 {
+		return "", nil
+	}
+
+	if len(commits) > limit {
+		commits = append(commits[:limit], fmt.Sprintf("and %d more", len(commits)-limit))
+	}
+
+	return fmt.Sprintf("\n\n%s", strings.Join(commits, "\n")), nil
+}
+
+// VerifyNoUncommittedSubmoduleChanges returns a DirtySubmoduleError if the
+// checked out submodule at path has local modifications.
+func (r Repo) VerifyNoUncommittedSubmoduleChanges(path string) error {
+	pathToSubmodule := filepath.Join(r.repo, path)
+
+	checkedOut, err := r.hasGitEntry(path)
+	if err != nil {
+		return err
+	}
+
+	if !checkedOut {
+		return nil
+	}
+
+	output, err := r.combinedOutput(Command{
+		Args: []string{"status", "--porcelain"},
+		Dir:  pathToSubmodule,
+	})
+	if err != nil {
+		return err
+	}
+
+	changes := splitLines(output)
+	if len(changes) > 0 {
+		return DirtySubmoduleError{Path: path, Changes: changes}
+	}
+
+	return nil
+}
+
+// EnsureSubmodule converges the submodule at path on url and sha, adding it if
+// it is missing, retargeting its URL if it differs and bumping it if it is
+// recorded at another commit. Nothing is committed if it already matches. sha
+// may be anything the submodule resolves to a commit, such as an abbreviated
+// sha or a tag; one it cannot resolve yet is bumped to.
+func (r Repo) EnsureSubmodule(path, url, sha, branch string) error {
+	if strings.TrimSpace(sha) == "" {
+		return fmt.Errorf("Cannot ensure submodule %q without a commit to check out", path)
+	}
+
+	return r.withHooks("EnsureSubmodule", path, func() error {
+		return r.ensureSubmodule(path, url, sha, branch)
+	})
+}
+
+func (r Repo) ensureSubmodule(path, url, sha, branch string) error {
+	modules, err := r.gitmodules()
+	if err != nil {
+		return err
+	}
+
+	var module *gitmodule
+	for i := range modules {
+		if filepath.Clean(modules[i].path) == filepath.Clean(path) {
+			module = &modules[i]
+		}
+	}
+
+	if module == nil {
+		return r.addSubmodule(path, url, sha, branch)
+	}
+
+	if module.url != url {
+		commands := []Command{
+			Command{
+				Args: []string{"submodule", "set-url", "--", path, url},
+				Dir:  r.repo,
+			},
+			Command{
+				Args: []string{"submodule", "sync", "--", path},
+				Dir:  r.repo,
+			},
+			Command{
+				Args: []string{"add", ".gitmodules"},
+				Dir:  r.repo,
+			},
+		}
+		commands = append(commands, r.commitCommands(r.repo, fmt.Sprintf("%s retarget of %s to %s", r.messagePrefix, path, url))...)
+
+		for _, command := range commands {
+			if err := r.run(command); err != nil {
+				return err
+			}
+		}
+	}
+
+	output, err := r.combinedOutput(Command{
+		Args: []string{"rev-parse", fmt.Sprintf("HEAD:%s", path)},
+		Dir:  r.repo,
+	})
+	if err != nil {
+		return err
+	}
+
+	target, err := r.combinedOutput(Command{
+		Args: []string{"rev-parse", "--verify", "-q", fmt.Sprintf("%s^{commit}", sha)},
+		Dir:  filepath.Join(r.repo, path),
+	})
+	if err == nil && strings.TrimSpace(string(target)) == strings.TrimSpace(string(output)) {
+		return nil
+	}
+
+	if err := r.VerifyNoUncommittedSubmoduleChanges(path); err != nil {
+		return err
+	}
+
+	return r.bumpSubmodule(path, sha, "", 0)
+}
+
+// BumpSubmoduleToTrackedBranch bumps the submodule to the tip of the branch
+// recorded for it in .gitmodules. Nested submodules are looked up in the
+// .gitmodules of the repository that records them.
+func (r Repo) BumpSubmoduleToTrackedBranch(path string) error {
+	modules, err := r.ListSubmodulesRecursive()
+	if err != nil {
+		return err
+	}
+
+	var branch string
+	for _, module := range modules {
+		if filepath.Clean(module.Path) == filepath.Clean(path) {
+			branch = module.Branch
+		}
+	}
+
+	if branch == "" {
+		return fmt.Errorf("Submodule %q does not track a branch in .gitmodules", path)
+	}
+
+	// Only stdout is parsed so that ssh banners and warnings on stderr are not
+	// mistaken for the sha.
+	var output bytes.Buffer
+	err = r.run(Command{
+		Args:   []string{"ls-remote", "origin", fmt.Sprintf("refs/heads/%s", branch)},
+		Dir:    filepath.Join(r.repo, path),
+		Stdout: &output,
+	})
+	if err != nil {
+		return err
+	}
+
+	fields := strings.Fields(output.String())
+	if len(fields) == 0 {
+		return fmt.Errorf("Branch %q tracked by submodule %q does not exist on origin", branch, path)
+	}
+
+	sha := fields[0]
+	if err := r.VerifyNoUncommittedSubmoduleChanges(path); err != nil {
+		return err
+	}
+
+	return r.withHooks("BumpSubmodule", path, func() error {
+		return r.bumpSubmodule(path, sha, fmt.Sprintf(" to %s (tip of %s)", sha, branch), 0)
+	})
+}
+
+// SetSubmoduleBranch records branch as the branch tracked by the submodule at
+// path in .gitmodules and commits the change.
+func (r Repo) SetSubmoduleBranch(path, branch string) error {
+	return r.withHooks("SetSubmoduleBranch", path, func() error {
+		name, err := r.submoduleName(path)
+		if err != nil {
+			return err
+		}
+
+		commands := []Command{
+			Command{
+				Args: []string{"config", "-f", ".gitmodules", fmt.Sprintf("submodule.%s.branch", name), branch},
+				Dir:  r.repo,
+			},
+			Command{
+				Args: []string{"submodule", "sync", "--", path},
+				Dir:  r.repo,
+			},
+			Command{
+				Args: []string{"add", ".gitmodules"},
+				Dir:  r.repo,
+			},
+		}
+		commands = append(commands, r.commitCommands(r.repo, fmt.Sprintf("%s tracked branch of '%s' set to %s", r.messagePrefix, path, branch))...)
+
+		for _, command := range commands {
+			if err := r.run(command); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (r Repo) bumpSubmodule(path, sha, messageDetails string, commitRange int) error {
+	pathToSubmodule := filepath.Join(r.repo, path)
+	pathToRepo := r.repo
+
+	fullPath := path
+	enclosingRepo, path := r.SubmoduleRelativePath(path)
+	if enclosingRepo != "" {
+		pathToRepo = filepath.Join(r.repo, enclosingRepo)
+	}
+
+	fetchArgs := []string{"fetch"}
+	if depth := r.submoduleFetchDepth(fullPath); depth > 0 {
+		fetchArgs = append(fetchArgs, fmt.Sprintf("--depth=%d", depth), "origin", r.shallowFetchRefspec(pathToSubmodule, sha))
+	}
+
+	err := r.run(Command{
+		Args: fetchArgs,
+		Dir:  pathToSubmodule,
+	})
+	if err != nil {
+		return err
+	}
+
+	if commitRange > 0 {
+		commitLog, err := r.bumpCommitLog(pathToRepo, path, sha, commitRange)
+		if err != nil {
+			return err
+		}
+
+		messageDetails += commitLog
+	}
+
+	commands := []Command{
+		Command{
+			Args: []string{"checkout", sha},
+			Dir:  pathToSubmodule,
+		},
+		Command{
+			Args: []string{"submodule", "init"},
+			Dir:  pathToSubmodule,
+		},
+		Command{
+			Args: []string{"submodule", "sync"},
+			Dir:  pathToSubmodule,
+		},
+		Command{
+			Args: []string{"submodule", "update", "--init", "--recursive", "--force", r.submoduleJobsArg()},
+			Dir:  pathToSubmodule,
+		},
+		Command{
+			Args: []string{"submodule", "foreach", "--recursive", "git clean -ffd"},
+			Dir:  r.repo,
+		},
+		Command{
+			Args: []string{"clean", "-ffd"},
+			Dir:  pathToSubmodule,
+		},
+		Command{
+			Args: []string{"add", "-A", path},
+			Dir:  pathToRepo,
+		},
+	}
+	commands = append(commands, r.commitCommands(pathToRepo, fmt.Sprintf("%s bump of %s%s", r.messagePrefix, path, messageDetails))...)
+
+	// Record the new gitlink at every level of nesting up to the superproject.
+	for enclosingRepo != "" {
+		outerMessage := fmt.Sprintf("%s bump of %s", r.messagePrefix, enclosingRepo)
+		if r.combineNestedBumps {
+			outerMessage = fmt.Sprintf("%s bump of %s%s", r.messagePrefix, fullPath, messageDetails)
+		}
+
+		var relativePath string
+		enclosingRepo, relativePath = r.SubmoduleRelativePath(enclosingRepo)
+
+		commands = append(commands, Command{
+			Args: []string{"add", "-A", relativePath},
+			Dir:  filepath.Join(r.repo, enclosingRepo),
+		})
+		commands = append(commands, r.commitCommands(filepath.Join(r.repo, enclosingRepo), outerMessage)...)
+	}
+
+	for _, command := range commands {
+		if err := r.run(command); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type PatchSubmoduleOptions struct {
+	// SkipSuperprojectCommit leaves the submodule's new gitlink staged but
+	// uncommitted in the superproject, so that several patches to one
+	// submodule can be recorded with a single CommitStaged.
+	SkipSuperprojectCommit bool
+}
+
+func (r Repo) PatchSubmodule(path, fullPathToPatch string) error {
+	return r.PatchSubmoduleWithOptions(path, fullPathToPatch, PatchSubmoduleOptions{})
+}
+
+func (r Repo) PatchSubmoduleWithOptions(path, fullPathToPatch string, options PatchSubmoduleOptions) error {
+	return r.withHooks("PatchSubmodule", path, func() error {
+		// A streamed patch cannot be checked against its signature, so one
+		// that has to be verified is staged on disk first.
+		if r.patchResolver != nil && r.gpgRunner != nil {
+			return r.withResolvedPatch(fullPathToPatch, func(patch string) error {
+				return r.patchSubmodule(path, patch, options)
+			})
+		}
+
+		if r.patchResolver != nil {
+			patch, err := r.patchResolver.Resolve(fullPathToPatch)
+			if err != nil {
+				return err
+			}
+			defer patch.Close()
+
+			return r.patchSubmoduleFromReader(path, patch, options)
+		}
+
+		if err := r.verifyPatchSignature(fullPathToPatch, fullPathToPatch); err != nil {
+			return err
+		}
+
+		return r.patchSubmodule(path, fullPathToPatch, options)
+	})
+}
+
+// PatchSubmoduleFromReader applies a patch streamed from patch, e.g. straight
+// from an artifact store, the same way PatchSubmodule applies one on disk.
+func (r Repo) PatchSubmoduleFromReader(path string, patch io.Reader) error {
+	return r.withHooks("PatchSubmodule", path, func() error {
+		if r.gpgRunner != nil {
+			return SignatureError{Patch: path, Reason: "a patch read from a stream has no detached signature to verify"}
+		}
+
+		return r.patchSubmoduleFromReader(path, patch, PatchSubmoduleOptions{})
+	})
+}
+
+func (r Repo) patchSubmoduleFromReader(path string, patch io.Reader, options PatchSubmoduleOptions) error {
+	applyCommand := Command{
+		Args:  r.withIdentity("am"),
+		Dir:   filepath.Join(r.repo, path),
+		Stdin: patch,
+	}
+
+	if err := r.run(applyCommand); err != nil {
+		return err
+	}
+
+	return r.commitSubmodulePatch(path, options)
+}
+
+func (r Repo) patchSubmodule(path, fullPathToPatch string, options PatchSubmoduleOptions) error {
+	applyCommand := Command{
+		Args: r.withIdentity("am", fullPathToPatch),
+		Dir:  filepath.Join(r.repo, path),
+	}
+
+	if err := r.run(applyCommand); err != nil {
+		return err
+	}
+
+	return r.commitSubmodulePatch(path, options)
+}
+
+func (r Repo) commitSubmodulePatch(path string, options PatchSubmoduleOptions) error {
+	addCommand := Command{
+		Args: []string{"add", "-A", path},
+		Dir:  r.repo,
+	}
+
+	if output, err := r.combinedOutput(addCommand); err != nil {
+		re := regexp.MustCompile(submoduleMessageRegex)
+		submodulePath := re.FindStringSubmatch(string(output))[1]
+		absoluteSubmodulePath := filepath.Join(r.repo, submodulePath)
+
+		commands := []Command{
+			Command{
+				Args: []string{"add", "-A", "."},
+				Dir:  absoluteSubmodulePath,
+			},
+			r.commitCommand(absoluteSubmodulePath, fmt.Sprintf("%s submodule patch of %s", r.messagePrefix, submodulePath)),
+		}
+
+		for _, command := range commands {
+			if err := r.run(command); err != nil {
+				return err
+			}
+		}
+	}
+
+	if options.SkipSuperprojectCommit {
+		return nil
+	}
+
+	commitCommands := []Command{
+		Command{
+			Args: []string{"add", "-A", "."},
+			Dir:  r.repo,
+		},
+	}
+	commitCommands = append(commitCommands, r.commitCommands(r.repo, fmt.Sprintf("%s patch of %s", r.messagePrefix, path))...)
+
+	for _, command := range commitCommands {
+		if err := r.run(command); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CommitMarker records an empty commit, e.g. to mark the end of a knit run.
+func (r Repo) CommitMarker(message string) error {
+	command := r.commitCommand(r.repo, message)
+	command.Args = append(command.Args, "--allow-empty")
+
+	return r.run(command)
+}
+
+// CommitTree creates a commit of treeSHA on top of parentSHA, or a root commit
+// when parentSHA is empty, without touching the index or working tree. It
+// returns the sha of the new commit; no branch is updated.
+func (r Repo) CommitTree(treeSHA, parentSHA, message string) (string, error) {
+	args := []string{"commit-tree", treeSHA}
+	if parentSHA != "" {
+		args = append(args, "-p", parentSHA)
+	}
+
+	command := Command{
+		Args: r.withIdentity(append(args, "-m", message)...),
+		Dir:  r.repo,
+	}
+
+	if authorName, authorEmail, ok := r.author(); ok {
+		command.Env = []string{
+			fmt.Sprintf("GIT_AUTHOR_NAME=%s", authorName),
+			fmt.Sprintf("GIT_AUTHOR_EMAIL=%s", authorEmail),
+		}
+	}
+
+	output, err := r.combinedOutput(command)
+	if err != nil {
+		return "", fmt.Errorf("Could not commit tree %q: %s\n%s", treeSHA, err, strings.TrimSpace(string(output)))
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
+
+// RecordBaseVersion commits version to .knit-base, so that later runs can
+// check which starting version the branch was built from.
+func (r Repo) RecordBaseVersion(version string) error {
+	if strings.TrimSpace(version) == "" || strings.ContainsAny(version, "\r\n") {
+		return fmt.Errorf("Invalid base version %q", version)
+	}
+
+	if err := r.requireLocal("RecordBaseVersion"); err != nil {
+		return err
+	}
+
+	err := ioutil.WriteFile(filepath.Join(r.repo, baseVersionFile), []byte(version+"\n"), 0644)
+	if err != nil {
+		return err
+	}
+
+	commands := []Command{
+		Command{
+			Args: []string{"add", baseVersionFile},
+			Dir:  r.repo,
+		},
+	}
+	commands = append(commands, r.commitCommands(r.repo, fmt.Sprintf("%s base version %s", r.messagePrefix, version))...)
+
+	for _, command := range commands {
+		if err := r.run(command); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// BaseVersion returns the starting version recorded by RecordBaseVersion.
+func (r Repo) BaseVersion() (string, error) {
+	if err := r.requireLocal("BaseVersion"); err != nil {
+		return "", err
+	}
+
+	contents, err := ioutil.ReadFile(filepath.Join(r.repo, baseVersionFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("Repository %q has no recorded base version", r.repo)
+		}
+
+		return "", err
+	}
+
+	return strings.TrimSpace(string(contents)), nil
+}
+
+// CommitAll records everything staged by deferred operations, along with any
+// other changes in the superproject, as a single commit.
+func (r Repo) CommitAll(message string) error {
+	err := r.run(Command{
+		Args: []string{"add", "-A", "."},
+		Dir:  r.repo,
+	})
+	if err != nil {
+		return err
+	}
+
+	if r.renormalize {
+		if err := r.run(r.renormalizeCommand(r.repo)); err != nil {
+			return err
+		}
+	}
+
+	return r.CommitStaged(message)
+}
+
+// StagePaths adds paths to the index without committing them.
+func (r Repo) StagePaths(paths ...string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+
+	return r.run(Command{
+		Args: append([]string{"add", "--"}, paths...),
+		Dir:  r.repo,
+	})
+}
+
+// CommitStaged commits only what is already in the index, with the same
+// identity and hook policy as knit's own commits.
+func (r Repo) CommitStaged(message string) error {
+	return r.run(r.commitCommand(r.repo, message))
+}
+
+func (r Repo) checkoutArgs(ref string) []string {
+	if !r.useSwitch {
+		return []string{"checkout", ref}
+	}
+
+	err := r.run(Command{
+		Args: []string{"rev-parse", "--verify", "--quiet", fmt.Sprintf("refs/heads/%s", ref)},
+		Dir:  r.repo,
+	})
+	if err != nil {
+		return []string{"checkout", ref}
+	}
+
+	return []string{"switch", ref}
+}
+
+func (r Repo) CheckoutBranch(name string) error {
+	return r.withHooks("CheckoutBranch", name, func() error {
+		return r.checkoutBranch(name)
+	})
+}
+
+func (r Repo) checkoutBranch(name string) error {
+	err := r.run(Command{
+		Args: []string{"rev-parse", "--verify", fmt.Sprintf("refs/heads/%s", name)},
+		Dir:  r.repo,
+	})
+	if err == nil {
+		return fmt.Errorf("Branch %q already exists. Please delete it before trying again", name)
+	}
+
+	args := []string{"checkout", "-b", name}
+	if r.useSwitch {
+		args = []string{"switch", "-c", name}
+	}
+
+	err = r.run(Command{
+		Args: args,
+		Dir:  r.repo,
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RebaseOnto replays the knit commits made on top of upstream, the starting
+// version they were applied to, onto newBase.
+func (r Repo) RebaseOnto(newBase, upstream string) error {
+	if upstream == "" {
+		return errors.New("The starting version to rebase from is required")
+	}
+
+	err := r.run(Command{
+		Args: r.withIdentity("rebase", "--onto", newBase, upstream),
+		Dir:  r.repo,
+	})
+	if err != nil {
+		return r.rebaseConflictError(err)
+	}
+
+	return nil
+}
+
+func (r Repo) RebaseContinue() error {
+	err := r.run(Command{
+		Args: r.withIdentity("-c", "core.editor=true", "rebase", "--continue"),
+		Dir:  r.repo,
+	})
+	if err != nil {
+		return r.rebaseConflictError(err)
+	}
+
+	return nil
+}
+
+func (r Repo) RebaseAbort() error {
+	return r.run(Command{
+		Args: []string{"rebase", "--abort"},
+		Dir:  r.repo,
+	})
+}
+
+func (r Repo) rebaseConflictError(rebaseErr error) error {
+	stoppedAt, err := r.combinedOutput(Command{
+		Args: []string{"rev-parse", "--verify", "REBASE_HEAD"},
+		Dir:  r.repo,
+	})
+	if err != nil {
+		return rebaseErr
+	}
+
+	status, err := r.combinedOutput(Command{
+		Args: []string{"status", "--porcelain"},
+		Dir:  r.repo,
+	})
+	if err != nil {
+		return rebaseErr
+	}
+
+	return fmt.Errorf("Rebase stopped at %s: %s\n%s", strings.TrimSpace(string(stoppedAt)), rebaseErr, strings.TrimRight(string(status), "\n"))
+}
+
+func (r Repo) IsSubmoduleInitialized(path string) (bool, error) {
+	checkedOut, err := r.hasGitEntry(path)
+	if err != nil || !checkedOut {
+		return false, err
+	}
+
+	output, err := r.combinedOutput(Command{
+		Args: []string{"submodule", "status", "--", path},
+		Dir:  r.repo,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	status := strings.TrimRight(string(output), "\n")
+	if status == "" {
+		return false, fmt.Errorf("%q is not a submodule", path)
+	}
+
+	return !strings.HasPrefix(status, "-"), nil
+}
+
+// SubmodulePaths returns the submodule paths recorded in .gitmodules that match
+// the shell-style glob, using path.Match semantics.
+func (r Repo) SubmodulePaths(glob string) ([]string, error) {
+	modulePaths, err := r.gitmodulesPaths()
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, modulePath := range modulePaths {
+		matched, err := pathpkg.Match(glob, modulePath)
+		if err != nil {
+			return nil, err
+		}
+
+		if matched {
+			paths = append(paths, modulePath)
+		}
+	}
+
+	return paths, nil
+}
+
+// ModifiedSubmodules returns the paths of the submodules whose gitlink was
+// added, changed or removed between fromRef and toRef.
+func (r Repo) ModifiedSubmodules(fromRef, toRef string) ([]string, error) {
+	output, err := r.combinedOutput(Command{
+		Args: []string{"diff", "--raw", "--no-abbrev", "--no-renames", "-z", fromRef, toRef},
+		Dir:  r.repo,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return parseGitlinkPaths(output), nil
+}
+
+// parseGitlinkPaths returns the gitlink paths in the output of git diff --raw
+// -z, where each entry is a ":<modes> <shas> <status>" field followed by its
+// path in a field of its own.
+func parseGitlinkPaths(output []byte) []string {
+	var paths []string
+	fields := strings.Split(string(output), "\x00")
+	for i := 0; i+1 < len(fields); i++ {
+		if !strings.HasPrefix(fields[i], ":") {
+			continue
+		}
+
+		modes := strings.Fields(fields[i])
+		path := fields[i+1]
+		i++
+
+		if len(modes) < 2 {
+			continue
+		}
+
+		if modes[0] == ":"+gitlinkMode || modes[1] == gitlinkMode {
+			paths = append(paths, path)
+		}
+	}
+
+	return paths
+}
+
+// ModifiedSubmoduleNames is ModifiedSubmodules with each path mapped to its
+// submodule name in .gitmodules. Removed submodules, which .gitmodules no
+// longer lists, are returned by path.
+func (r Repo) ModifiedSubmoduleNames(fromRef, toRef string) ([]string, error) {
+	paths, err := r.ModifiedSubmodules(fromRef, toRef)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, path := range paths {
+		name, err := r.submoduleName(path)
+		if err != nil {
+			name = path
+		}
+
+		names = append(names, name)
+	}
 
-func (r Repo) submodules() ([]string, error) {
-	modules, err := ioutil.ReadFile(filepath.Join(r.repo, ".gitmodules"))
+	return names, nil
+}
+
+func (r Repo) ChangedFiles(fromRef, toRef string) ([]string, error) {
+	if fromRef == "" {
+		fromRef = "HEAD~1"
+	}
+
+	if toRef == "" {
+		toRef = "HEAD"
+	}
+
+	output, err := r.combinedOutput(Command{
+		Args: []string{"diff", "--name-only", fromRef, toRef},
+		Dir:  r.repo,
+	})
 	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, nil
-		}
+		return nil, err
+	}
+
+	return splitLines(output), nil
+}
 
+func (r Repo) Conflicts() ([]string, error) {
+	output, err := r.combinedOutput(Command{
+		Args: []string{"diff", "--name-only", "--diff-filter=U"},
+		Dir:  r.repo,
+	})
+	if err != nil {
 		return nil, err
 	}
 
-	var modulePaths []string
-	lines := strings.Split(string(modules), "\n")
+	conflicts := []string{}
+	return append(conflicts, splitLines(output)...), nil
+}
 
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if strings.HasPrefix(line, modulePrefix) {
-			modulePaths = append(modulePaths, strings.TrimPrefix(line, modulePrefix))
-		}
+func (r Repo) HashObject(content io.Reader) (string, error) {
+	output, err := r.combinedOutput(Command{
+		Args:  []string{"hash-object", "--stdin"},
+		Dir:   r.repo,
+		Stdin: content,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
+
+func (r Repo) AssertClean() error {
+	output, err := r.combinedOutput(Command{
+		Args: []string{"status", "--porcelain", "--ignore-submodules=none"},
+		Dir:  r.repo,
+	})
+	if err != nil {
+		return err
 	}
 
+	entries := splitLines(output)
+	if len(entries) > 0 {
+		return fmt.Errorf("Repository %q has uncommitted changes:\n%s", r.repo, strings.Join(entries, "\n"))
+	}
+
+	return nil
+}
+
+// submodules returns the full paths of the submodules in .gitmodules that
+// are present in the working tree. On a remote host, where the directories
+// cannot be looked at, a submodule counts as present once it is checked out.
+func (r Repo) submodules() ([]string, error) {
+	modulePaths, err := r.gitmodulesPaths()
+	if err != nil {
+		return nil, err
+	}
+
+	remote := remoteHost(r.runner) != ""
+
 	var paths []string
 	for _, modulePath := range modulePaths {
 		fullModulePath := filepath.Join(r.repo, modulePath)
-		_, err := os.Stat(fullModulePath)
-		if os.IsNotExist(err) {
+		if remote {
+			present, err := r.hasGitEntry(modulePath)
+			if err != nil {
+				return nil, err
+			}
+			if !present {
+				continue
+			}
+		} else if _, err := os.Stat(fullModulePath); os.IsNotExist(err) {
 			continue
 		}
 
@@ -393,3 +1622,446 @@ func (r Repo) submodules() ([]string, error) {
 
 	return paths, nil
 }
+
+// shallowFetchRefspec returns what a shallow fetch of target should ask for.
+// Fetching a branch or tag by name only updates FETCH_HEAD, so names are
+// looked up with ls-remote and fetched into the ref a checkout of them uses.
+func (r Repo) shallowFetchRefspec(pathToSubmodule, target string) string {
+	if regexp.MustCompile(objectIDRegex).MatchString(target) {
+		return target
+	}
+
+	stdout := &bytes.Buffer{}
+	err := r.run(Command{
+		Args:   []string{"ls-remote", "origin", target},
+		Dir:    pathToSubmodule,
+		Stdout: stdout,
+	})
+	if err != nil {
+		return target
+	}
+
+	refs := map[string]bool{}
+	for _, line := range splitLines(stdout.Bytes()) {
+		if fields := strings.Fields(line); len(fields) == 2 {
+			refs[fields[1]] = true
+		}
+	}
+
+	switch {
+	case refs["refs/tags/"+target]:
+		return fmt.Sprintf("+refs/tags/%s:refs/tags/%s", target, target)
+	case refs["refs/heads/"+target]:
+		return fmt.Sprintf("+refs/heads/%s:refs/remotes/origin/%s", target, target)
+	}
+
+	return target
+}
+
+func (r Repo) submoduleFetchDepth(path string) int {
+	modules, err := r.gitmodules()
+	if err != nil {
+		return 0
+	}
+
+	for _, module := range modules {
+		if filepath.Clean(module.path) == filepath.Clean(path) {
+			return module.fetchDepth()
+		}
+	}
+
+	return 0
+}
+
+func (r Repo) submoduleName(path string) (string, error) {
+	modules, err := r.gitmodules()
+	if err != nil {
+		return "", err
+	}
+
+	for _, module := range modules {
+		if filepath.Clean(module.path) == filepath.Clean(path) {
+			return module.name, nil
+		}
+	}
+
+	return "", fmt.Errorf("%q is not a submodule", path)
+}
+
+func (r Repo) gitmodulesPaths() ([]string, error) {
+	modules, err := r.gitmodules()
+	if err != nil {
+		return nil, err
+	}
+
+	var modulePaths []string
+	for _, module := range modules {
+		if module.path != "" {
+			modulePaths = append(modulePaths, module.path)
+		}
+	}
+
+	return modulePaths, nil
+}
+
+func (r Repo) gitmodules() ([]gitmodule, error) {
+	if remoteHost(r.runner) != "" {
+		return r.remoteGitmodules()
+	}
+
+	contents, err := ioutil.ReadFile(filepath.Join(r.repo, ".gitmodules"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return parseGitmodules(string(contents)), nil
+}
+
+// remoteGitmodules reads .gitmodules with git config when the checkout is on
+// another host, rebuilding its sections for parseGitmodules.
+func (r Repo) remoteGitmodules() ([]gitmodule, error) {
+	output, err := r.combinedOutput(Command{
+		Args: []string{"config", "-f", ".gitmodules", "--list"},
+		Dir:  r.repo,
+	})
+	if err != nil {
+		if strings.Contains(string(output), "No such file or directory") {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("Could not read .gitmodules: %s\n%s", err, strings.TrimSpace(string(output)))
+	}
+
+	var contents []string
+	current := ""
+	for _, line := range splitLines(output) {
+		key, value, ok := gitmodulesEntry(line)
+		if !ok || !strings.HasPrefix(key, "submodule.") {
+			continue
+		}
+
+		dot := strings.LastIndex(key, ".")
+		name := key[len("submodule."):dot]
+		if name != current {
+			contents = append(contents, fmt.Sprintf("[submodule \"%s\"]", name))
+			current = name
+		}
+
+		contents = append(contents, fmt.Sprintf("%s = %s", key[dot+1:], value))
+	}
+
+	return parseGitmodules(strings.Join(contents, "\n")), nil
+}
+
+// hasGitEntry reports whether dir, relative to the checkout, has a .git file or
+// directory of its own. On another host git resolves it instead of stat.
+func (r Repo) hasGitEntry(dir string) (bool, error) {
+	if remoteHost(r.runner) != "" {
+		err := r.run(Command{
+			Args: []string{"rev-parse", "--resolve-git-dir", filepath.Join(dir, ".git")},
+			Dir:  r.repo,
+		})
+		return err == nil, nil
+	}
+
+	_, err := os.Lstat(filepath.Join(r.repo, dir, ".git"))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// deinitStaleSubmodules deinitializes the submodules initialized in the
+// repository config that the checked out .gitmodules no longer lists and git
+// does not track, whether the checkout just dropped them or a previous run
+// left them behind, so that they do not trip up the submodule init/update
+// that follows. Their git directories are kept, as git submodule deinit does.
+func (r Repo) deinitStaleSubmodules() error {
+	stdout := &bytes.Buffer{}
+	err := r.run(Command{
+		Args:   []string{"config", "--name-only", "--get-regexp", `^submodule\..*\.url$`},
+		Dir:    r.repo,
+		Stdout: stdout,
+	})
+	if err != nil {
+		// git config exits non-zero when no submodule is initialized.
+		return nil
+	}
+
+	modules, err := r.gitmodules()
+	if err != nil {
+		return err
+	}
+
+	listed := map[string]bool{}
+	for _, module := range modules {
+		listed[module.name] = true
+		listed[filepath.Clean(module.path)] = true
+	}
+
+	for _, key := range splitLines(stdout.Bytes()) {
+		name := strings.TrimSuffix(strings.TrimPrefix(key, "submodule."), ".url")
+		if name == "" || listed[name] {
+			continue
+		}
+
+		path, err := r.submoduleWorktree(name)
+		if err != nil {
+			return err
+		}
+
+		if path != "" {
+			tracked, err := r.isTracked(path)
+			if err != nil {
+				return err
+			}
+			if listed[path] || tracked {
+				continue
+			}
+		}
+
+		commands := []Command{
+			Command{
+				Args: []string{"config", "--remove-section", fmt.Sprintf("submodule.%s", name)},
+				Dir:  r.repo,
+			},
+		}
+		if path != "" {
+			commands = append(commands, Command{
+				Args: []string{"clean", "-ffdq", "--", path},
+				Dir:  r.repo,
+			})
+		}
+
+		for _, command := range commands {
+			if err := r.run(command); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// submoduleWorktree returns the path, relative to the checkout, that the
+// submodule called name was last checked out at, or "" when that is not
+// recorded or lies outside the checkout.
+func (r Repo) submoduleWorktree(name string) (string, error) {
+	stdout := &bytes.Buffer{}
+	err := r.run(Command{
+		Args:   []string{"rev-parse", "--git-path", fmt.Sprintf("modules/%s", name)},
+		Dir:    r.repo,
+		Stdout: stdout,
+	})
+	if err != nil {
+		return "", err
+	}
+	moduleDir := strings.TrimSpace(stdout.String())
+
+	stdout = &bytes.Buffer{}
+	err = r.run(Command{
+		Args:   []string{"config", "-f", filepath.Join(moduleDir, "config"), "core.worktree"},
+		Dir:    r.repo,
+		Stdout: stdout,
+	})
+	if err != nil {
+		return "", nil
+	}
+
+	path := filepath.Join(moduleDir, strings.TrimSpace(stdout.String()))
+	if filepath.IsAbs(path) {
+		path, err = filepath.Rel(r.repo, path)
+		if err != nil {
+			return "", nil
+		}
+	}
+
+	if path == "." || path == ".." || strings.HasPrefix(path, "../") {
+		return "", nil
+	}
+
+	return path, nil
+}
+
+// isTracked reports whether git tracks anything at path.
+func (r Repo) isTracked(path string) (bool, error) {
+	stdout := &bytes.Buffer{}
+	err := r.run(Command{
+		Args:   []string{"ls-files", "-z", "--", path},
+		Dir:    r.repo,
+		Stdout: stdout,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return stdout.Len() > 0, nil
+}
+
+func (r Repo) withHooks(operation, path string, f func() error) error {
+	if r.autoLock {
+		unlock, err := r.Lock()
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+
+	if r.preHook != nil {
+		if err := r.preHook(operation, path); err != nil {
+			return err
+		}
+	}
+
+	start := time.Now()
+	err := f()
+	r.recordOperation(operation, time.Since(start), err)
+	if err != nil {
+		return err
+	}
+
+	if r.postHook != nil {
+		return r.postHook(operation, path)
+	}
+
+	return nil
+}
+
+// withPatchHooks is withHooks for the operations that apply patch, which must
+// pass signature verification before anything is run.
+func (r Repo) withPatchHooks(operation, patch string, f func() error) error {
+	return r.withHooks(operation, patch, func() error {
+		if err := r.verifyPatchSignature(patch, patch); err != nil {
+			return err
+		}
+
+		return f()
+	})
+}
+
+func (r Repo) withIdentity(args ...string) []string {
+	return append([]string{
+		"-c", fmt.Sprintf("user.name=%s", r.committerName),
+		"-c", fmt.Sprintf("user.email=%s", r.committerEmail),
+	}, args...)
+}
+
+func (r Repo) commitCommand(dir, message string) Command {
+	args := r.withIdentity("commit", "-m", message, "--no-verify")
+
+	if authorName, authorEmail, ok := r.author(); ok {
+		args = append(args, fmt.Sprintf("--author=%s <%s>", authorName, authorEmail))
+	}
+
+	return Command{
+		Args: args,
+		Dir:  dir,
+	}
+}
+
+// author returns the identity set with WithAuthor, filling in whichever half
+// is missing from the committer. ok is false when no author was set.
+func (r Repo) author() (name, email string, ok bool) {
+	if r.authorName == "" && r.authorEmail == "" {
+		return "", "", false
+	}
+
+	name = r.authorName
+	if name == "" {
+		name = r.committerName
+	}
+
+	email = r.authorEmail
+	if email == "" {
+		email = r.committerEmail
+	}
+
+	return name, email, true
+}
+
+func (r Repo) commitCommands(dir, message string) []Command {
+	if r.deferCommits && dir == r.repo {
+		return nil
+	}
+
+	if r.renormalize {
+		return []Command{r.renormalizeCommand(dir), r.commitCommand(dir, message)}
+	}
+
+	return []Command{r.commitCommand(dir, message)}
+}
+
+func (r Repo) renormalizeCommand(dir string) Command {
+	return Command{
+		Args: []string{"add", "--renormalize", "."},
+		Dir:  dir,
+	}
+}
+
+func (r Repo) patchArgs(args ...string) []string {
+	if r.deferCommits {
+		return append([]string{"apply", "--index"}, args...)
+	}
+
+	return r.withIdentity(append([]string{"am"}, args...)...)
+}
+
+func (r Repo) run(command Command) error {
+	if isNetworkCommand(command) {
+		defer acquireNetworkSlot()()
+	}
+
+	if !r.canRetryOnIndexLock(command) {
+		return r.runner.Run(command)
+	}
+
+	rewind := stdinRewinder(command.Stdin)
+
+	var deadline time.Time
+	for {
+		stderr := &bytes.Buffer{}
+		attempt := command
+		attempt.Stderr = teeWriter(command.Stderr, stderr)
+
+		err := r.runner.Run(attempt)
+		if err == nil || !r.waitForIndexLock(stderr.Bytes(), &deadline) || rewind() != nil {
+			return err
+		}
+	}
+}
+
+func (r Repo) combinedOutput(command Command) ([]byte, error) {
+	if isNetworkCommand(command) {
+		defer acquireNetworkSlot()()
+	}
+
+	if !r.canRetryOnIndexLock(command) {
+		return r.runner.CombinedOutput(command)
+	}
+
+	rewind := stdinRewinder(command.Stdin)
+
+	var deadline time.Time
+	for {
+		output, err := r.runner.CombinedOutput(command)
+		if err == nil || !r.waitForIndexLock(output, &deadline) || rewind() != nil {
+			return output, err
+		}
+	}
+}
+
+func splitLines(output []byte) []string {
+	var lines []string
+	for _, line := range strings.Split(string(output), "\n") {
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines
+}
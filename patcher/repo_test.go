@@ -6,6 +6,8 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/pivotal-cf/knit/patcher"
 	"github.com/pivotal-cf/knit/patcher/fakes"
@@ -54,6 +56,11 @@ var _ = Describe("Repo", func() {
 					Args: []string{"checkout", "some-ref"},
 					Dir:  repoPath,
 				},
+				patcher.Command{
+					Args:   []string{"config", "--name-only", "--get-regexp", `^submodule\..*\.url$`},
+					Dir:    repoPath,
+					Stdout: runner.RunCall.Receives.Commands[1].Stdout,
+				},
 				patcher.Command{
 					Args: []string{"clean", "-ffd"},
 					Dir:  repoPath,
@@ -77,12 +84,110 @@ var _ = Describe("Repo", func() {
 			}))
 		})
 
+		Context("when a submodule job count is set", func() {
+			It("updates that many submodules at once", func() {
+				err := r.WithSubmoduleJobs(8).Checkout("some-ref")
+				Expect(err).NotTo(HaveOccurred())
+
+				var args [][]string
+				for _, command := range runner.RunCall.Receives.Commands {
+					args = append(args, command.Args)
+				}
+				Expect(args).To(ContainElement([]string{"submodule", "update", "--init", "--recursive", "--force", "--jobs=8"}))
+			})
+		})
+
+		Context("when submodules no longer listed in .gitmodules are still initialized", func() {
+			var tracked map[string]bool
+
+			BeforeEach(func() {
+				err := ioutil.WriteFile(filepath.Join(repoPath, ".gitmodules"), []byte(`[submodule "src/module-one"]
+	path = src/module-one
+	url = https://example.com/module-one.git
+`), 0644)
+				Expect(err).NotTo(HaveOccurred())
+
+				tracked = map[string]bool{}
+				runner.RunCall.Stub = func(command patcher.Command) error {
+					switch {
+					case command.Args[0] == "config" && command.Args[1] == "--name-only":
+						fmt.Fprint(command.Stdout, "submodule.src/module-one.url\nsubmodule.src/module-two.url\nsubmodule.old-name.url\n")
+					case command.Args[0] == "rev-parse":
+						fmt.Fprintf(command.Stdout, ".git/%s\n", command.Args[2])
+					case command.Args[0] == "config" && command.Args[1] == "-f":
+						switch command.Args[2] {
+						case ".git/modules/src/module-two/config":
+							fmt.Fprintln(command.Stdout, "../../../../src/module-two")
+						case ".git/modules/old-name/config":
+							fmt.Fprintln(command.Stdout, "../../../src/module-three")
+						}
+					case command.Args[0] == "ls-files":
+						if tracked[command.Args[3]] {
+							fmt.Fprint(command.Stdout, command.Args[3]+"\x00")
+						}
+					}
+					return nil
+				}
+			})
+
+			It("deinitializes the ones git does not track once the checkout is done", func() {
+				err := r.Checkout("some-ref")
+				Expect(err).NotTo(HaveOccurred())
+
+				var args [][]string
+				for _, command := range runner.RunCall.Receives.Commands {
+					Expect(command.Dir).To(Equal(repoPath))
+					args = append(args, command.Args)
+				}
+				Expect(args[:10]).To(Equal([][]string{
+					{"checkout", "some-ref"},
+					{"config", "--name-only", "--get-regexp", `^submodule\..*\.url$`},
+					{"rev-parse", "--git-path", "modules/src/module-two"},
+					{"config", "-f", ".git/modules/src/module-two/config", "core.worktree"},
+					{"ls-files", "-z", "--", "src/module-two"},
+					{"config", "--remove-section", "submodule.src/module-two"},
+					{"clean", "-ffdq", "--", "src/module-two"},
+					{"rev-parse", "--git-path", "modules/old-name"},
+					{"config", "-f", ".git/modules/old-name/config", "core.worktree"},
+					{"ls-files", "-z", "--", "src/module-three"},
+				}))
+				Expect(args[10:12]).To(Equal([][]string{
+					{"config", "--remove-section", "submodule.old-name"},
+					{"clean", "-ffdq", "--", "src/module-three"},
+				}))
+			})
+
+			It("leaves the ones git still tracks alone", func() {
+				tracked["src/module-two"] = true
+				tracked["src/module-three"] = true
+
+				err := r.Checkout("some-ref")
+				Expect(err).NotTo(HaveOccurred())
+
+				for _, command := range runner.RunCall.Receives.Commands {
+					Expect(command.Args).NotTo(ContainElement("--remove-section"))
+					Expect(command.Args).NotTo(ContainElement("-ffdq"))
+				}
+			})
+		})
+
+		Context("when no submodule is initialized", func() {
+			It("checks out without deinitializing anything", func() {
+				runner.RunCall.Returns.Errors = []error{nil, errors.New("exit status 1")}
+
+				err := r.Checkout("some-ref")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(runner.RunCall.Receives.Commands[2].Args).To(Equal([]string{"clean", "-ffd"}))
+			})
+		})
+
 		Context("failure cases", func() {
 			Context("when the checkout fails", func() {
 				It("returns an error", func() {
 					runner.RunCall.Returns.Errors = []error{errors.New("some error")}
 					err := r.Checkout("invalid-ref")
 					Expect(err).To(MatchError("some error"))
+					Expect(runner.RunCall.Receives.Commands).To(HaveLen(1))
 				})
 			})
 		})
@@ -209,6 +314,49 @@ var _ = Describe("Repo", func() {
 			})
 		})
 
+		Context("when a ref is not specified for the new submodule", func() {
+			It("leaves the submodule at the default branch of the URL", func() {
+				err := r.AddSubmodule("src/some/path", "some-url", "", "")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(runner.RunCall.Receives.Commands).To(Equal([]patcher.Command{
+					patcher.Command{
+						Args: []string{"submodule", "add", "--force", "some-url", "src/some/path"},
+						Dir:  repoPath,
+					},
+					patcher.Command{
+						Args: []string{"submodule", "foreach", "--recursive", "git submodule sync"},
+						Dir:  filepath.Join(repoPath, "src", "some", "path"),
+					},
+					patcher.Command{
+						Args: []string{"submodule", "update", "--init", "--recursive", "--force", "--jobs=4"},
+						Dir:  filepath.Join(repoPath, "src", "some", "path"),
+					},
+					patcher.Command{
+						Args: []string{"submodule", "foreach", "--recursive", "git clean -ffd"},
+						Dir:  repoPath,
+					},
+					patcher.Command{
+						Args: []string{"clean", "-ffd"},
+						Dir:  filepath.Join(repoPath, "src", "some", "path"),
+					},
+					patcher.Command{
+						Args: []string{"add", "-A", "src/some/path"},
+						Dir:  repoPath,
+					},
+					patcher.Command{
+						Args: []string{
+							"-c", fmt.Sprintf("user.name=%s", user),
+							"-c", fmt.Sprintf("user.email=%s", email),
+							"commit",
+							"-m", "Knit addition of src/some/path",
+							"--no-verify",
+						},
+						Dir: repoPath,
+					},
+				}))
+			})
+		})
+
 		Context("when an error occurs", func() {
 			Context("when the command fails", func() {
 				It("returns an error", func() {
@@ -372,6 +520,28 @@ var _ = Describe("Repo", func() {
 			}))
 		})
 
+		Context("when nested bumps are combined", func() {
+			It("describes the whole nested bump in the superproject commit", func() {
+				err := r.WithCombinedNestedBumps().BumpSubmodule("src/some/path/src/some/other/path", "a-sha")
+				Expect(err).NotTo(HaveOccurred())
+
+				commands := runner.RunCall.Receives.Commands
+				Expect(commands).To(HaveLen(11))
+				Expect(commands[8].Args).To(ContainElement("Knit bump of src/some/other/path"))
+				Expect(commands[8].Dir).To(Equal(filepath.Join(repoPath, "src/some/path")))
+				Expect(commands[10]).To(Equal(patcher.Command{
+					Args: []string{
+						"-c", fmt.Sprintf("user.name=%s", user),
+						"-c", fmt.Sprintf("user.email=%s", email),
+						"commit",
+						"-m", "Knit bump of src/some/path/src/some/other/path",
+						"--no-verify",
+					},
+					Dir: repoPath,
+				}))
+			})
+		})
+
 		Context("when an error occurs", func() {
 			Context("when the command fails", func() {
 				It("returns an error", func() {
@@ -522,4 +692,2349 @@ var _ = Describe("Repo", func() {
 			})
 		})
 	})
+
+	Describe("WithAuthor", func() {
+		It("attributes knit commits to the author", func() {
+			err := r.WithAuthor("Some Author", "author@example.com").RemoveSubmodule("src/some/path")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(runner.RunCall.Receives.Commands[2]).To(Equal(patcher.Command{
+				Args: []string{
+					"-c", fmt.Sprintf("user.name=%s", user),
+					"-c", fmt.Sprintf("user.email=%s", email),
+					"commit",
+					"-m", "Knit removal of submodule 'src/some/path'",
+					"--no-verify",
+					"--author=Some Author <author@example.com>",
+				},
+				Dir: repoPath,
+			}))
+		})
+
+		Context("when only the author name is provided", func() {
+			It("falls back to the committer email", func() {
+				err := r.WithAuthor("Some Author", "").RemoveSubmodule("src/some/path")
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(runner.RunCall.Receives.Commands[2].Args).To(ContainElement(
+					fmt.Sprintf("--author=Some Author <%s>", email),
+				))
+			})
+		})
+	})
+
+	Describe("ModifiedSubmodules", func() {
+		BeforeEach(func() {
+			runner.CombinedOutputCall.Returns.Errors = []error{nil}
+			runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte(
+				":160000 160000 aaaaaaa bbbbbbb M\x00src/module-one\x00" +
+					":100644 100644 ccccccc ddddddd M\x00some-file.txt\x00" +
+					":000000 160000 0000000 eeeeeee A\x00src/module-two\x00" +
+					":160000 000000 fffffff 0000000 D\x00src/module three\x00",
+			)}
+		})
+
+		It("returns the submodule paths whose gitlink changed between the refs", func() {
+			paths, err := r.ModifiedSubmodules("from-ref", "to-ref")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(paths).To(Equal([]string{"src/module-one", "src/module-two", "src/module three"}))
+
+			Expect(runner.CombinedOutputCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{"diff", "--raw", "--no-abbrev", "--no-renames", "-z", "from-ref", "to-ref"},
+					Dir:  repoPath,
+				},
+			}))
+		})
+
+		It("maps the paths to submodule names through .gitmodules", func() {
+			err := ioutil.WriteFile(filepath.Join(repoPath, ".gitmodules"), []byte(`[submodule "one"]
+	path = src/module-one
+	url = https://example.com/one.git
+[submodule "two"]
+	path = src/module-two
+	url = https://example.com/two.git
+`), 0644)
+			Expect(err).NotTo(HaveOccurred())
+
+			names, err := r.ModifiedSubmoduleNames("from-ref", "to-ref")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(names).To(Equal([]string{"one", "two", "src/module three"}))
+		})
+
+		Context("when the diff fails", func() {
+			It("returns an error", func() {
+				runner.CombinedOutputCall.Returns.Errors = []error{errors.New("meow")}
+				_, err := r.ModifiedSubmodules("from-ref", "to-ref")
+				Expect(err).To(MatchError("meow"))
+			})
+		})
+	})
+
+	Describe("AssertClean", func() {
+		It("checks the status of the repository including submodules", func() {
+			err := r.AssertClean()
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(runner.CombinedOutputCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{"status", "--porcelain", "--ignore-submodules=none"},
+					Dir:  repoPath,
+				},
+			}))
+		})
+
+		Context("when the repository has uncommitted changes", func() {
+			It("returns an error listing the entries", func() {
+				runner.CombinedOutputCall.Returns.Errors = []error{nil}
+				runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte(" M src/module-one\n?? stray-file\n")}
+
+				err := r.AssertClean()
+				Expect(err).To(MatchError(fmt.Sprintf("Repository %q has uncommitted changes:\n M src/module-one\n?? stray-file", repoPath)))
+			})
+		})
+
+		Context("when the status command fails", func() {
+			It("returns an error", func() {
+				runner.CombinedOutputCall.Returns.Errors = []error{errors.New("meow")}
+				runner.CombinedOutputCall.Returns.Outputs = [][]byte{nil}
+
+				err := r.AssertClean()
+				Expect(err).To(MatchError("meow"))
+			})
+		})
+	})
+
+	Describe("ChangedFiles", func() {
+		BeforeEach(func() {
+			runner.CombinedOutputCall.Returns.Errors = []error{nil}
+			runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte("some-file.txt\nsrc/module-one\n")}
+		})
+
+		It("returns the files changed between the refs", func() {
+			files, err := r.ChangedFiles("from-ref", "to-ref")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(files).To(Equal([]string{"some-file.txt", "src/module-one"}))
+
+			Expect(runner.CombinedOutputCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{"diff", "--name-only", "from-ref", "to-ref"},
+					Dir:  repoPath,
+				},
+			}))
+		})
+
+		Context("when no refs are provided", func() {
+			It("inspects the last commit", func() {
+				_, err := r.ChangedFiles("", "")
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(runner.CombinedOutputCall.Receives.Commands[0].Args).To(Equal(
+					[]string{"diff", "--name-only", "HEAD~1", "HEAD"},
+				))
+			})
+		})
+
+		Context("when the diff fails", func() {
+			It("returns an error", func() {
+				runner.CombinedOutputCall.Returns.Errors = []error{errors.New("meow")}
+				_, err := r.ChangedFiles("from-ref", "to-ref")
+				Expect(err).To(MatchError("meow"))
+			})
+		})
+	})
+
+	Describe("NewRepoE", func() {
+		It("returns a repo for a valid committer identity", func() {
+			repo, err := patcher.NewRepoE(runner, repoPath, user, email)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(repo).To(Equal(r))
+		})
+
+		Context("when the committer name is empty", func() {
+			It("returns an error", func() {
+				_, err := patcher.NewRepoE(runner, repoPath, " ", email)
+				Expect(err).To(MatchError("committer name must not be empty"))
+			})
+		})
+
+		Context("when the committer email is not plausible", func() {
+			It("returns an error", func() {
+				_, err := patcher.NewRepoE(runner, repoPath, user, "not-an-email")
+				Expect(err).To(MatchError(`committer email "not-an-email" is not a valid email address`))
+
+				_, err = patcher.NewRepoE(runner, repoPath, user, "")
+				Expect(err).To(MatchError(`committer email "" is not a valid email address`))
+			})
+		})
+	})
+
+	Describe("RebaseOnto", func() {
+		It("replays the knit commits since the starting version onto the new base", func() {
+			err := r.RebaseOnto("new-base", "old-base")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(runner.CombinedOutputCall.Receives.Commands).To(BeEmpty())
+			Expect(runner.RunCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{
+						"-c", fmt.Sprintf("user.name=%s", user),
+						"-c", fmt.Sprintf("user.email=%s", email),
+						"rebase", "--onto", "new-base", "old-base",
+					},
+					Dir: repoPath,
+				},
+			}))
+		})
+
+		Context("when the starting version is not given", func() {
+			It("returns an error without rebasing", func() {
+				err := r.RebaseOnto("new-base", "")
+				Expect(err).To(MatchError("The starting version to rebase from is required"))
+				Expect(runner.RunCall.Receives.Commands).To(BeEmpty())
+			})
+		})
+
+		Context("when the rebase stops on a conflict", func() {
+			BeforeEach(func() {
+				runner.RunCall.Returns.Errors = []error{errors.New("exit status 1")}
+				runner.CombinedOutputCall.Returns.Errors = []error{nil, nil}
+				runner.CombinedOutputCall.Returns.Outputs = [][]byte{
+					[]byte("abc123\n"),
+					[]byte("UU some-file.txt\n"),
+				}
+			})
+
+			It("returns an error with the stopped-at commit and the status", func() {
+				err := r.RebaseOnto("new-base", "old-base")
+				Expect(err).To(MatchError("Rebase stopped at abc123: exit status 1\nUU some-file.txt"))
+
+				Expect(runner.CombinedOutputCall.Receives.Commands).To(Equal([]patcher.Command{
+					patcher.Command{
+						Args: []string{"rev-parse", "--verify", "REBASE_HEAD"},
+						Dir:  repoPath,
+					},
+					patcher.Command{
+						Args: []string{"status", "--porcelain"},
+						Dir:  repoPath,
+					},
+				}))
+			})
+		})
+
+		Context("when the rebase fails without stopping", func() {
+			It("returns the original error", func() {
+				runner.RunCall.Returns.Errors = []error{errors.New("meow")}
+				runner.CombinedOutputCall.Returns.Errors = []error{errors.New("no REBASE_HEAD")}
+				runner.CombinedOutputCall.Returns.Outputs = [][]byte{nil}
+
+				err := r.RebaseOnto("new-base", "old-base")
+				Expect(err).To(MatchError("meow"))
+			})
+		})
+	})
+
+	Describe("RebaseContinue", func() {
+		It("continues the rebase without opening an editor", func() {
+			err := r.RebaseContinue()
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(runner.RunCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{
+						"-c", fmt.Sprintf("user.name=%s", user),
+						"-c", fmt.Sprintf("user.email=%s", email),
+						"-c", "core.editor=true",
+						"rebase", "--continue",
+					},
+					Dir: repoPath,
+				},
+			}))
+		})
+	})
+
+	Describe("RebaseAbort", func() {
+		It("aborts the rebase", func() {
+			err := r.RebaseAbort()
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(runner.RunCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{"rebase", "--abort"},
+					Dir:  repoPath,
+				},
+			}))
+		})
+	})
+
+	Describe("NewRepoFromConfig", func() {
+		var savedEnv map[string]string
+
+		BeforeEach(func() {
+			savedEnv = map[string]string{}
+			for _, key := range []string{"GIT_AUTHOR_NAME", "GIT_AUTHOR_EMAIL"} {
+				if value, ok := os.LookupEnv(key); ok {
+					savedEnv[key] = value
+				}
+				os.Setenv(key, "")
+			}
+		})
+
+		AfterEach(func() {
+			for _, key := range []string{"GIT_AUTHOR_NAME", "GIT_AUTHOR_EMAIL"} {
+				os.Unsetenv(key)
+				if value, ok := savedEnv[key]; ok {
+					os.Setenv(key, value)
+				}
+			}
+		})
+
+		It("reads the committer identity from git config", func() {
+			runner.CombinedOutputCall.Returns.Errors = []error{nil, nil}
+			runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte(user + "\n"), []byte(email + "\n")}
+
+			repo, err := patcher.NewRepoFromConfig(runner, repoPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(repo).To(Equal(r))
+
+			Expect(runner.CombinedOutputCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{"config", "--get", "user.name"},
+					Dir:  repoPath,
+				},
+				patcher.Command{
+					Args: []string{"config", "--get", "user.email"},
+					Dir:  repoPath,
+				},
+			}))
+		})
+
+		Context("when git config has no identity", func() {
+			BeforeEach(func() {
+				runner.CombinedOutputCall.Returns.Errors = []error{errors.New("exit status 1"), errors.New("exit status 1")}
+				runner.CombinedOutputCall.Returns.Outputs = [][]byte{nil, nil}
+			})
+
+			It("falls back to the environment", func() {
+				os.Setenv("GIT_AUTHOR_NAME", user)
+				os.Setenv("GIT_AUTHOR_EMAIL", email)
+
+				repo, err := patcher.NewRepoFromConfig(runner, repoPath)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(repo).To(Equal(r))
+			})
+
+			Context("when the environment has no identity either", func() {
+				It("returns an error", func() {
+					_, err := patcher.NewRepoFromConfig(runner, repoPath)
+					Expect(err).To(MatchError("could not determine committer name: set git config user.name or GIT_AUTHOR_NAME"))
+				})
+			})
+		})
+	})
+
+	Describe("Conflicts", func() {
+		It("returns the unmerged paths", func() {
+			runner.CombinedOutputCall.Returns.Errors = []error{nil}
+			runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte("some-file.txt\nother-file.txt\n")}
+
+			conflicts, err := r.Conflicts()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(conflicts).To(Equal([]string{"some-file.txt", "other-file.txt"}))
+
+			Expect(runner.CombinedOutputCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{"diff", "--name-only", "--diff-filter=U"},
+					Dir:  repoPath,
+				},
+			}))
+		})
+
+		Context("when there are no conflicts", func() {
+			It("returns an empty slice", func() {
+				conflicts, err := r.Conflicts()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(conflicts).To(Equal([]string{}))
+			})
+		})
+
+		Context("when the diff fails", func() {
+			It("returns an error", func() {
+				runner.CombinedOutputCall.Returns.Errors = []error{errors.New("meow")}
+				runner.CombinedOutputCall.Returns.Outputs = [][]byte{nil}
+
+				_, err := r.Conflicts()
+				Expect(err).To(MatchError("meow"))
+			})
+		})
+	})
+
+	Describe("ApplyManifest", func() {
+		It("applies each entry with its own options in order", func() {
+			err := r.ApplyManifest([]patcher.PatchEntry{
+				{Path: "some-dir/first.patch"},
+				{Path: "some-dir/second.patch", ThreeWay: true, Strip: 2, Directory: "src/some/path"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(runner.RunCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{
+						"-c", fmt.Sprintf("user.name=%s", user),
+						"-c", fmt.Sprintf("user.email=%s", email),
+						"am",
+						"some-dir/first.patch",
+					},
+					Dir: repoPath,
+				},
+				patcher.Command{
+					Args: []string{
+						"-c", fmt.Sprintf("user.name=%s", user),
+						"-c", fmt.Sprintf("user.email=%s", email),
+						"am",
+						"--3way",
+						"-p2",
+						"--directory=src/some/path",
+						"some-dir/second.patch",
+					},
+					Dir: repoPath,
+				},
+			}))
+		})
+
+		Context("when an entry fails to apply", func() {
+			It("stops and reports the offending entry", func() {
+				runner.RunCall.Returns.Errors = []error{nil, errors.New("meow")}
+
+				err := r.ApplyManifest([]patcher.PatchEntry{
+					{Path: "some-dir/first.patch"},
+					{Path: "some-dir/second.patch"},
+					{Path: "some-dir/third.patch"},
+				})
+				Expect(err).To(MatchError(`could not apply manifest entry 1 ("some-dir/second.patch"): meow`))
+				Expect(runner.RunCall.Count).To(Equal(2))
+			})
+		})
+	})
+
+	Describe("BumpSubmoduleToTrackedBranch", func() {
+		var lsRemoteOutput string
+
+		BeforeEach(func() {
+			err := ioutil.WriteFile(filepath.Join(repoPath, ".gitmodules"), []byte(`[submodule "src/module-one"]
+	path = src/module-one
+	url = https://example.com/module-one.git
+	branch = develop
+[submodule "src/module-two"]
+	path = src/module-two
+	url = https://example.com/module-two.git
+`), 0644)
+			Expect(err).NotTo(HaveOccurred())
+
+			lsRemoteOutput = "abc123\trefs/heads/develop\n"
+			runner.RunCall.Stub = func(command patcher.Command) error {
+				if command.Args[0] == "ls-remote" {
+					fmt.Fprint(command.Stdout, lsRemoteOutput)
+				}
+				return nil
+			}
+		})
+
+		It("bumps the submodule to the tip of its tracked branch", func() {
+			err := r.BumpSubmoduleToTrackedBranch("src/module-one")
+			Expect(err).NotTo(HaveOccurred())
+
+			lsRemote := runner.RunCall.Receives.Commands[0]
+			Expect(lsRemote.Args).To(Equal([]string{"ls-remote", "origin", "refs/heads/develop"}))
+			Expect(lsRemote.Dir).To(Equal(filepath.Join(repoPath, "src", "module-one")))
+
+			Expect(runner.RunCall.Receives.Commands[2]).To(Equal(patcher.Command{
+				Args: []string{"checkout", "abc123"},
+				Dir:  filepath.Join(repoPath, "src", "module-one"),
+			}))
+
+			Expect(runner.RunCall.Receives.Commands[len(runner.RunCall.Receives.Commands)-1]).To(Equal(patcher.Command{
+				Args: []string{
+					"-c", fmt.Sprintf("user.name=%s", user),
+					"-c", fmt.Sprintf("user.email=%s", email),
+					"commit",
+					"-m", "Knit bump of src/module-one to abc123 (tip of develop)",
+					"--no-verify",
+				},
+				Dir: repoPath,
+			}))
+		})
+
+		Context("when the submodule does not track a branch", func() {
+			It("returns an error without bumping", func() {
+				err := r.BumpSubmoduleToTrackedBranch("src/module-two")
+				Expect(err).To(MatchError(`Submodule "src/module-two" does not track a branch in .gitmodules`))
+				Expect(runner.RunCall.Count).To(Equal(0))
+			})
+		})
+
+		Context("when the tracked branch does not exist on the remote", func() {
+			It("returns an error without bumping", func() {
+				lsRemoteOutput = ""
+
+				err := r.BumpSubmoduleToTrackedBranch("src/module-one")
+				Expect(err).To(MatchError(`Branch "develop" tracked by submodule "src/module-one" does not exist on origin`))
+				Expect(runner.RunCall.Count).To(Equal(1))
+			})
+		})
+
+		Context("when the submodule is nested", func() {
+			It("reads the tracked branch from the enclosing submodule's .gitmodules", func() {
+				err := os.MkdirAll(filepath.Join(repoPath, "src/module-one/src/nested"), 0755)
+				Expect(err).NotTo(HaveOccurred())
+
+				err = ioutil.WriteFile(filepath.Join(repoPath, "src/module-one/.gitmodules"), []byte(`[submodule "nested"]
+	path = src/nested
+	url = https://example.com/nested.git
+	branch = main
+`), 0644)
+				Expect(err).NotTo(HaveOccurred())
+
+				err = r.BumpSubmoduleToTrackedBranch("src/module-one/src/nested")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(runner.RunCall.Receives.Commands[0].Args).To(Equal([]string{"ls-remote", "origin", "refs/heads/main"}))
+			})
+		})
+	})
+
+	Describe("Clean", func() {
+		It("cleans the repository and its submodules recursively", func() {
+			err := r.Clean()
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(runner.RunCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{"clean", "-ffd"},
+					Dir:  repoPath,
+				},
+				patcher.Command{
+					Args: []string{"submodule", "foreach", "--recursive", "git clean -ffd"},
+					Dir:  repoPath,
+				},
+			}))
+		})
+
+		Context("when the clean fails", func() {
+			It("returns an error", func() {
+				runner.RunCall.Returns.Errors = []error{errors.New("meow")}
+				err := r.Clean()
+				Expect(err).To(MatchError("meow"))
+				Expect(runner.RunCall.Count).To(Equal(1))
+			})
+		})
+	})
+
+	Describe("CommitMarker", func() {
+		It("creates an empty commit with the provided message", func() {
+			err := r.CommitMarker("knit run 1 complete")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(runner.RunCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{
+						"-c", fmt.Sprintf("user.name=%s", user),
+						"-c", fmt.Sprintf("user.email=%s", email),
+						"commit",
+						"-m", "knit run 1 complete",
+						"--no-verify",
+						"--allow-empty",
+					},
+					Dir: repoPath,
+				},
+			}))
+		})
+
+		Context("when the commit fails", func() {
+			It("returns an error", func() {
+				runner.RunCall.Returns.Errors = []error{errors.New("meow")}
+				err := r.CommitMarker("knit run 1 complete")
+				Expect(err).To(MatchError("meow"))
+			})
+		})
+	})
+
+	Describe("SubmodulePaths", func() {
+		BeforeEach(func() {
+			err := ioutil.WriteFile(filepath.Join(repoPath, ".gitmodules"), []byte(`[submodule "src/github.com/org/one"]
+	path = src/github.com/org/one
+	url = https://example.com/one.git
+[submodule "src/github.com/org/two"]
+	path = src/github.com/org/two
+	url = https://example.com/two.git
+[submodule "src/github.com/other/three"]
+	path = src/github.com/other/three
+	url = https://example.com/three.git
+`), 0644)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("returns the recorded submodule paths matching the glob", func() {
+			paths, err := r.SubmodulePaths("src/github.com/org/*")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(paths).To(Equal([]string{"src/github.com/org/one", "src/github.com/org/two"}))
+		})
+
+		Context("when .gitmodules has CRLF line endings and mixed indentation", func() {
+			BeforeEach(func() {
+				contents := "[submodule \"src/github.com/org/one\"]\r\n" +
+					"\tpath = src/github.com/org/one\r\n" +
+					"\turl = https://example.com/one.git\r\n" +
+					"[submodule \"src/github.com/org/two\"]\r\n" +
+					"    path\t=\tsrc/github.com/org/two\r\n" +
+					"  \turl = https://example.com/two.git\r\n"
+
+				err := ioutil.WriteFile(filepath.Join(repoPath, ".gitmodules"), []byte(contents), 0644)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("still finds every submodule path", func() {
+				paths, err := r.SubmodulePaths("src/github.com/org/*")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(paths).To(Equal([]string{"src/github.com/org/one", "src/github.com/org/two"}))
+			})
+		})
+
+		Context("when the path keys are spaced differently", func() {
+			BeforeEach(func() {
+				err := ioutil.WriteFile(filepath.Join(repoPath, ".gitmodules"), []byte(`[submodule "one"]
+path=src/github.com/org/one
+[submodule "two"]
+path =src/github.com/org/two
+[submodule "three"]
+  path   =   src/github.com/org/three
+`), 0644)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("finds every submodule path", func() {
+				paths, err := r.SubmodulePaths("src/github.com/org/*")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(paths).To(Equal([]string{"src/github.com/org/one", "src/github.com/org/two", "src/github.com/org/three"}))
+			})
+		})
+
+		Context("when a submodule has multiple path entries", func() {
+			BeforeEach(func() {
+				err := ioutil.WriteFile(filepath.Join(repoPath, ".gitmodules"), []byte(`[submodule "one"]
+	path = src/github.com/org/old
+	path = src/github.com/org/one
+`), 0644)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("uses the last one, as git does", func() {
+				paths, err := r.SubmodulePaths("src/github.com/org/*")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(paths).To(Equal([]string{"src/github.com/org/one"}))
+			})
+		})
+
+		Context("when the glob is malformed", func() {
+			It("returns an error", func() {
+				_, err := r.SubmodulePaths("src/[")
+				Expect(err).To(MatchError("syntax error in pattern"))
+			})
+		})
+	})
+
+	Describe("WithHooks", func() {
+		var calls []string
+
+		BeforeEach(func() {
+			calls = nil
+			runner.RunCall.Stub = func(command patcher.Command) error {
+				calls = append(calls, command.Args[0])
+				if command.Args[0] == "rev-parse" {
+					return errors.New("no such branch")
+				}
+				return nil
+			}
+		})
+
+		recordingHook := func(name string, err error) patcher.Hook {
+			return func(operation, path string) error {
+				calls = append(calls, fmt.Sprintf("%s %s %s", name, operation, path))
+				return err
+			}
+		}
+
+		It("runs the hooks around each mutating operation", func() {
+			r = r.WithHooks(recordingHook("pre", nil), recordingHook("post", nil))
+
+			err := r.RemoveSubmodule("src/some/path")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(calls).To(Equal([]string{
+				"pre RemoveSubmodule src/some/path",
+				"submodule",
+				"rm",
+				"-c",
+				"post RemoveSubmodule src/some/path",
+			}))
+		})
+
+		It("runs the hooks around every patch entry point", func() {
+			r = r.WithHooks(recordingHook("pre", errors.New("lint failed")), nil)
+
+			Expect(r.PatchSubmodule("src/some/path", "some.patch")).To(MatchError("lint failed"))
+			Expect(r.ApplyCached("some.patch")).To(MatchError("lint failed"))
+			Expect(r.ApplyBinaryPatch("some.patch")).To(MatchError("lint failed"))
+			_, err := r.ApplyPatchVerbose("some.patch")
+			Expect(err).To(MatchError("lint failed"))
+			_, err = r.ApplyPatchIsolated("some-ref", "some.patch")
+			Expect(err).To(MatchError("lint failed"))
+			_, err = r.ApplyPatchToTree("some-ref", "some.patch")
+			Expect(err).To(MatchError("lint failed"))
+			_, err = r.ApplyPatchBestEffort("some.patch", []patcher.ApplyStrategy{patcher.ApplyStrategyAm})
+			Expect(err).To(MatchError("lint failed"))
+
+			Expect(calls).To(Equal([]string{
+				"pre PatchSubmodule src/some/path",
+				"pre ApplyCached some.patch",
+				"pre ApplyBinaryPatch some.patch",
+				"pre ApplyPatchVerbose some.patch",
+				"pre ApplyPatchIsolated some.patch",
+				"pre ApplyPatchToTree some.patch",
+				"pre ApplyPatchBestEffort some.patch",
+			}))
+			Expect(runner.CombinedOutputCall.Receives.Commands).To(BeEmpty())
+		})
+
+		It("runs the hooks once around an EnsureSubmodule that retargets and bumps", func() {
+			err := ioutil.WriteFile(filepath.Join(repoPath, ".gitmodules"), []byte(`[submodule "src/module-one"]
+	path = src/module-one
+	url = https://example.com/module-one.git
+`), 0644)
+			Expect(err).NotTo(HaveOccurred())
+
+			runner.CombinedOutputCall.Returns.Errors = []error{nil, nil}
+			runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte("old-sha\n"), []byte("a-sha\n")}
+			r = r.WithHooks(recordingHook("pre", nil), recordingHook("post", nil))
+
+			err = r.EnsureSubmodule("src/module-one", "https://mirror.example.com/module-one.git", "a-sha", "")
+			Expect(err).NotTo(HaveOccurred())
+
+			var hooks []string
+			for _, call := range calls {
+				if strings.HasPrefix(call, "pre ") || strings.HasPrefix(call, "post ") {
+					hooks = append(hooks, call)
+				}
+			}
+			Expect(hooks).To(Equal([]string{
+				"pre EnsureSubmodule src/module-one",
+				"post EnsureSubmodule src/module-one",
+			}))
+			Expect(calls[0]).To(Equal("pre EnsureSubmodule src/module-one"))
+			Expect(calls[len(calls)-1]).To(Equal("post EnsureSubmodule src/module-one"))
+		})
+
+		Context("when the pre-hook fails", func() {
+			It("skips the operation and returns the error", func() {
+				r = r.WithHooks(recordingHook("pre", errors.New("lint failed")), recordingHook("post", nil))
+
+				err := r.ApplyPatch("some-dir/something.patch")
+				Expect(err).To(MatchError("lint failed"))
+				Expect(calls).To(Equal([]string{"pre ApplyPatch some-dir/something.patch"}))
+			})
+		})
+
+		Context("when the post-hook fails", func() {
+			It("returns the error after the operation ran", func() {
+				r = r.WithHooks(nil, recordingHook("post", errors.New("lint failed")))
+
+				err := r.CheckoutBranch("some-branch")
+				Expect(err).To(MatchError("lint failed"))
+				Expect(calls).To(Equal([]string{"rev-parse", "checkout", "post CheckoutBranch some-branch"}))
+			})
+		})
+	})
+
+	Describe("FetchTags", func() {
+		It("fetches the tags from the remote", func() {
+			err := r.FetchTags()
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(runner.RunCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{"fetch", "--tags"},
+					Dir:  repoPath,
+				},
+			}))
+		})
+
+		Context("when the fetch fails", func() {
+			It("returns an error", func() {
+				runner.RunCall.Returns.Errors = []error{errors.New("meow")}
+				err := r.FetchTags()
+				Expect(err).To(MatchError("meow"))
+			})
+		})
+	})
+
+	Describe("IsSubmoduleInitialized", func() {
+		BeforeEach(func() {
+			err := ioutil.WriteFile(filepath.Join(repoPath, "src", "module-one", ".git"), []byte("gitdir: ../../.git/modules/module-one"), 0644)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("returns true when git reports the submodule as initialized", func() {
+			runner.CombinedOutputCall.Returns.Errors = []error{nil}
+			runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte(" abc123 src/module-one (heads/master)\n")}
+
+			initialized, err := r.IsSubmoduleInitialized("src/module-one")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(initialized).To(BeTrue())
+
+			Expect(runner.CombinedOutputCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{"submodule", "status", "--", "src/module-one"},
+					Dir:  repoPath,
+				},
+			}))
+		})
+
+		It("returns false when git reports the submodule as uninitialized", func() {
+			runner.CombinedOutputCall.Returns.Errors = []error{nil}
+			runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte("-abc123 src/module-one\n")}
+
+			initialized, err := r.IsSubmoduleInitialized("src/module-one")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(initialized).To(BeFalse())
+		})
+
+		Context("when the submodule has no .git on disk", func() {
+			It("returns false without asking git", func() {
+				initialized, err := r.IsSubmoduleInitialized("src/module-two")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(initialized).To(BeFalse())
+				Expect(runner.CombinedOutputCall.Count).To(Equal(0))
+			})
+		})
+
+		Context("when the path is not a submodule", func() {
+			It("returns an error", func() {
+				_, err := r.IsSubmoduleInitialized("src/module-one")
+				Expect(err).To(MatchError(`"src/module-one" is not a submodule`))
+			})
+		})
+	})
+
+	Describe("SoftCheckout", func() {
+		It("moves the repository to the ref without cleaning or forcing", func() {
+			err := r.SoftCheckout("some-ref")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(runner.RunCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{"checkout", "some-ref"},
+					Dir:  repoPath,
+				},
+				patcher.Command{
+					Args: []string{"submodule", "init"},
+					Dir:  repoPath,
+				},
+				patcher.Command{
+					Args: []string{"submodule", "foreach", "--recursive", "git submodule sync"},
+					Dir:  repoPath,
+				},
+				patcher.Command{
+					Args: []string{"submodule", "update", "--init", "--recursive", "--jobs=4"},
+					Dir:  repoPath,
+				},
+			}))
+		})
+
+		Context("when git refuses to overwrite local changes", func() {
+			It("returns an error", func() {
+				runner.RunCall.Returns.Errors = []error{errors.New("meow")}
+				err := r.SoftCheckout("some-ref")
+				Expect(err).To(MatchError("meow"))
+				Expect(runner.RunCall.Count).To(Equal(1))
+			})
+		})
+	})
+
+	Describe("HashObject", func() {
+		It("hashes the content with git without writing it to the repository", func() {
+			runner.CombinedOutputCall.Returns.Errors = []error{nil}
+			runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte("abc123\n")}
+			content := strings.NewReader("some content")
+
+			sha, err := r.HashObject(content)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sha).To(Equal("abc123"))
+
+			Expect(runner.CombinedOutputCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args:  []string{"hash-object", "--stdin"},
+					Dir:   repoPath,
+					Stdin: content,
+				},
+			}))
+		})
+
+		Context("when hashing fails", func() {
+			It("returns an error", func() {
+				runner.CombinedOutputCall.Returns.Errors = []error{errors.New("meow")}
+				runner.CombinedOutputCall.Returns.Outputs = [][]byte{nil}
+
+				_, err := r.HashObject(strings.NewReader("some content"))
+				Expect(err).To(MatchError("meow"))
+			})
+		})
+	})
+
+	Describe("SubmoduleSync", func() {
+		BeforeEach(func() {
+			err := ioutil.WriteFile(filepath.Join(repoPath, ".gitmodules"), []byte(`[submodule "src/module-one"]
+	path = src/module-one
+	url = https://example.com/module-one.git
+`), 0644)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("syncs the submodule urls without updating their content", func() {
+			err := r.SubmoduleSync()
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(runner.RunCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{"submodule", "sync", "--recursive"},
+					Dir:  repoPath,
+				},
+			}))
+		})
+
+		Context("when the repository has no submodules", func() {
+			It("does nothing", func() {
+				err := os.Remove(filepath.Join(repoPath, ".gitmodules"))
+				Expect(err).NotTo(HaveOccurred())
+
+				err = r.SubmoduleSync()
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(runner.RunCall.Receives.Commands).To(BeEmpty())
+			})
+		})
+
+		Context("when the sync fails", func() {
+			It("returns an error", func() {
+				runner.RunCall.Returns.Errors = []error{errors.New("meow")}
+
+				err := r.SubmoduleSync()
+				Expect(err).To(MatchError("meow"))
+			})
+		})
+	})
+
+	Describe("WithDeferredCommits", func() {
+		BeforeEach(func() {
+			r = r.WithDeferredCommits()
+		})
+
+		It("stages patches in the index instead of committing them", func() {
+			err := r.ApplyPatch("some-patch.patch")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(runner.RunCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{"apply", "--index", "some-patch.patch"},
+					Dir:  repoPath,
+				},
+			}))
+		})
+
+		It("stages submodule removals without committing them", func() {
+			err := r.RemoveSubmodule("src/module-one")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(runner.RunCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{"submodule", "deinit", "-f", "src/module-one"},
+					Dir:  repoPath,
+				},
+				patcher.Command{
+					Args: []string{"rm", "-f", "src/module-one"},
+					Dir:  repoPath,
+				},
+			}))
+		})
+
+		It("still commits inside the outer submodule of a nested bump", func() {
+			err := r.BumpSubmodule("src/module-one/src/nested", "some-sha")
+			Expect(err).NotTo(HaveOccurred())
+
+			commands := runner.RunCall.Receives.Commands
+			Expect(commands).To(HaveLen(10))
+			Expect(commands[8].Args).To(ContainElement("commit"))
+			Expect(commands[8].Dir).To(Equal(filepath.Join(repoPath, "src", "module-one")))
+			Expect(commands[9]).To(Equal(patcher.Command{
+				Args: []string{"add", "-A", "src/module-one"},
+				Dir:  repoPath,
+			}))
+		})
+	})
+
+	Describe("CommitAll", func() {
+		It("commits all staged changes as a single commit", func() {
+			err := r.WithDeferredCommits().CommitAll("Knit run")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(runner.RunCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{"add", "-A", "."},
+					Dir:  repoPath,
+				},
+				patcher.Command{
+					Args: []string{
+						"-c", fmt.Sprintf("user.name=%s", user),
+						"-c", fmt.Sprintf("user.email=%s", email),
+						"commit",
+						"-m", "Knit run",
+						"--no-verify",
+					},
+					Dir: repoPath,
+				},
+			}))
+		})
+
+		Context("when the commit fails", func() {
+			It("returns an error", func() {
+				runner.RunCall.Returns.Errors = []error{nil, errors.New("meow")}
+
+				err := r.CommitAll("Knit run")
+				Expect(err).To(MatchError("meow"))
+			})
+		})
+	})
+
+	Describe("BumpSubmoduleWithOptions", func() {
+		BeforeEach(func() {
+			err := ioutil.WriteFile(filepath.Join(repoPath, "src", "module-one", ".git"), []byte("gitdir: ../../.git/modules/module-one"), 0644)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("checks that the submodule is clean before bumping it", func() {
+			err := r.BumpSubmoduleWithOptions("src/module-one", "a-sha", patcher.BumpSubmoduleOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(runner.CombinedOutputCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{"status", "--porcelain"},
+					Dir:  filepath.Join(repoPath, "src", "module-one"),
+				},
+			}))
+			Expect(runner.RunCall.Receives.Commands).NotTo(BeEmpty())
+		})
+
+		Context("when the submodule has uncommitted changes", func() {
+			BeforeEach(func() {
+				runner.CombinedOutputCall.Returns.Errors = []error{nil}
+				runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte(" M some-file.go\n")}
+			})
+
+			It("returns a DirtySubmoduleError without bumping", func() {
+				err := r.BumpSubmodule("src/module-one", "a-sha")
+				Expect(err).To(Equal(patcher.DirtySubmoduleError{
+					Path:    "src/module-one",
+					Changes: []string{" M some-file.go"},
+				}))
+				Expect(err).To(MatchError("Submodule \"src/module-one\" has uncommitted changes:\n M some-file.go"))
+
+				Expect(runner.RunCall.Receives.Commands).To(BeEmpty())
+			})
+
+			Context("when forced", func() {
+				It("bumps the submodule anyway", func() {
+					err := r.BumpSubmoduleWithOptions("src/module-one", "a-sha", patcher.BumpSubmoduleOptions{Force: true})
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(runner.CombinedOutputCall.Receives.Commands).To(BeEmpty())
+					Expect(runner.RunCall.Receives.Commands[1]).To(Equal(patcher.Command{
+						Args: []string{"checkout", "a-sha"},
+						Dir:  filepath.Join(repoPath, "src", "module-one"),
+					}))
+				})
+			})
+		})
+
+		Context("when the submodule is not checked out", func() {
+			It("skips the check", func() {
+				err := r.BumpSubmodule("src/module-two", "a-sha")
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(runner.CombinedOutputCall.Receives.Commands).To(BeEmpty())
+			})
+		})
+
+		Context("when checking the submodule status fails", func() {
+			It("returns an error", func() {
+				runner.CombinedOutputCall.Returns.Errors = []error{errors.New("meow")}
+				runner.CombinedOutputCall.Returns.Outputs = [][]byte{nil}
+
+				err := r.BumpSubmodule("src/module-one", "a-sha")
+				Expect(err).To(MatchError("meow"))
+			})
+		})
+	})
+
+	Describe("WithSwitch", func() {
+		Context("when git supports switch", func() {
+			BeforeEach(func() {
+				runner.CombinedOutputCall.Returns.Errors = []error{nil}
+				runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte("git version 2.39.2\n")}
+				r = r.WithSwitch()
+			})
+
+			It("detects the git version", func() {
+				Expect(runner.CombinedOutputCall.Receives.Commands).To(Equal([]patcher.Command{
+					patcher.Command{
+						Args: []string{"version"},
+						Dir:  repoPath,
+					},
+				}))
+			})
+
+			It("creates branches with git switch", func() {
+				runner.RunCall.Returns.Errors = []error{errors.New("no such branch")}
+
+				err := r.CheckoutBranch("some-branch")
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(runner.RunCall.Receives.Commands[1]).To(Equal(patcher.Command{
+					Args: []string{"switch", "-c", "some-branch"},
+					Dir:  repoPath,
+				}))
+			})
+
+			It("switches to existing branches with git switch", func() {
+				err := r.Checkout("some-branch")
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(runner.RunCall.Receives.Commands[:2]).To(Equal([]patcher.Command{
+					patcher.Command{
+						Args: []string{"rev-parse", "--verify", "--quiet", "refs/heads/some-branch"},
+						Dir:  repoPath,
+					},
+					patcher.Command{
+						Args: []string{"switch", "some-branch"},
+						Dir:  repoPath,
+					},
+				}))
+			})
+
+			It("still checks out other refs with git checkout", func() {
+				runner.RunCall.Returns.Errors = []error{errors.New("not a branch")}
+
+				err := r.Checkout("abc123")
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(runner.RunCall.Receives.Commands[1]).To(Equal(patcher.Command{
+					Args: []string{"checkout", "abc123"},
+					Dir:  repoPath,
+				}))
+			})
+		})
+
+		Context("when git is too old to support switch", func() {
+			It("falls back to git checkout", func() {
+				runner.CombinedOutputCall.Returns.Errors = []error{nil}
+				runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte("git version 2.17.1\n")}
+				runner.RunCall.Returns.Errors = []error{errors.New("no such branch")}
+
+				err := r.WithSwitch().CheckoutBranch("some-branch")
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(runner.RunCall.Receives.Commands[1]).To(Equal(patcher.Command{
+					Args: []string{"checkout", "-b", "some-branch"},
+					Dir:  repoPath,
+				}))
+			})
+		})
+	})
+
+	Describe("PatchSubmoduleFromReader", func() {
+		It("pipes the patch into git am in the submodule and commits the result", func() {
+			patch := strings.NewReader("some patch contents")
+
+			err := r.PatchSubmoduleFromReader("src/different/path", patch)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(runner.RunCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{
+						"-c", fmt.Sprintf("user.name=%s", user),
+						"-c", fmt.Sprintf("user.email=%s", email),
+						"am",
+					},
+					Dir:   filepath.Join(repoPath, "src", "different/path"),
+					Stdin: patch,
+				},
+				patcher.Command{
+					Args: []string{"add", "-A", "."},
+					Dir:  repoPath,
+				},
+				patcher.Command{
+					Args: []string{
+						"-c", fmt.Sprintf("user.name=%s", user),
+						"-c", fmt.Sprintf("user.email=%s", email),
+						"commit",
+						"-m", "Knit patch of src/different/path",
+						"--no-verify",
+					},
+					Dir: repoPath,
+				},
+			}))
+		})
+
+		Context("when the changes are in a nested submodule", func() {
+			It("adds and commits the underlying submodule", func() {
+				runner.CombinedOutputCall.Returns.Errors = []error{errors.New("some patch error")}
+				runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte(`fatal patchspec is in submodule 'src/some/crazy/submodule'`)}
+
+				err := r.PatchSubmoduleFromReader("src/different/path", strings.NewReader("some patch contents"))
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(runner.RunCall.Receives.Commands).To(HaveLen(5))
+				Expect(runner.RunCall.Receives.Commands[1]).To(Equal(patcher.Command{
+					Args: []string{"add", "-A", "."},
+					Dir:  filepath.Join(repoPath, "src/some/crazy/submodule"),
+				}))
+			})
+		})
+
+		Context("when git am fails", func() {
+			It("returns an error", func() {
+				runner.RunCall.Returns.Errors = []error{errors.New("meow")}
+
+				err := r.PatchSubmoduleFromReader("src/different/path", strings.NewReader("some patch contents"))
+				Expect(err).To(MatchError("meow"))
+				Expect(runner.RunCall.Count).To(Equal(1))
+			})
+		})
+	})
+
+	Describe("WithRunner", func() {
+		It("runs commands through the new runner", func() {
+			otherRunner := &fakes.CommandRunner{}
+
+			err := r.WithRunner(otherRunner).FetchTags()
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(runner.RunCall.Receives.Commands).To(BeEmpty())
+			Expect(otherRunner.RunCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{"fetch", "--tags"},
+					Dir:  repoPath,
+				},
+			}))
+		})
+
+		It("accepts runners that wrap the existing one", func() {
+			var ran []string
+			timed := patcher.NewTimedRunner(runner, func(command patcher.Command, _ time.Duration, _ error) {
+				ran = append(ran, command.Args[0])
+			})
+
+			err := r.WithRunner(timed).FetchTags()
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(ran).To(Equal([]string{"fetch"}))
+			Expect(runner.RunCall.Count).To(Equal(1))
+		})
+	})
+
+	Describe("DeinitAllSubmodules", func() {
+		BeforeEach(func() {
+			err := ioutil.WriteFile(filepath.Join(repoPath, ".gitmodules"), []byte(`[submodule "src/module-one"]
+	path = src/module-one
+	url = https://example.com/module-one.git
+`), 0644)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("deinits every submodule", func() {
+			err := r.DeinitAllSubmodules()
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(runner.RunCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{"submodule", "deinit", "--all", "--force"},
+					Dir:  repoPath,
+				},
+			}))
+		})
+
+		Context("when the repository has no submodules", func() {
+			It("does nothing", func() {
+				err := os.Remove(filepath.Join(repoPath, ".gitmodules"))
+				Expect(err).NotTo(HaveOccurred())
+
+				err = r.DeinitAllSubmodules()
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(runner.RunCall.Receives.Commands).To(BeEmpty())
+			})
+		})
+
+		Context("when the deinit fails", func() {
+			It("returns an error", func() {
+				runner.RunCall.Returns.Errors = []error{errors.New("meow")}
+
+				err := r.DeinitAllSubmodules()
+				Expect(err).To(MatchError("meow"))
+			})
+		})
+	})
+
+	Describe("WithMessagePrefix", func() {
+		It("replaces Knit in the commit messages", func() {
+			prefixed, err := r.WithMessagePrefix("Applied")
+			Expect(err).NotTo(HaveOccurred())
+
+			err = prefixed.RemoveSubmodule("src/module-one")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(runner.RunCall.Receives.Commands[2].Args).To(ContainElement("Applied removal of submodule 'src/module-one'"))
+		})
+
+		Context("when the prefix is empty", func() {
+			It("returns an error", func() {
+				_, err := r.WithMessagePrefix("  ")
+				Expect(err).To(MatchError("message prefix must not be empty"))
+			})
+		})
+
+		Context("when the prefix contains a newline", func() {
+			It("returns an error", func() {
+				_, err := r.WithMessagePrefix("Applied\nby bot")
+				Expect(err).To(MatchError(`message prefix "Applied\nby bot" must not contain newlines`))
+			})
+		})
+	})
+
+	Describe("EnsureSubmodule", func() {
+		BeforeEach(func() {
+			err := ioutil.WriteFile(filepath.Join(repoPath, ".gitmodules"), []byte(`[submodule "src/module-one"]
+	path = src/module-one
+	url = https://example.com/module-one.git
+`), 0644)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		Context("when the submodule is missing", func() {
+			It("adds it", func() {
+				err := r.EnsureSubmodule("src/module-two", "https://example.com/module-two.git", "a-sha", "main")
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(runner.RunCall.Receives.Commands[0]).To(Equal(patcher.Command{
+					Args: []string{"submodule", "add", "--force", "-b", "main", "https://example.com/module-two.git", "src/module-two"},
+					Dir:  repoPath,
+				}))
+				Expect(runner.CombinedOutputCall.Receives.Commands).To(BeEmpty())
+			})
+		})
+
+		Context("when the submodule is already at the url and sha", func() {
+			It("does nothing", func() {
+				runner.CombinedOutputCall.Returns.Errors = []error{nil, nil}
+				runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte("a-sha-that-is-long\n"), []byte("a-sha-that-is-long\n")}
+
+				err := r.EnsureSubmodule("src/module-one", "https://example.com/module-one.git", "a-sha", "")
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(runner.CombinedOutputCall.Receives.Commands).To(Equal([]patcher.Command{
+					patcher.Command{
+						Args: []string{"rev-parse", "HEAD:src/module-one"},
+						Dir:  repoPath,
+					},
+					patcher.Command{
+						Args: []string{"rev-parse", "--verify", "-q", "a-sha^{commit}"},
+						Dir:  filepath.Join(repoPath, "src", "module-one"),
+					},
+				}))
+				Expect(runner.RunCall.Receives.Commands).To(BeEmpty())
+			})
+		})
+
+		Context("when the sha names a commit the submodule cannot resolve", func() {
+			It("bumps it", func() {
+				runner.CombinedOutputCall.Returns.Errors = []error{nil, errors.New("exit status 1")}
+				runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte("a-sha-that-is-long\n"), nil}
+
+				err := r.EnsureSubmodule("src/module-one", "https://example.com/module-one.git", "v1.2.3", "")
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(runner.RunCall.Receives.Commands[1]).To(Equal(patcher.Command{
+					Args: []string{"checkout", "v1.2.3"},
+					Dir:  filepath.Join(repoPath, "src", "module-one"),
+				}))
+			})
+		})
+
+		Context("when no sha is given", func() {
+			It("returns an error without touching the submodule", func() {
+				err := r.EnsureSubmodule("src/module-one", "https://example.com/module-one.git", "", "")
+				Expect(err).To(MatchError(`Cannot ensure submodule "src/module-one" without a commit to check out`))
+
+				Expect(runner.CombinedOutputCall.Receives.Commands).To(BeEmpty())
+				Expect(runner.RunCall.Receives.Commands).To(BeEmpty())
+			})
+		})
+
+		Context("when the submodule is at another sha", func() {
+			It("bumps it", func() {
+				runner.CombinedOutputCall.Returns.Errors = []error{nil, nil}
+				runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte("old-sha\n"), []byte("a-sha-that-is-long\n")}
+
+				err := r.EnsureSubmodule("src/module-one", "https://example.com/module-one.git", "a-sha", "")
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(runner.RunCall.Receives.Commands[1]).To(Equal(patcher.Command{
+					Args: []string{"checkout", "a-sha"},
+					Dir:  filepath.Join(repoPath, "src", "module-one"),
+				}))
+			})
+		})
+
+		Context("when the submodule points at another url", func() {
+			It("retargets it", func() {
+				runner.CombinedOutputCall.Returns.Errors = []error{nil, nil}
+				runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte("a-sha\n"), []byte("a-sha\n")}
+
+				err := r.EnsureSubmodule("src/module-one", "https://mirror.example.com/module-one.git", "a-sha", "")
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(runner.RunCall.Receives.Commands).To(Equal([]patcher.Command{
+					patcher.Command{
+						Args: []string{"submodule", "set-url", "--", "src/module-one", "https://mirror.example.com/module-one.git"},
+						Dir:  repoPath,
+					},
+					patcher.Command{
+						Args: []string{"submodule", "sync", "--", "src/module-one"},
+						Dir:  repoPath,
+					},
+					patcher.Command{
+						Args: []string{"add", ".gitmodules"},
+						Dir:  repoPath,
+					},
+					patcher.Command{
+						Args: []string{
+							"-c", fmt.Sprintf("user.name=%s", user),
+							"-c", fmt.Sprintf("user.email=%s", email),
+							"commit",
+							"-m", "Knit retarget of src/module-one to https://mirror.example.com/module-one.git",
+							"--no-verify",
+						},
+						Dir: repoPath,
+					},
+				}))
+			})
+		})
+
+		Context("when the recorded sha cannot be read", func() {
+			It("returns an error", func() {
+				runner.CombinedOutputCall.Returns.Errors = []error{errors.New("meow")}
+				runner.CombinedOutputCall.Returns.Outputs = [][]byte{nil}
+
+				err := r.EnsureSubmodule("src/module-one", "https://example.com/module-one.git", "a-sha", "")
+				Expect(err).To(MatchError("meow"))
+			})
+		})
+	})
+
+	Describe("StagePaths", func() {
+		It("stages the given paths", func() {
+			err := r.StagePaths("some-file.txt", "src/module-one")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(runner.RunCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{"add", "--", "some-file.txt", "src/module-one"},
+					Dir:  repoPath,
+				},
+			}))
+		})
+
+		Context("when no paths are given", func() {
+			It("does nothing", func() {
+				err := r.StagePaths()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(runner.RunCall.Receives.Commands).To(BeEmpty())
+			})
+		})
+
+		Context("when staging fails", func() {
+			It("returns an error", func() {
+				runner.RunCall.Returns.Errors = []error{errors.New("meow")}
+
+				err := r.StagePaths("some-file.txt")
+				Expect(err).To(MatchError("meow"))
+			})
+		})
+	})
+
+	Describe("CommitStaged", func() {
+		It("commits the index with the configured identity", func() {
+			err := r.WithAuthor("Some Author", "author@example.com").CommitStaged("Custom change")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(runner.RunCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{
+						"-c", fmt.Sprintf("user.name=%s", user),
+						"-c", fmt.Sprintf("user.email=%s", email),
+						"commit",
+						"-m", "Custom change",
+						"--no-verify",
+						"--author=Some Author <author@example.com>",
+					},
+					Dir: repoPath,
+				},
+			}))
+		})
+
+		Context("when the commit fails", func() {
+			It("returns an error", func() {
+				runner.RunCall.Returns.Errors = []error{errors.New("meow")}
+
+				err := r.CommitStaged("Custom change")
+				Expect(err).To(MatchError("meow"))
+			})
+		})
+	})
+
+	Describe("CheckoutPaths", func() {
+		It("restores the paths from the ref", func() {
+			err := r.CheckoutPaths("base-ref", "some-file.txt", "other/file.txt")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(runner.CombinedOutputCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{"checkout", "base-ref", "--", "some-file.txt", "other/file.txt"},
+					Dir:  repoPath,
+				},
+			}))
+		})
+
+		Context("when a path does not exist in the ref", func() {
+			It("returns an error naming the path", func() {
+				runner.CombinedOutputCall.Returns.Errors = []error{errors.New("exit status 1")}
+				runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte("error: pathspec 'missing.txt' did not match any file(s) known to git\n")}
+
+				err := r.CheckoutPaths("base-ref", "missing.txt")
+				Expect(err).To(MatchError(`Path "missing.txt" does not exist in base-ref`))
+			})
+		})
+
+		Context("when the checkout fails for another reason", func() {
+			It("returns the error", func() {
+				runner.CombinedOutputCall.Returns.Errors = []error{errors.New("meow")}
+				runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte("fatal: invalid reference: base-ref\n")}
+
+				err := r.CheckoutPaths("base-ref", "some-file.txt")
+				Expect(err).To(MatchError("meow"))
+			})
+		})
+	})
+
+	Describe("RecordBaseVersion", func() {
+		It("writes the version to .knit-base and commits it", func() {
+			err := r.RecordBaseVersion("1.9.2")
+			Expect(err).NotTo(HaveOccurred())
+
+			contents, err := ioutil.ReadFile(filepath.Join(repoPath, ".knit-base"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(contents)).To(Equal("1.9.2\n"))
+
+			Expect(runner.RunCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{"add", ".knit-base"},
+					Dir:  repoPath,
+				},
+				patcher.Command{
+					Args: []string{
+						"-c", fmt.Sprintf("user.name=%s", user),
+						"-c", fmt.Sprintf("user.email=%s", email),
+						"commit",
+						"-m", "Knit base version 1.9.2",
+						"--no-verify",
+					},
+					Dir: repoPath,
+				},
+			}))
+		})
+
+		It("can be read back with BaseVersion", func() {
+			Expect(r.RecordBaseVersion("1.9.2")).To(Succeed())
+
+			version, err := r.BaseVersion()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(version).To(Equal("1.9.2"))
+		})
+
+		Context("when the version is invalid", func() {
+			It("returns an error", func() {
+				err := r.RecordBaseVersion("1.9\n2")
+				Expect(err).To(MatchError(`Invalid base version "1.9\n2"`))
+				Expect(runner.RunCall.Receives.Commands).To(BeEmpty())
+			})
+		})
+
+		Context("when the commit fails", func() {
+			It("returns an error", func() {
+				runner.RunCall.Returns.Errors = []error{nil, errors.New("meow")}
+
+				err := r.RecordBaseVersion("1.9.2")
+				Expect(err).To(MatchError("meow"))
+			})
+		})
+	})
+
+	Describe("BaseVersion", func() {
+		Context("when no base version has been recorded", func() {
+			It("returns an error", func() {
+				_, err := r.BaseVersion()
+				Expect(err).To(MatchError(fmt.Sprintf("Repository %q has no recorded base version", repoPath)))
+			})
+		})
+	})
+
+	Describe("AbsorbGitDirs", func() {
+		BeforeEach(func() {
+			err := ioutil.WriteFile(filepath.Join(repoPath, ".gitmodules"), []byte(`[submodule "src/module-one"]
+	path = src/module-one
+	url = https://example.com/module-one.git
+`), 0644)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("absorbs the submodule git directories into the superproject", func() {
+			err := r.AbsorbGitDirs()
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(runner.RunCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{"submodule", "absorbgitdirs"},
+					Dir:  repoPath,
+				},
+			}))
+		})
+
+		Context("when the repository has no submodules", func() {
+			It("does nothing", func() {
+				err := os.Remove(filepath.Join(repoPath, ".gitmodules"))
+				Expect(err).NotTo(HaveOccurred())
+
+				err = r.AbsorbGitDirs()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(runner.RunCall.Receives.Commands).To(BeEmpty())
+			})
+		})
+
+		Context("when absorbing fails", func() {
+			It("returns an error", func() {
+				runner.RunCall.Returns.Errors = []error{errors.New("meow")}
+
+				err := r.AbsorbGitDirs()
+				Expect(err).To(MatchError("meow"))
+			})
+		})
+	})
+
+	Describe("PatchSubmoduleWithOptions", func() {
+		Context("when skipping the superproject commit", func() {
+			var options patcher.PatchSubmoduleOptions
+
+			BeforeEach(func() {
+				options = patcher.PatchSubmoduleOptions{SkipSuperprojectCommit: true}
+			})
+
+			It("applies the patch and stages the gitlink without committing it", func() {
+				err := r.PatchSubmoduleWithOptions("src/different/path", "/full/submodule/some.patch", options)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(runner.CombinedOutputCall.Receives.Commands).To(Equal([]patcher.Command{
+					patcher.Command{
+						Args: []string{"add", "-A", "src/different/path"},
+						Dir:  repoPath,
+					},
+				}))
+				Expect(runner.RunCall.Receives.Commands).To(Equal([]patcher.Command{
+					patcher.Command{
+						Args: []string{
+							"-c", fmt.Sprintf("user.name=%s", user),
+							"-c", fmt.Sprintf("user.email=%s", email),
+							"am",
+							"/full/submodule/some.patch",
+						},
+						Dir: filepath.Join(repoPath, "src", "different/path"),
+					},
+				}))
+			})
+
+			It("still commits the changes inside a nested submodule", func() {
+				runner.CombinedOutputCall.Returns.Errors = []error{errors.New("some patch error")}
+				runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte(`fatal patchspec is in submodule 'src/some/crazy/submodule'`)}
+
+				err := r.PatchSubmoduleWithOptions("src/different/path", "/full/submodule/some.patch", options)
+				Expect(err).NotTo(HaveOccurred())
+
+				commands := runner.RunCall.Receives.Commands
+				Expect(commands).To(HaveLen(3))
+				Expect(commands[2].Args).To(ContainElement("Knit submodule patch of src/some/crazy/submodule"))
+				Expect(commands[2].Dir).To(Equal(filepath.Join(repoPath, "src/some/crazy/submodule")))
+			})
+		})
+	})
+
+	Describe("CheckoutDetached", func() {
+		It("detaches HEAD at the sha without the detached HEAD advice", func() {
+			err := r.CheckoutDetached("abc123")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(runner.RunCall.Receives.Commands[0]).To(Equal(patcher.Command{
+				Args: []string{"-c", "advice.detachedHead=false", "checkout", "--detach", "abc123"},
+				Dir:  repoPath,
+			}))
+			Expect(runner.RunCall.Receives.Commands[1:]).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args:   []string{"config", "--name-only", "--get-regexp", `^submodule\..*\.url$`},
+					Dir:    repoPath,
+					Stdout: runner.RunCall.Receives.Commands[1].Stdout,
+				},
+				patcher.Command{
+					Args: []string{"clean", "-ffd"},
+					Dir:  repoPath,
+				},
+				patcher.Command{
+					Args: []string{"submodule", "init"},
+					Dir:  repoPath,
+				},
+				patcher.Command{
+					Args: []string{"submodule", "foreach", "--recursive", "git submodule sync"},
+					Dir:  repoPath,
+				},
+				patcher.Command{
+					Args: []string{"submodule", "update", "--init", "--recursive", "--force", "--jobs=4"},
+					Dir:  repoPath,
+				},
+				patcher.Command{
+					Args: []string{"submodule", "foreach", "--recursive", "git clean -ffd"},
+					Dir:  repoPath,
+				},
+			}))
+		})
+
+		Context("when the checkout fails", func() {
+			It("returns an error", func() {
+				runner.RunCall.Returns.Errors = []error{errors.New("some error")}
+
+				err := r.CheckoutDetached("abc123")
+				Expect(err).To(MatchError("some error"))
+				Expect(runner.RunCall.Receives.Commands).To(HaveLen(1))
+			})
+		})
+	})
+
+	Describe("SetSubmoduleBranch", func() {
+		BeforeEach(func() {
+			err := ioutil.WriteFile(filepath.Join(repoPath, ".gitmodules"), []byte(`[submodule "one"]
+	path = src/module-one
+	url = https://example.com/module-one.git
+	branch = master
+`), 0644)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("updates the tracked branch by submodule name and commits it", func() {
+			err := r.SetSubmoduleBranch("src/module-one", "release-1.2")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(runner.RunCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{"config", "-f", ".gitmodules", "submodule.one.branch", "release-1.2"},
+					Dir:  repoPath,
+				},
+				patcher.Command{
+					Args: []string{"submodule", "sync", "--", "src/module-one"},
+					Dir:  repoPath,
+				},
+				patcher.Command{
+					Args: []string{"add", ".gitmodules"},
+					Dir:  repoPath,
+				},
+				patcher.Command{
+					Args: []string{
+						"-c", fmt.Sprintf("user.name=%s", user),
+						"-c", fmt.Sprintf("user.email=%s", email),
+						"commit", "-m", "Knit tracked branch of 'src/module-one' set to release-1.2", "--no-verify",
+					},
+					Dir: repoPath,
+				},
+			}))
+		})
+
+		Context("when the path is not a submodule", func() {
+			It("returns an error", func() {
+				err := r.SetSubmoduleBranch("src/module-two", "release-1.2")
+				Expect(err).To(MatchError(`"src/module-two" is not a submodule`))
+				Expect(runner.RunCall.Receives.Commands).To(BeEmpty())
+			})
+		})
+	})
+
+	Describe("WithRenormalize", func() {
+		BeforeEach(func() {
+			r = r.WithRenormalize()
+		})
+
+		It("renormalizes line endings before committing", func() {
+			err := r.RemoveSubmodule("src/module-one")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(runner.RunCall.Receives.Commands[2:]).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{"add", "--renormalize", "."},
+					Dir:  repoPath,
+				},
+				patcher.Command{
+					Args: []string{
+						"-c", fmt.Sprintf("user.name=%s", user),
+						"-c", fmt.Sprintf("user.email=%s", email),
+						"commit", "-m", "Knit removal of submodule 'src/module-one'", "--no-verify",
+					},
+					Dir: repoPath,
+				},
+			}))
+		})
+
+		It("renormalizes the deferred changes committed by CommitAll", func() {
+			err := r.WithDeferredCommits().CommitAll("Knit run")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(runner.RunCall.Receives.Commands[1]).To(Equal(patcher.Command{
+				Args: []string{"add", "--renormalize", "."},
+				Dir:  repoPath,
+			}))
+		})
+	})
+
+	Describe("ApplyCached", func() {
+		It("applies the patch to the index only", func() {
+			err := r.ApplyCached("some-dir/something.patch")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(runner.CombinedOutputCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{"apply", "--cached", "some-dir/something.patch"},
+					Dir:  repoPath,
+				},
+			}))
+			Expect(runner.RunCall.Receives.Commands).To(BeEmpty())
+		})
+
+		Context("when the patch does not apply to the index", func() {
+			It("returns an error with git's explanation", func() {
+				runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte("error: some-file: does not exist in index\n")}
+				runner.CombinedOutputCall.Returns.Errors = []error{errors.New("exit status 1")}
+
+				err := r.ApplyCached("some-dir/something.patch")
+				Expect(err).To(MatchError("Patch \"some-dir/something.patch\" does not apply to the index: exit status 1\nerror: some-file: does not exist in index"))
+			})
+		})
+	})
+
+	Describe("GetSubmoduleConfig", func() {
+		It("returns every section of .gitmodules with its settings", func() {
+			err := ioutil.WriteFile(filepath.Join(repoPath, ".gitmodules"), []byte(`[submodule "one"]
+	path = src/module-one
+	url = https://example.com/module-one.git
+	branch = master
+	update = rebase
+	ignore = dirty
+[submodule "src/not-checked-out"]
+	path = src/not-checked-out
+	url = https://example.com/not-checked-out.git
+`), 0644)
+			Expect(err).NotTo(HaveOccurred())
+
+			configs, err := r.GetSubmoduleConfig()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(configs).To(Equal([]patcher.SubmoduleConfig{
+				{
+					Name:   "one",
+					Path:   "src/module-one",
+					URL:    "https://example.com/module-one.git",
+					Branch: "master",
+					Update: "rebase",
+					Ignore: "dirty",
+				},
+				{
+					Name: "src/not-checked-out",
+					Path: "src/not-checked-out",
+					URL:  "https://example.com/not-checked-out.git",
+				},
+			}))
+		})
+
+		Context("when there is no .gitmodules", func() {
+			It("returns no submodules", func() {
+				configs, err := r.GetSubmoduleConfig()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(configs).To(BeEmpty())
+			})
+		})
+	})
+
+	Describe("UpdateSubmodules", func() {
+		BeforeEach(func() {
+			err := ioutil.WriteFile(filepath.Join(repoPath, ".gitmodules"), []byte(`[submodule "src/module-one"]
+	path = src/module-one
+	url = https://example.com/module-one.git
+[submodule "two"]
+	path = src/module-two
+	url = https://example.com/module-two.git
+`), 0644)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("updates only the named submodules", func() {
+			err := r.UpdateSubmodules("src/module-one", "src/module-two")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(runner.RunCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{"submodule", "update", "--init", "--recursive", "--force", "--jobs=4", "--", "src/module-one", "src/module-two"},
+					Dir:  repoPath,
+				},
+			}))
+		})
+
+		It("does nothing when no paths are given", func() {
+			err := r.UpdateSubmodules()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(runner.RunCall.Receives.Commands).To(BeEmpty())
+		})
+
+		Context("when a path is not in .gitmodules", func() {
+			It("returns an error without updating anything", func() {
+				err := r.UpdateSubmodules("src/module-one", "src/modul-two")
+				Expect(err).To(MatchError(`"src/modul-two" is not a submodule`))
+				Expect(runner.RunCall.Receives.Commands).To(BeEmpty())
+			})
+		})
+	})
+
+	Describe("CommitTree", func() {
+		BeforeEach(func() {
+			runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte("0123456789abcdef0123456789abcdef01234567\n")}
+			runner.CombinedOutputCall.Returns.Errors = []error{nil}
+		})
+
+		It("commits the tree on top of the parent with the committer identity", func() {
+			sha, err := r.CommitTree("some-tree", "some-parent", "Knit graft")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sha).To(Equal("0123456789abcdef0123456789abcdef01234567"))
+
+			Expect(runner.CombinedOutputCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{
+						"-c", fmt.Sprintf("user.name=%s", user),
+						"-c", fmt.Sprintf("user.email=%s", email),
+						"commit-tree", "some-tree", "-p", "some-parent", "-m", "Knit graft",
+					},
+					Dir: repoPath,
+				},
+			}))
+			Expect(runner.RunCall.Receives.Commands).To(BeEmpty())
+		})
+
+		It("creates a root commit when there is no parent", func() {
+			_, err := r.CommitTree("some-tree", "", "Knit graft")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(runner.CombinedOutputCall.Receives.Commands[0].Args[4:]).To(Equal([]string{"commit-tree", "some-tree", "-m", "Knit graft"}))
+		})
+
+		It("uses the configured author", func() {
+			_, err := r.WithAuthor("Some Author", "author@example.com").CommitTree("some-tree", "some-parent", "Knit graft")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(runner.CombinedOutputCall.Receives.Commands[0].Env).To(Equal([]string{
+				"GIT_AUTHOR_NAME=Some Author",
+				"GIT_AUTHOR_EMAIL=author@example.com",
+			}))
+		})
+
+		Context("when git commit-tree fails", func() {
+			It("returns an error", func() {
+				runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte("fatal: not a valid object name some-tree\n")}
+				runner.CombinedOutputCall.Returns.Errors = []error{errors.New("exit status 128")}
+
+				_, err := r.CommitTree("some-tree", "some-parent", "Knit graft")
+				Expect(err).To(MatchError("Could not commit tree \"some-tree\": exit status 128\nfatal: not a valid object name some-tree"))
+			})
+		})
+	})
+
+	Describe("BumpSubmoduleWithOptions with CommitRange", func() {
+		BeforeEach(func() {
+			runner.CombinedOutputCall.Returns.Outputs = [][]byte{
+				[]byte("old-sha\n"),
+				[]byte("ccc333 Third change\nbbb222 Second change\naaa111 First change\n"),
+			}
+			runner.CombinedOutputCall.Returns.Errors = []error{nil, nil}
+		})
+
+		It("lists the commits pulled in by the bump in the message body", func() {
+			err := r.BumpSubmoduleWithOptions("src/module-one", "new-sha", patcher.BumpSubmoduleOptions{CommitRange: 2})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(runner.CombinedOutputCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{"rev-parse", "HEAD:src/module-one"},
+					Dir:  repoPath,
+				},
+				patcher.Command{
+					Args: []string{"log", "--oneline", "old-sha..new-sha"},
+					Dir:  filepath.Join(repoPath, "src/module-one"),
+				},
+			}))
+
+			commit := runner.RunCall.Receives.Commands[len(runner.RunCall.Receives.Commands)-1]
+			Expect(commit.Args).To(ContainElement("Knit bump of src/module-one\n\nccc333 Third change\nbbb222 Second change\nand 1 more"))
+		})
+
+		It("leaves the message alone when the range is empty", func() {
+			runner.CombinedOutputCall.Returns.Outputs[1] = []byte("")
+
+			err := r.BumpSubmoduleWithOptions("src/module-one", "new-sha", patcher.BumpSubmoduleOptions{CommitRange: 2})
+			Expect(err).NotTo(HaveOccurred())
+
+			commit := runner.RunCall.Receives.Commands[len(runner.RunCall.Receives.Commands)-1]
+			Expect(commit.Args).To(ContainElement("Knit bump of src/module-one"))
+		})
+
+		Context("when the current gitlink cannot be read", func() {
+			It("returns an error before checking anything out", func() {
+				runner.CombinedOutputCall.Returns.Errors = []error{errors.New("exit status 128"), nil}
+
+				err := r.BumpSubmoduleWithOptions("src/module-one", "new-sha", patcher.BumpSubmoduleOptions{CommitRange: 2})
+				Expect(err).To(MatchError(`Could not find the current commit of submodule "src/module-one": exit status 128`))
+				Expect(runner.RunCall.Receives.Commands).To(HaveLen(1))
+			})
+		})
+	})
+
+	Describe("ApplyPatchAmend", func() {
+		It("applies the patch and amends it into HEAD", func() {
+			err := r.ApplyPatchAmend("some-dir/something.patch")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(runner.RunCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{"rev-parse", "--verify", "--quiet", "HEAD"},
+					Dir:  repoPath,
+				},
+				patcher.Command{
+					Args: []string{"apply", "--index", "some-dir/something.patch"},
+					Dir:  repoPath,
+				},
+				patcher.Command{
+					Args: []string{
+						"-c", fmt.Sprintf("user.name=%s", user),
+						"-c", fmt.Sprintf("user.email=%s", email),
+						"commit", "--amend", "--no-edit", "--no-verify",
+					},
+					Dir: repoPath,
+				},
+			}))
+		})
+
+		Context("when there is no commit to amend", func() {
+			It("returns an error without applying the patch", func() {
+				runner.RunCall.Returns.Errors = []error{errors.New("exit status 1")}
+
+				err := r.ApplyPatchAmend("some-dir/something.patch")
+				Expect(err).To(MatchError(`Cannot amend patch "some-dir/something.patch" into HEAD: the repository has no commits`))
+				Expect(runner.RunCall.Receives.Commands).To(HaveLen(1))
+			})
+		})
+
+		Context("when the patch does not apply", func() {
+			It("returns an error without amending", func() {
+				runner.RunCall.Returns.Errors = []error{nil, errors.New("patch does not apply")}
+
+				err := r.ApplyPatchAmend("some-dir/something.patch")
+				Expect(err).To(MatchError("patch does not apply"))
+				Expect(runner.RunCall.Receives.Commands).To(HaveLen(2))
+			})
+		})
+	})
+
+	Describe("ListSubmodulesRecursive", func() {
+		BeforeEach(func() {
+			err := ioutil.WriteFile(filepath.Join(repoPath, ".gitmodules"), []byte(`[submodule "one"]
+	path = src/module-one
+	url = https://example.com/module-one.git
+[submodule "missing"]
+	path = src/missing
+	url = https://example.com/missing.git
+`), 0644)
+			Expect(err).NotTo(HaveOccurred())
+
+			err = os.MkdirAll(filepath.Join(repoPath, "src/module-one/src/nested"), 0755)
+			Expect(err).NotTo(HaveOccurred())
+
+			err = ioutil.WriteFile(filepath.Join(repoPath, "src/module-one/.gitmodules"), []byte(`[submodule "nested"]
+	path = src/nested
+	url = https://example.com/nested.git
+	branch = develop
+`), 0644)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("includes nested submodules with paths relative to the superproject", func() {
+			submodules, err := r.ListSubmodulesRecursive()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(submodules).To(Equal([]patcher.SubmoduleConfig{
+				{Name: "one", Path: "src/module-one", URL: "https://example.com/module-one.git"},
+				{Name: "nested", Path: "src/module-one/src/nested", URL: "https://example.com/nested.git", Branch: "develop"},
+				{Name: "missing", Path: "src/missing", URL: "https://example.com/missing.git"},
+			}))
+		})
+
+		Context("when a submodule links back to an enclosing repository", func() {
+			It("does not walk into it again", func() {
+				err := os.Symlink(filepath.Join(repoPath, "src/module-one"), filepath.Join(repoPath, "src/module-one/src/nested/loop"))
+				Expect(err).NotTo(HaveOccurred())
+
+				err = ioutil.WriteFile(filepath.Join(repoPath, "src/module-one/src/nested/.gitmodules"), []byte(`[submodule "loop"]
+	path = loop
+	url = https://example.com/module-one.git
+`), 0644)
+				Expect(err).NotTo(HaveOccurred())
+
+				submodules, err := r.ListSubmodulesRecursive()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(submodules).To(HaveLen(4))
+				Expect(submodules[2].Path).To(Equal("src/module-one/src/nested/loop"))
+			})
+		})
+	})
+
+	Describe("SetSubmoduleShallow", func() {
+		BeforeEach(func() {
+			err := ioutil.WriteFile(filepath.Join(repoPath, ".gitmodules"), []byte(`[submodule "one"]
+	path = src/module-one
+	url = https://example.com/module-one.git
+`), 0644)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("records the shallow depth in .gitmodules and commits it", func() {
+			err := r.SetSubmoduleShallow("src/module-one", 10)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(runner.RunCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{"config", "-f", ".gitmodules", "submodule.one.shallow", "true"},
+					Dir:  repoPath,
+				},
+				patcher.Command{
+					Args: []string{"config", "-f", ".gitmodules", "submodule.one.depth", "10"},
+					Dir:  repoPath,
+				},
+				patcher.Command{
+					Args: []string{"add", ".gitmodules"},
+					Dir:  repoPath,
+				},
+				patcher.Command{
+					Args: []string{
+						"-c", fmt.Sprintf("user.name=%s", user),
+						"-c", fmt.Sprintf("user.email=%s", email),
+						"commit", "-m", "Knit shallow fetch of 'src/module-one' at depth 10", "--no-verify",
+					},
+					Dir: repoPath,
+				},
+			}))
+		})
+
+		Context("when the depth is not positive", func() {
+			It("returns an error", func() {
+				err := r.SetSubmoduleShallow("src/module-one", 0)
+				Expect(err).To(MatchError(`Invalid shallow depth 0 for submodule "src/module-one"`))
+				Expect(runner.RunCall.Receives.Commands).To(BeEmpty())
+			})
+		})
+
+		Context("when the submodule is shallow", func() {
+			BeforeEach(func() {
+				err := ioutil.WriteFile(filepath.Join(repoPath, ".gitmodules"), []byte(`[submodule "one"]
+	path = src/module-one
+	url = https://example.com/module-one.git
+	shallow = true
+	depth = 10
+[submodule "two"]
+	path = src/module-two
+	url = https://example.com/module-two.git
+	shallow = true
+`), 0644)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("is updated to its depth on checkout", func() {
+				err := r.Checkout("some-ref")
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(runner.RunCall.Receives.Commands[5:7]).To(Equal([]patcher.Command{
+					patcher.Command{
+						Args: []string{"submodule", "update", "--init", "--force", "--depth=10", "--", "src/module-one"},
+						Dir:  repoPath,
+					},
+					patcher.Command{
+						Args: []string{"submodule", "update", "--init", "--force", "--depth=1", "--", "src/module-two"},
+						Dir:  repoPath,
+					},
+				}))
+				Expect(runner.RunCall.Receives.Commands[7].Args).To(Equal([]string{"submodule", "update", "--init", "--recursive", "--force", "--jobs=4"}))
+			})
+
+			It("is fetched to its depth when bumped", func() {
+				sha := "0123456789abcdef0123456789abcdef01234567"
+				err := r.BumpSubmodule("src/module-one", sha)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(runner.RunCall.Receives.Commands[0]).To(Equal(patcher.Command{
+					Args: []string{"fetch", "--depth=10", "origin", sha},
+					Dir:  filepath.Join(repoPath, "src/module-one"),
+				}))
+			})
+
+			It("fetches a tag it is bumped to into the tag's ref", func() {
+				runner.RunCall.Stub = func(command patcher.Command) error {
+					if command.Args[0] == "ls-remote" {
+						fmt.Fprint(command.Stdout, "abc123\trefs/tags/release/v1.2\nbcd234\trefs/tags/v1.2\n")
+					}
+					return nil
+				}
+
+				err := r.BumpSubmodule("src/module-one", "v1.2")
+				Expect(err).NotTo(HaveOccurred())
+
+				commands := runner.RunCall.Receives.Commands
+				Expect(commands[0].Args).To(Equal([]string{"ls-remote", "origin", "v1.2"}))
+				Expect(commands[1]).To(Equal(patcher.Command{
+					Args: []string{"fetch", "--depth=10", "origin", "+refs/tags/v1.2:refs/tags/v1.2"},
+					Dir:  filepath.Join(repoPath, "src/module-one"),
+				}))
+				Expect(commands[2].Args).To(Equal([]string{"checkout", "v1.2"}))
+			})
+
+			It("fetches a branch it is bumped to into the remote-tracking ref", func() {
+				runner.RunCall.Stub = func(command patcher.Command) error {
+					if command.Args[0] == "ls-remote" {
+						fmt.Fprint(command.Stdout, "abc123\trefs/heads/main\n")
+					}
+					return nil
+				}
+
+				err := r.BumpSubmodule("src/module-one", "main")
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(runner.RunCall.Receives.Commands[1].Args).To(Equal([]string{"fetch", "--depth=10", "origin", "+refs/heads/main:refs/remotes/origin/main"}))
+			})
+		})
+	})
+
+	Describe("BumpSubmodule of a deeply nested submodule", func() {
+		BeforeEach(func() {
+			for _, dir := range []string{"src/outer", "src/outer/src/middle", "src/outer/src/middle/src/inner"} {
+				err := os.MkdirAll(filepath.Join(repoPath, dir), 0755)
+				Expect(err).NotTo(HaveOccurred())
+
+				err = ioutil.WriteFile(filepath.Join(repoPath, dir, ".git"), []byte("gitdir: somewhere"), 0644)
+				Expect(err).NotTo(HaveOccurred())
+			}
+		})
+
+		It("commits the new gitlink at every enclosing level", func() {
+			err := r.BumpSubmodule("src/outer/src/middle/src/inner", "some-sha")
+			Expect(err).NotTo(HaveOccurred())
+
+			commands := runner.RunCall.Receives.Commands
+			Expect(commands).To(HaveLen(13))
+			Expect(commands[7]).To(Equal(patcher.Command{
+				Args: []string{"add", "-A", "src/inner"},
+				Dir:  filepath.Join(repoPath, "src/outer/src/middle"),
+			}))
+			Expect(commands[8].Args).To(ContainElement("Knit bump of src/inner"))
+			Expect(commands[9]).To(Equal(patcher.Command{
+				Args: []string{"add", "-A", "src/middle"},
+				Dir:  filepath.Join(repoPath, "src/outer"),
+			}))
+			Expect(commands[10]).To(Equal(patcher.Command{
+				Args: []string{
+					"-c", fmt.Sprintf("user.name=%s", user),
+					"-c", fmt.Sprintf("user.email=%s", email),
+					"commit",
+					"-m", "Knit bump of src/outer/src/middle",
+					"--no-verify",
+				},
+				Dir: filepath.Join(repoPath, "src/outer"),
+			}))
+			Expect(commands[11]).To(Equal(patcher.Command{
+				Args: []string{"add", "-A", "src/outer"},
+				Dir:  repoPath,
+			}))
+		})
+	})
+})
+
+var _ = Describe("Checkout with a submodule the ref does not have", func() {
+	var (
+		superproject gitRepo
+		submodule    gitRepo
+	)
+
+	BeforeEach(func() {
+		submodule = newGitRepo()
+		submodule.commit("Initial")
+
+		superproject = newGitRepo()
+		superproject.writeFile("README.md", "# Some Project\n")
+		superproject.commit("Initial")
+		superproject.git("-c", "protocol.file.allow=always", "submodule", "add", "-q", submodule.path, "src/sub")
+		superproject.commit("Add a submodule")
+	})
+
+	AfterEach(func() {
+		superproject.remove()
+		submodule.remove()
+	})
+
+	It("deinitializes it and removes its checkout", func() {
+		err := superproject.repo().Checkout("HEAD~1")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(filepath.Join(superproject.path, "src", "sub")).NotTo(BeADirectory())
+		Expect(superproject.git("config", "--list")).NotTo(ContainSubstring("submodule.src/sub"))
+	})
+
+	It("cleans up one left behind by an earlier checkout", func() {
+		superproject.git("-c", "advice.detachedHead=false", "checkout", "-q", "HEAD~1")
+		Expect(filepath.Join(superproject.path, "src", "sub", ".git")).To(BeAnExistingFile())
+
+		err := superproject.repo().Checkout("master~1")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(filepath.Join(superproject.path, "src", "sub")).NotTo(BeADirectory())
+		Expect(superproject.git("config", "--list")).NotTo(ContainSubstring("submodule.src/sub"))
+	})
 })
@@ -0,0 +1,85 @@
+package patcher
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+type SignatureError struct {
+	Patch  string
+	Reason string
+}
+
+func (e SignatureError) Error() string {
+	return fmt.Sprintf("Patch %q failed signature verification: %s", e.Patch, e.Reason)
+}
+
+// WithSignatureVerification requires every applied patch to have a detached
+// signature beside it, e.g. some.patch.asc, made by a key in keyring. gpgRunner
+// runs gpg --verify and should be configured with the gpg executable.
+func (r Repo) WithSignatureVerification(gpgRunner Runner, keyring string) Repo {
+	r.gpgRunner = gpgRunner
+	r.keyring = keyring
+	return r
+}
+
+// verifyPatchSignature checks the patch named by ref, staged on disk at path,
+// against the signature published next to it: ref.asc through the patch
+// resolver when ref was resolved to path, or path.asc on disk otherwise.
+func (r Repo) verifyPatchSignature(ref, path string) error {
+	if r.gpgRunner == nil {
+		return nil
+	}
+
+	if err := r.requireLocal("Verifying a patch signature"); err != nil {
+		return err
+	}
+
+	signature := path + ".asc"
+	if r.patchResolver != nil && ref != path {
+		staged, err := r.stageSignature(ref + ".asc")
+		if err != nil {
+			return SignatureError{Patch: ref, Reason: fmt.Sprintf("no signature found at %s.asc: %s", ref, err)}
+		}
+		defer os.Remove(staged)
+
+		signature = staged
+	} else if _, err := os.Stat(signature); err != nil {
+		return SignatureError{Patch: ref, Reason: fmt.Sprintf("no signature found at %s", signature)}
+	}
+
+	output, err := r.gpgRunner.CombinedOutput(Command{
+		Args: []string{"--batch", "--no-default-keyring", "--keyring", r.keyring, "--verify", signature, path},
+		Dir:  r.repo,
+	})
+	if err != nil {
+		return SignatureError{Patch: ref, Reason: strings.TrimSpace(fmt.Sprintf("%s\n%s", err, output))}
+	}
+
+	return nil
+}
+
+func (r Repo) stageSignature(ref string) (string, error) {
+	signature, err := r.patchResolver.Resolve(ref)
+	if err != nil {
+		return "", err
+	}
+	defer signature.Close()
+
+	signatureFile, err := ioutil.TempFile(r.tempDir, "knit-signature")
+	if err != nil {
+		return "", err
+	}
+
+	_, err = io.Copy(signatureFile, signature)
+	signatureFile.Close()
+	if err != nil {
+		os.Remove(signatureFile.Name())
+		return "", err
+	}
+
+	return signatureFile.Name(), nil
+}
@@ -0,0 +1,97 @@
+package patcher_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pivotal-cf/knit/patcher"
+	"github.com/pivotal-cf/knit/patcher/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("WithSignatureVerification", func() {
+	var (
+		runner    *fakes.CommandRunner
+		gpgRunner *fakes.CommandRunner
+		r         patcher.Repo
+		patchDir  string
+		patch     string
+	)
+
+	BeforeEach(func() {
+		var err error
+		patchDir, err = ioutil.TempDir("", "")
+		Expect(err).NotTo(HaveOccurred())
+
+		patch = filepath.Join(patchDir, "some.patch")
+		Expect(ioutil.WriteFile(patch, []byte("some patch contents"), 0644)).To(Succeed())
+		Expect(ioutil.WriteFile(patch+".asc", []byte("some signature"), 0644)).To(Succeed())
+
+		runner = &fakes.CommandRunner{}
+		gpgRunner = &fakes.CommandRunner{}
+		r = patcher.NewRepo(runner, "/some/repo", "testbot", "foo@example.com").
+			WithSignatureVerification(gpgRunner, "/keys/trusted.gpg")
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(patchDir)).To(Succeed())
+	})
+
+	It("verifies the detached signature before applying the patch", func() {
+		err := r.ApplyPatch(patch)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(gpgRunner.CombinedOutputCall.Receives.Commands).To(Equal([]patcher.Command{
+			patcher.Command{
+				Args: []string{"--batch", "--no-default-keyring", "--keyring", "/keys/trusted.gpg", "--verify", patch + ".asc", patch},
+				Dir:  "/some/repo",
+			},
+		}))
+		Expect(runner.RunCall.Count).To(Equal(1))
+	})
+
+	Context("when the signature does not verify", func() {
+		It("returns a SignatureError without applying the patch", func() {
+			gpgRunner.CombinedOutputCall.Returns.Errors = []error{errors.New("exit status 1")}
+			gpgRunner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte("gpg: BAD signature\n")}
+
+			err := r.ApplyPatch(patch)
+			Expect(err).To(BeAssignableToTypeOf(patcher.SignatureError{}))
+			Expect(err).To(MatchError(ContainSubstring("gpg: BAD signature")))
+			Expect(runner.RunCall.Receives.Commands).To(BeEmpty())
+		})
+	})
+
+	Context("when the patch is applied through another operation", func() {
+		It("verifies the signature there too", func() {
+			gpgRunner.CombinedOutputCall.Returns.Errors = []error{errors.New("exit status 1"), errors.New("exit status 1")}
+			gpgRunner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte("gpg: BAD signature\n"), []byte("gpg: BAD signature\n")}
+
+			Expect(r.ApplyCached(patch)).To(BeAssignableToTypeOf(patcher.SignatureError{}))
+			Expect(r.PatchSubmodule("src/some-module", patch)).To(BeAssignableToTypeOf(patcher.SignatureError{}))
+			Expect(gpgRunner.CombinedOutputCall.Count).To(Equal(2))
+
+			err := r.PatchSubmoduleFromReader("src/some-module", bytes.NewBufferString("some patch contents"))
+			Expect(err).To(MatchError(ContainSubstring("no detached signature to verify")))
+
+			Expect(runner.RunCall.Receives.Commands).To(BeEmpty())
+			Expect(runner.CombinedOutputCall.Receives.Commands).To(BeEmpty())
+		})
+	})
+
+	Context("when the patch has no signature", func() {
+		It("returns a SignatureError", func() {
+			Expect(os.Remove(patch + ".asc")).To(Succeed())
+
+			err := r.ApplyPatch(patch)
+			Expect(err).To(MatchError(ContainSubstring("no signature found at " + patch + ".asc")))
+			Expect(gpgRunner.CombinedOutputCall.Receives.Commands).To(BeEmpty())
+			Expect(runner.RunCall.Receives.Commands).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,146 @@
+package patcher
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const sshConnectionFailedExitCode = 255
+
+type SSHConnectionError struct {
+	Host string
+	Err  error
+}
+
+func (e SSHConnectionError) Error() string {
+	return fmt.Sprintf("Lost connection to %q: %s", e.Host, e.Err)
+}
+
+// SSHRunner runs commands on a remote host through the ssh client, so that
+// Command.Dir refers to the remote filesystem. Stdin and output are streamed
+// over the connection.
+type SSHRunner struct {
+	SSH        string
+	Host       string
+	Executable string
+	Stdout     io.Writer
+	Stderr     io.Writer
+}
+
+// LocalCheckoutError is returned by operations that read or write the
+// checkout or a patch file directly when the repo's commands run on another
+// host, where those paths name different files.
+type LocalCheckoutError struct {
+	Operation string
+	Host      string
+}
+
+func (e LocalCheckoutError) Error() string {
+	return fmt.Sprintf("%s needs the checkout on the local filesystem and cannot be used with commands running on %q", e.Operation, e.Host)
+}
+
+func NewSSHRunner(host, executable string, quiet bool) (SSHRunner, error) {
+	sshPath, err := exec.LookPath("ssh")
+	if err != nil {
+		return SSHRunner{}, err
+	}
+
+	sshRunner := SSHRunner{
+		SSH:        sshPath,
+		Host:       host,
+		Executable: executable,
+	}
+	if !quiet {
+		sshRunner.Stdout = os.Stdout
+		sshRunner.Stderr = os.Stderr
+	}
+	return sshRunner, nil
+}
+
+func (r SSHRunner) CombinedOutput(command Command) ([]byte, error) {
+	cmd := r.sshCommand(command)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return output, r.connectionError(err)
+	}
+
+	return output, nil
+}
+
+func (r SSHRunner) Run(command Command) error {
+	cmd := r.sshCommand(command)
+	cmd.Stdout = r.Stdout
+	cmd.Stderr = teeWriter(r.Stderr, command.Stderr)
+	if command.Stdout != nil {
+		cmd.Stdout = command.Stdout
+	}
+
+	err := cmd.Run()
+	if err != nil {
+		return r.connectionError(err)
+	}
+
+	return nil
+}
+
+// RemoteHost returns the host the commands run on.
+func (r SSHRunner) RemoteHost() string {
+	return r.Host
+}
+
+func (r SSHRunner) sshCommand(command Command) *exec.Cmd {
+	var script []string
+	if command.Dir != "" {
+		script = append(script, "cd", shellQuote(command.Dir), "&&")
+	}
+
+	if len(command.Env) > 0 {
+		script = append(script, "env")
+		for _, variable := range command.Env {
+			script = append(script, shellQuote(variable))
+		}
+	}
+
+	script = append(script, shellQuote(r.Executable))
+	for _, arg := range command.Args {
+		script = append(script, shellQuote(arg))
+	}
+
+	return &exec.Cmd{
+		Path:  r.SSH,
+		Args:  []string{r.SSH, "-o", "BatchMode=yes", r.Host, strings.Join(script, " ")},
+		Stdin: command.Stdin,
+	}
+}
+
+func (r SSHRunner) connectionError(err error) error {
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == sshConnectionFailedExitCode {
+		return SSHConnectionError{Host: r.Host, Err: err}
+	}
+
+	return err
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+func remoteHost(runner Runner) string {
+	if remote, ok := runner.(interface{ RemoteHost() string }); ok {
+		return remote.RemoteHost()
+	}
+
+	return ""
+}
+
+func (r Repo) requireLocal(operation string) error {
+	if host := remoteHost(r.runner); host != "" {
+		return LocalCheckoutError{Operation: operation, Host: host}
+	}
+
+	return nil
+}
@@ -0,0 +1,187 @@
+package patcher_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pivotal-cf/knit/patcher"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SSHRunner", func() {
+	var (
+		tempDir string
+		runner  patcher.SSHRunner
+	)
+
+	BeforeEach(func() {
+		var err error
+		tempDir, err = ioutil.TempDir("", "")
+		Expect(err).NotTo(HaveOccurred())
+
+		tempDir, err = filepath.EvalSymlinks(tempDir)
+		Expect(err).NotTo(HaveOccurred())
+
+		fakeSSH := filepath.Join(tempDir, "ssh")
+		err = ioutil.WriteFile(fakeSSH, []byte(`#!/bin/sh
+if [ "$3" = "unreachable-host" ]; then
+  echo "ssh: connect to host unreachable-host port 22: Connection refused" >&2
+  exit 255
+fi
+exec sh -c "$4"
+`), 0755)
+		Expect(err).NotTo(HaveOccurred())
+
+		runner = patcher.SSHRunner{
+			SSH:        fakeSSH,
+			Host:       "build-host",
+			Executable: "echo",
+			Stdout:     bytes.NewBuffer([]byte{}),
+			Stderr:     bytes.NewBuffer([]byte{}),
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(tempDir)).To(Succeed())
+	})
+
+	Describe("Run", func() {
+		It("runs the command remotely in the given directory", func() {
+			runner.Executable = "pwd"
+
+			err := runner.Run(patcher.Command{Dir: tempDir})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(runner.Stdout).To(Equal(bytes.NewBuffer([]byte(tempDir + "\n"))))
+		})
+
+		It("quotes the arguments for the remote shell", func() {
+			err := runner.Run(patcher.Command{
+				Args: []string{"it's", "$HOME", "a;b"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(runner.Stdout).To(Equal(bytes.NewBuffer([]byte("it's $HOME a;b\n"))))
+		})
+
+		It("passes the environment and stdin to the remote command", func() {
+			runner.Executable = "sh"
+
+			err := runner.Run(patcher.Command{
+				Args:  []string{"-c", `echo "$KNIT_VAR $(cat)"`},
+				Env:   []string{"KNIT_VAR=banana"},
+				Stdin: bytes.NewBufferString("split"),
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(runner.Stdout).To(Equal(bytes.NewBuffer([]byte("banana split\n"))))
+		})
+
+		Context("when the connection fails", func() {
+			It("returns an SSHConnectionError", func() {
+				runner.Host = "unreachable-host"
+
+				err := runner.Run(patcher.Command{Args: []string{"banana"}})
+				Expect(err).To(BeAssignableToTypeOf(patcher.SSHConnectionError{}))
+				Expect(err).To(MatchError(ContainSubstring(`Lost connection to "unreachable-host"`)))
+			})
+		})
+
+		Context("when the remote command fails", func() {
+			It("returns the command's error", func() {
+				runner.Executable = "false"
+
+				err := runner.Run(patcher.Command{})
+				Expect(err).To(MatchError("exit status 1"))
+			})
+		})
+	})
+
+	Describe("CombinedOutput", func() {
+		It("returns the remote command's output", func() {
+			output, err := runner.CombinedOutput(patcher.Command{
+				Args: []string{"command output"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(output).To(Equal([]byte("command output\n")))
+		})
+
+		Context("when the connection fails", func() {
+			It("returns an SSHConnectionError along with ssh's output", func() {
+				runner.Host = "unreachable-host"
+
+				output, err := runner.CombinedOutput(patcher.Command{})
+				Expect(err).To(BeAssignableToTypeOf(patcher.SSHConnectionError{}))
+				Expect(string(output)).To(ContainSubstring("Connection refused"))
+			})
+		})
+	})
+
+	Context("when a Repo runs its commands through it", func() {
+		var (
+			checkout string
+			gitLog   string
+			repo     patcher.Repo
+		)
+
+		BeforeEach(func() {
+			checkout = filepath.Join(tempDir, "checkout")
+			Expect(os.Mkdir(checkout, 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(checkout, ".gitmodules"), []byte("[submodule \"local\"]\n\tpath = src/local\n"), 0644)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(checkout, "some.patch"), []byte("From: someone\n"), 0644)).To(Succeed())
+
+			gitLog = filepath.Join(tempDir, "git.log")
+			fakeGit := filepath.Join(tempDir, "git")
+			err := ioutil.WriteFile(fakeGit, []byte(`#!/bin/sh
+echo "$@" >> `+gitLog+`
+if [ "$1" = "config" ]; then
+  printf 'submodule.remote.path=src/remote\nsubmodule.remote.url=https://example.com/remote\n'
+fi
+`), 0755)
+			Expect(err).NotTo(HaveOccurred())
+
+			runner.Executable = fakeGit
+			repo = patcher.NewRepo(runner, checkout, "testbot", "foo@example.com")
+		})
+
+		It("reads .gitmodules on the remote host with git", func() {
+			configs, err := repo.GetSubmoduleConfig()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(configs).To(Equal([]patcher.SubmoduleConfig{
+				{Name: "remote", Path: "src/remote", URL: "https://example.com/remote"},
+			}))
+		})
+
+		It("rejects the operations that need the local filesystem without touching it", func() {
+			_, err := repo.Lock()
+			Expect(err).To(MatchError(patcher.LocalCheckoutError{Operation: "Lock", Host: "build-host"}))
+
+			_, err = repo.ApplyPatchWithOptions(filepath.Join(checkout, "some.patch"), patcher.ApplyPatchOptions{ReportFuzz: true})
+			Expect(err).To(MatchError(patcher.LocalCheckoutError{Operation: "ApplyPatchOptions.ReportFuzz", Host: "build-host"}))
+
+			_, err = repo.BaseVersion()
+			Expect(err).To(MatchError(ContainSubstring("cannot be used with commands running on \"build-host\"")))
+
+			entries, err := ioutil.ReadDir(checkout)
+			Expect(err).NotTo(HaveOccurred())
+
+			var names []string
+			for _, entry := range entries {
+				names = append(names, entry.Name())
+			}
+			Expect(names).To(Equal([]string{".gitmodules", "some.patch"}))
+
+			Expect(gitLog).NotTo(BeAnExistingFile())
+		})
+
+		It("applies a patch with git on the remote host", func() {
+			err := repo.ApplyPatch("/remote/some.patch")
+			Expect(err).NotTo(HaveOccurred())
+
+			log, err := ioutil.ReadFile(gitLog)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(log)).To(ContainSubstring("am /remote/some.patch"))
+		})
+	})
+})
@@ -0,0 +1,43 @@
+package patcher
+
+import "strings"
+
+type Stash struct {
+	Ref     string
+	Message string
+}
+
+// ListStashes returns the repository's stash entries, newest first. Changes
+// stashed by ApplyPatchOptions.AutoStash carry the "<prefix> auto-stash"
+// message.
+func (r Repo) ListStashes() ([]Stash, error) {
+	output, err := r.combinedOutput(Command{
+		Args: []string{"stash", "list", "--format=%gd %gs"},
+		Dir:  r.repo,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var stashes []Stash
+	for _, line := range splitLines(output) {
+		fields := strings.SplitN(line, " ", 2)
+
+		stash := Stash{Ref: fields[0]}
+		if len(fields) == 2 {
+			stash.Message = fields[1]
+		}
+
+		stashes = append(stashes, stash)
+	}
+
+	return stashes, nil
+}
+
+// DropStash discards the stash entry ref, e.g. "stash@{1}".
+func (r Repo) DropStash(ref string) error {
+	return r.run(Command{
+		Args: []string{"stash", "drop", ref},
+		Dir:  r.repo,
+	})
+}
@@ -0,0 +1,77 @@
+package patcher_test
+
+import (
+	"errors"
+
+	"github.com/pivotal-cf/knit/patcher"
+	"github.com/pivotal-cf/knit/patcher/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Stashes", func() {
+	var (
+		runner *fakes.CommandRunner
+		r      patcher.Repo
+	)
+
+	BeforeEach(func() {
+		runner = &fakes.CommandRunner{}
+		r = patcher.NewRepo(runner, "/some/repo", "testbot", "foo@example.com")
+	})
+
+	Describe("ListStashes", func() {
+		It("returns the stash entries, newest first", func() {
+			runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte("stash@{0} On master: Knit auto-stash\nstash@{1} WIP on master: abc1234 Some change\n")}
+			runner.CombinedOutputCall.Returns.Errors = []error{nil}
+
+			stashes, err := r.ListStashes()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stashes).To(Equal([]patcher.Stash{
+				{Ref: "stash@{0}", Message: "On master: Knit auto-stash"},
+				{Ref: "stash@{1}", Message: "WIP on master: abc1234 Some change"},
+			}))
+
+			Expect(runner.CombinedOutputCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{"stash", "list", "--format=%gd %gs"},
+					Dir:  "/some/repo",
+				},
+			}))
+		})
+
+		It("returns no entries when nothing is stashed", func() {
+			runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte("")}
+			runner.CombinedOutputCall.Returns.Errors = []error{nil}
+
+			stashes, err := r.ListStashes()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stashes).To(BeEmpty())
+		})
+
+		Context("when git stash list fails", func() {
+			It("returns an error", func() {
+				runner.CombinedOutputCall.Returns.Outputs = [][]byte{nil}
+				runner.CombinedOutputCall.Returns.Errors = []error{errors.New("not a git repository")}
+
+				_, err := r.ListStashes()
+				Expect(err).To(MatchError("not a git repository"))
+			})
+		})
+	})
+
+	Describe("DropStash", func() {
+		It("drops the given stash entry", func() {
+			err := r.DropStash("stash@{1}")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(runner.RunCall.Receives.Commands).To(Equal([]patcher.Command{
+				patcher.Command{
+					Args: []string{"stash", "drop", "stash@{1}"},
+					Dir:  "/some/repo",
+				},
+			}))
+		})
+	})
+})
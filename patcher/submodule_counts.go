@@ -0,0 +1,101 @@
+package patcher
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// SubmoduleCountsError reports submodules whose presence after a run does not
+// match what the applied changes declared.
+type SubmoduleCountsError struct {
+	Missing    []string
+	Unexpected []string
+}
+
+func (e SubmoduleCountsError) Error() string {
+	var problems []string
+	if len(e.Missing) > 0 {
+		problems = append(problems, fmt.Sprintf("missing: %s", strings.Join(e.Missing, ", ")))
+	}
+	if len(e.Unexpected) > 0 {
+		problems = append(problems, fmt.Sprintf("unexpected: %s", strings.Join(e.Unexpected, ", ")))
+	}
+
+	return fmt.Sprintf("Submodules do not match the applied changes (%s)", strings.Join(problems, "; "))
+}
+
+// PresentSubmodules returns the repository-relative paths of the submodules
+// declared in .gitmodules that are present in the working tree.
+func (r Repo) PresentSubmodules() ([]string, error) {
+	fullPaths, err := r.submodules()
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, fullPath := range fullPaths {
+		path, err := filepath.Rel(r.repo, fullPath)
+		if err != nil {
+			return nil, err
+		}
+
+		paths = append(paths, path)
+	}
+
+	return paths, nil
+}
+
+// VerifySubmoduleCounts compares the submodules present now against before,
+// the result of PresentSubmodules prior to applying changes, with the declared
+// additions and removals accounted for. A mismatch is returned as a
+// SubmoduleCountsError.
+func (r Repo) VerifySubmoduleCounts(before []string, changes []Changeset) error {
+	expected := map[string]bool{}
+	for _, path := range before {
+		expected[path] = true
+	}
+
+	for _, change := range changes {
+		for path := range change.SubmoduleAdditions {
+			expected[path] = true
+		}
+
+		for _, path := range change.SubmoduleRemovals {
+			delete(expected, path)
+		}
+	}
+
+	after, err := r.PresentSubmodules()
+	if err != nil {
+		return err
+	}
+
+	actual := map[string]bool{}
+	for _, path := range after {
+		actual[path] = true
+	}
+
+	var countsErr SubmoduleCountsError
+	for path := range expected {
+		if !actual[path] {
+			countsErr.Missing = append(countsErr.Missing, path)
+		}
+	}
+
+	for path := range actual {
+		if !expected[path] {
+			countsErr.Unexpected = append(countsErr.Unexpected, path)
+		}
+	}
+
+	if len(countsErr.Missing) == 0 && len(countsErr.Unexpected) == 0 {
+		return nil
+	}
+
+	sort.Strings(countsErr.Missing)
+	sort.Strings(countsErr.Unexpected)
+
+	return countsErr
+}
@@ -0,0 +1,83 @@
+package patcher_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pivotal-cf/knit/patcher"
+	"github.com/pivotal-cf/knit/patcher/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("VerifySubmoduleCounts", func() {
+	var (
+		repoPath string
+		r        patcher.Repo
+	)
+
+	BeforeEach(func() {
+		var err error
+		repoPath, err = ioutil.TempDir("", "")
+		Expect(err).NotTo(HaveOccurred())
+
+		for _, dir := range []string{"src/one", "src/two", "src/three"} {
+			err := os.MkdirAll(filepath.Join(repoPath, dir), 0744)
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		err = ioutil.WriteFile(filepath.Join(repoPath, ".gitmodules"), []byte(`[submodule "src/one"]
+	path = src/one
+	url = https://example.com/one
+[submodule "src/two"]
+	path = src/two
+	url = https://example.com/two
+[submodule "src/three"]
+	path = src/three
+	url = https://example.com/three
+`), 0644)
+		Expect(err).NotTo(HaveOccurred())
+
+		r = patcher.NewRepo(&fakes.CommandRunner{}, repoPath, "testbot", "foo@example.com")
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(repoPath)).To(Succeed())
+	})
+
+	It("lists the submodules present in the working tree", func() {
+		Expect(os.RemoveAll(filepath.Join(repoPath, "src/three"))).To(Succeed())
+
+		paths, err := r.PresentSubmodules()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(paths).To(Equal([]string{"src/one", "src/two"}))
+	})
+
+	It("accepts submodules that match the declared changes", func() {
+		err := r.VerifySubmoduleCounts([]string{"src/one", "src/two", "src/gone"}, []patcher.Changeset{
+			{
+				SubmoduleAdditions: map[string]patcher.SubmoduleAddition{"src/three": {URL: "https://example.com/three"}},
+				SubmoduleRemovals:  []string{"src/gone"},
+			},
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	Context("when a declared change did not take", func() {
+		It("reports the discrepancies", func() {
+			err := r.VerifySubmoduleCounts([]string{"src/one", "src/two", "src/three"}, []patcher.Changeset{
+				{
+					SubmoduleAdditions: map[string]patcher.SubmoduleAddition{"src/four": {URL: "https://example.com/four"}},
+					SubmoduleRemovals:  []string{"src/two"},
+				},
+			})
+			Expect(err).To(Equal(patcher.SubmoduleCountsError{
+				Missing:    []string{"src/four"},
+				Unexpected: []string{"src/two"},
+			}))
+			Expect(err).To(MatchError("Submodules do not match the applied changes (missing: src/four; unexpected: src/two)"))
+		})
+	})
+})
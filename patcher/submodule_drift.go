@@ -0,0 +1,84 @@
+package patcher
+
+import "strings"
+
+type SubmoduleDriftEntry struct {
+	Path          string
+	RecordedSHA   string
+	CheckedOutSHA string
+}
+
+// SubmoduleDrift reports the submodules whose checked-out commit differs from
+// the gitlink recorded in the superproject's HEAD, i.e. the bumps that an
+// "add -A" would silently commit. Submodules that are not checked out are
+// ignored.
+func (r Repo) SubmoduleDrift() ([]SubmoduleDriftEntry, error) {
+	output, err := r.combinedOutput(Command{
+		Args: []string{"submodule", "status"},
+		Dir:  r.repo,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	checkedOut := map[string]string{}
+	var paths []string
+	for _, line := range splitLines(output) {
+		if strings.HasPrefix(line, "-") {
+			continue
+		}
+
+		// Each line is "<sha> <path>", followed by " (<describe>)" for a
+		// checked out submodule. The path may contain spaces.
+		fields := strings.SplitN(line[1:], " ", 2)
+		if len(fields) != 2 {
+			continue
+		}
+
+		path := fields[1]
+		if strings.HasSuffix(path, ")") {
+			if index := strings.LastIndex(path, " ("); index != -1 {
+				path = path[:index]
+			}
+		}
+
+		checkedOut[path] = fields[0]
+		paths = append(paths, path)
+	}
+
+	if len(paths) == 0 {
+		return nil, nil
+	}
+
+	output, err = r.combinedOutput(Command{
+		Args: append([]string{"ls-tree", "HEAD", "--"}, paths...),
+		Dir:  r.repo,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	recorded := map[string]string{}
+	for _, line := range splitLines(output) {
+		parts := strings.SplitN(line, "\t", 2)
+		fields := strings.Fields(parts[0])
+		if len(parts) != 2 || len(fields) != 3 || fields[1] != "commit" {
+			continue
+		}
+
+		recorded[parts[1]] = fields[2]
+	}
+
+	var drift []SubmoduleDriftEntry
+	for _, path := range paths {
+		if recorded[path] != checkedOut[path] {
+			drift = append(drift, SubmoduleDriftEntry{
+				Path:          path,
+				RecordedSHA:   recorded[path],
+				CheckedOutSHA: checkedOut[path],
+			})
+		}
+	}
+
+	return drift, nil
+}
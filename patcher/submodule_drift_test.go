@@ -0,0 +1,94 @@
+package patcher_test
+
+import (
+	"errors"
+
+	"github.com/pivotal-cf/knit/patcher"
+	"github.com/pivotal-cf/knit/patcher/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SubmoduleDrift", func() {
+	var (
+		runner *fakes.CommandRunner
+		r      patcher.Repo
+	)
+
+	BeforeEach(func() {
+		runner = &fakes.CommandRunner{}
+		runner.CombinedOutputCall.Returns.Outputs = [][]byte{
+			[]byte(" 1111111111111111111111111111111111111111 src/clean (v1.0.0)\n" +
+				"+2222222222222222222222222222222222222222 src/drifted (v1.1.0-3-g2222222)\n" +
+				"-3333333333333333333333333333333333333333 src/uninitialized\n"),
+			[]byte("160000 commit 1111111111111111111111111111111111111111\tsrc/clean\n" +
+				"160000 commit 4444444444444444444444444444444444444444\tsrc/drifted\n"),
+		}
+		runner.CombinedOutputCall.Returns.Errors = []error{nil, nil}
+
+		r = patcher.NewRepo(runner, "/some/repo", "testbot", "foo@example.com")
+	})
+
+	It("reports the submodules checked out at a different sha than recorded", func() {
+		drift, err := r.SubmoduleDrift()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(drift).To(Equal([]patcher.SubmoduleDriftEntry{
+			{
+				Path:          "src/drifted",
+				RecordedSHA:   "4444444444444444444444444444444444444444",
+				CheckedOutSHA: "2222222222222222222222222222222222222222",
+			},
+		}))
+
+		Expect(runner.CombinedOutputCall.Receives.Commands).To(Equal([]patcher.Command{
+			patcher.Command{
+				Args: []string{"submodule", "status"},
+				Dir:  "/some/repo",
+			},
+			patcher.Command{
+				Args: []string{"ls-tree", "HEAD", "--", "src/clean", "src/drifted"},
+				Dir:  "/some/repo",
+			},
+		}))
+	})
+
+	It("reports a submodule with no recorded gitlink", func() {
+		runner.CombinedOutputCall.Returns.Outputs[1] = []byte("160000 commit 1111111111111111111111111111111111111111\tsrc/clean\n")
+
+		drift, err := r.SubmoduleDrift()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(drift).To(Equal([]patcher.SubmoduleDriftEntry{
+			{Path: "src/drifted", CheckedOutSHA: "2222222222222222222222222222222222222222"},
+		}))
+	})
+
+	It("handles submodule paths with spaces", func() {
+		runner.CombinedOutputCall.Returns.Outputs = [][]byte{
+			[]byte("+2222222222222222222222222222222222222222 src/some module (v1.1.0-3-g2222222)\n" +
+				"+5555555555555555555555555555555555555555 src/no describe\n"),
+			[]byte("160000 commit 4444444444444444444444444444444444444444\tsrc/some module\n" +
+				"160000 commit 5555555555555555555555555555555555555555\tsrc/no describe\n"),
+		}
+
+		drift, err := r.SubmoduleDrift()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(drift).To(Equal([]patcher.SubmoduleDriftEntry{
+			{
+				Path:          "src/some module",
+				RecordedSHA:   "4444444444444444444444444444444444444444",
+				CheckedOutSHA: "2222222222222222222222222222222222222222",
+			},
+		}))
+		Expect(runner.CombinedOutputCall.Receives.Commands[1].Args).To(Equal([]string{"ls-tree", "HEAD", "--", "src/some module", "src/no describe"}))
+	})
+
+	Context("when git submodule status fails", func() {
+		It("returns an error", func() {
+			runner.CombinedOutputCall.Returns.Errors = []error{errors.New("not a git repository")}
+
+			_, err := r.SubmoduleDrift()
+			Expect(err).To(MatchError("not a git repository"))
+		})
+	})
+})
@@ -0,0 +1,31 @@
+package patcher
+
+import (
+	"path/filepath"
+	"regexp"
+)
+
+// SubmoduleRelativePath splits path, relative to the superproject, into the
+// repository that records the submodule and the submodule's path within it.
+// The enclosing repository is the nearest parent directory with a .git entry;
+// it is "" for the superproject itself. When no enclosing submodule is checked
+// out, paths of the form src/.../src/... are split at the last src/.
+func (r Repo) SubmoduleRelativePath(path string) (string, string) {
+	path = filepath.Clean(path)
+
+	for dir := filepath.Dir(path); dir != "." && dir != string(filepath.Separator); dir = filepath.Dir(dir) {
+		if checkedOut, _ := r.hasGitEntry(dir); checkedOut {
+			relativePath, err := filepath.Rel(dir, path)
+			if err == nil {
+				return dir, relativePath
+			}
+		}
+	}
+
+	matches := regexp.MustCompile(`(src/.*)/(src/.*)`).FindStringSubmatch(path)
+	if len(matches) == 3 {
+		return matches[1], matches[2]
+	}
+
+	return "", path
+}
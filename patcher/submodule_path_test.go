@@ -0,0 +1,68 @@
+package patcher_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pivotal-cf/knit/patcher"
+	"github.com/pivotal-cf/knit/patcher/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SubmoduleRelativePath", func() {
+	var (
+		repoPath string
+		r        patcher.Repo
+	)
+
+	BeforeEach(func() {
+		var err error
+		repoPath, err = ioutil.TempDir("", "")
+		Expect(err).NotTo(HaveOccurred())
+
+		r = patcher.NewRepo(&fakes.CommandRunner{}, repoPath, "testbot", "foo@example.com")
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(repoPath)).To(Succeed())
+	})
+
+	It("finds the nearest enclosing repository on disk", func() {
+		err := os.MkdirAll(filepath.Join(repoPath, "vendor/libs/outer/deps/inner"), 0755)
+		Expect(err).NotTo(HaveOccurred())
+
+		err = ioutil.WriteFile(filepath.Join(repoPath, "vendor/libs/outer/.git"), []byte("gitdir: ../../../.git/modules/outer"), 0644)
+		Expect(err).NotTo(HaveOccurred())
+
+		enclosingRepo, path := r.SubmoduleRelativePath("vendor/libs/outer/deps/inner")
+		Expect(enclosingRepo).To(Equal("vendor/libs/outer"))
+		Expect(path).To(Equal("deps/inner"))
+	})
+
+	It("does not mistake a src directory for a repository boundary", func() {
+		err := os.MkdirAll(filepath.Join(repoPath, "src/outer/src/lib/src/inner"), 0755)
+		Expect(err).NotTo(HaveOccurred())
+
+		err = ioutil.WriteFile(filepath.Join(repoPath, "src/outer/.git"), []byte("gitdir: ../../.git/modules/outer"), 0644)
+		Expect(err).NotTo(HaveOccurred())
+
+		enclosingRepo, path := r.SubmoduleRelativePath("src/outer/src/lib/src/inner")
+		Expect(enclosingRepo).To(Equal("src/outer"))
+		Expect(path).To(Equal("src/lib/src/inner"))
+	})
+
+	It("falls back to splitting src/.../src/... paths when nothing is checked out", func() {
+		enclosingRepo, path := r.SubmoduleRelativePath("src/outer/src/inner")
+		Expect(enclosingRepo).To(Equal("src/outer"))
+		Expect(path).To(Equal("src/inner"))
+	})
+
+	It("treats other paths as submodules of the superproject", func() {
+		enclosingRepo, path := r.SubmoduleRelativePath("src/outer")
+		Expect(enclosingRepo).To(Equal(""))
+		Expect(path).To(Equal("src/outer"))
+	})
+})
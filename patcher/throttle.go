@@ -0,0 +1,61 @@
+package patcher
+
+import "sync"
+
+var networkSlots struct {
+	sync.Mutex
+	slots chan struct{}
+}
+
+// SetMaxNetworkCommands bounds the number of git commands that talk to a
+// remote (fetch, submodule add/update, ...) that may be in flight at once
+// across every Repo in the process. A value of zero or less removes the
+// bound, which is the default.
+func SetMaxNetworkCommands(max int) {
+	networkSlots.Lock()
+	defer networkSlots.Unlock()
+
+	if max <= 0 {
+		networkSlots.slots = nil
+		return
+	}
+
+	networkSlots.slots = make(chan struct{}, max)
+}
+
+func acquireNetworkSlot() func() {
+	networkSlots.Lock()
+	slots := networkSlots.slots
+	networkSlots.Unlock()
+
+	if slots == nil {
+		return func() {}
+	}
+
+	slots <- struct{}{}
+	return func() { <-slots }
+}
+
+func isNetworkCommand(command Command) bool {
+	args := command.Args
+	for len(args) >= 2 && args[0] == "-c" {
+		args = args[2:]
+	}
+
+	if len(args) == 0 {
+		return false
+	}
+
+	switch args[0] {
+	case "fetch", "pull", "push", "clone", "ls-remote":
+		return true
+	case "submodule":
+		for _, arg := range args[1:] {
+			if arg == "update" || arg == "add" {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,83 @@
+package patcher_test
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pivotal-cf/knit/patcher"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type concurrencyRecordingRunner struct {
+	sync.Mutex
+	inFlight    int
+	maxInFlight int
+}
+
+func (r *concurrencyRecordingRunner) Run(command patcher.Command) error {
+	if command.Args[0] != "fetch" {
+		return nil
+	}
+
+	r.Lock()
+	r.inFlight++
+	if r.inFlight > r.maxInFlight {
+		r.maxInFlight = r.inFlight
+	}
+	r.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+
+	r.Lock()
+	r.inFlight--
+	r.Unlock()
+
+	return nil
+}
+
+func (r *concurrencyRecordingRunner) CombinedOutput(command patcher.Command) ([]byte, error) {
+	return nil, r.Run(command)
+}
+
+var _ = Describe("SetMaxNetworkCommands", func() {
+	var runner *concurrencyRecordingRunner
+
+	BeforeEach(func() {
+		runner = &concurrencyRecordingRunner{}
+	})
+
+	AfterEach(func() {
+		patcher.SetMaxNetworkCommands(0)
+	})
+
+	bumpConcurrently := func(count int) {
+		var wg sync.WaitGroup
+		for i := 0; i < count; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				r := patcher.NewRepo(runner, "/some/repo", "testbot", "foo@example.com")
+				Expect(r.BumpSubmodule("src/some/path", "a-sha")).To(Succeed())
+			}()
+		}
+		wg.Wait()
+	}
+
+	It("bounds the number of network commands in flight across repos", func() {
+		patcher.SetMaxNetworkCommands(2)
+		bumpConcurrently(6)
+
+		Expect(runner.maxInFlight).To(Equal(2))
+	})
+
+	Context("when no bound is set", func() {
+		It("does not throttle network commands", func() {
+			bumpConcurrently(6)
+
+			Expect(runner.maxInFlight).To(BeNumerically(">", 2))
+		})
+	})
+})
@@ -0,0 +1,46 @@
+package patcher
+
+import "time"
+
+type TimedRunner struct {
+	runner Runner
+	hook   func(command Command, duration time.Duration, err error)
+}
+
+// NewTimedRunner wraps runner so that hook is called with the duration of
+// every command once it completes. A nil hook makes the wrapper a passthrough.
+func NewTimedRunner(runner Runner, hook func(command Command, duration time.Duration, err error)) TimedRunner {
+	return TimedRunner{
+		runner: runner,
+		hook:   hook,
+	}
+}
+
+func (r TimedRunner) Run(command Command) error {
+	if r.hook == nil {
+		return r.runner.Run(command)
+	}
+
+	start := time.Now()
+	err := r.runner.Run(command)
+	r.hook(command, time.Since(start), err)
+
+	return err
+}
+
+func (r TimedRunner) CombinedOutput(command Command) ([]byte, error) {
+	if r.hook == nil {
+		return r.runner.CombinedOutput(command)
+	}
+
+	start := time.Now()
+	output, err := r.runner.CombinedOutput(command)
+	r.hook(command, time.Since(start), err)
+
+	return output, err
+}
+
+// RemoteHost returns the host the wrapped runner's commands run on, if any.
+func (r TimedRunner) RemoteHost() string {
+	return remoteHost(r.runner)
+}
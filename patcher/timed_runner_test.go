@@ -0,0 +1,79 @@
+package patcher_test
+
+import (
+	"errors"
+	"time"
+
+	"github.com/pivotal-cf/knit/patcher"
+	"github.com/pivotal-cf/knit/patcher/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TimedRunner", func() {
+	type timing struct {
+		command  patcher.Command
+		duration time.Duration
+		err      error
+	}
+
+	var (
+		runner  *fakes.CommandRunner
+		timings []timing
+		timed   patcher.TimedRunner
+	)
+
+	BeforeEach(func() {
+		runner = &fakes.CommandRunner{}
+		runner.RunCall.Stub = func(patcher.Command) error {
+			time.Sleep(5 * time.Millisecond)
+			return errors.New("meow")
+		}
+
+		timings = nil
+		timed = patcher.NewTimedRunner(runner, func(command patcher.Command, duration time.Duration, err error) {
+			timings = append(timings, timing{command, duration, err})
+		})
+	})
+
+	Describe("Run", func() {
+		It("reports the command, its duration, and its error to the hook", func() {
+			command := patcher.Command{Args: []string{"fetch"}, Dir: "/some/repo"}
+
+			err := timed.Run(command)
+			Expect(err).To(MatchError("meow"))
+
+			Expect(timings).To(HaveLen(1))
+			Expect(timings[0].command).To(Equal(command))
+			Expect(timings[0].duration).To(BeNumerically(">=", 5*time.Millisecond))
+			Expect(timings[0].err).To(MatchError("meow"))
+		})
+	})
+
+	Describe("CombinedOutput", func() {
+		It("reports the command to the hook and returns its output", func() {
+			runner.CombinedOutputCall.Returns.Errors = []error{nil}
+			runner.CombinedOutputCall.Returns.Outputs = [][]byte{[]byte("some output")}
+			command := patcher.Command{Args: []string{"status"}, Dir: "/some/repo"}
+
+			output, err := timed.CombinedOutput(command)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(output).To(Equal([]byte("some output")))
+
+			Expect(timings).To(HaveLen(1))
+			Expect(timings[0].command).To(Equal(command))
+			Expect(timings[0].err).NotTo(HaveOccurred())
+		})
+	})
+
+	Context("when no hook is provided", func() {
+		It("passes commands straight through", func() {
+			timed = patcher.NewTimedRunner(runner, nil)
+
+			err := timed.Run(patcher.Command{Args: []string{"fetch"}})
+			Expect(err).To(MatchError("meow"))
+			Expect(runner.RunCall.Count).To(Equal(1))
+		})
+	})
+})
@@ -0,0 +1,77 @@
+package patcher
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ApplyPatchToTree applies patch on top of baseRef and returns the sha of the
+// resulting tree without creating a commit. The patch is applied to a
+// temporary index, so neither the branch, the working tree nor the real index
+// are touched.
+func (r Repo) ApplyPatchToTree(baseRef, patch string) (string, error) {
+	var result string
+	err := r.withPatchHooks("ApplyPatchToTree", patch, func() error {
+		var err error
+		result, err = r.applyPatchToTree(baseRef, patch)
+		return err
+	})
+
+	return result, err
+}
+
+func (r Repo) applyPatchToTree(baseRef, patch string) (string, error) {
+	if err := r.requireLocal("ApplyPatchToTree"); err != nil {
+		return "", err
+	}
+
+	// Resolve a relative path against the repository, where git apply runs.
+	if !filepath.IsAbs(patch) {
+		patch = filepath.Join(r.repo, patch)
+	}
+
+	patch, err := filepath.Abs(patch)
+	if err != nil {
+		return "", err
+	}
+
+	indexDir, err := ioutil.TempDir(r.tempDir, "knit-index")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(indexDir)
+
+	env := []string{fmt.Sprintf("GIT_INDEX_FILE=%s", filepath.Join(indexDir, "index"))}
+
+	output, err := r.combinedOutput(Command{
+		Args: []string{"read-tree", baseRef},
+		Dir:  r.repo,
+		Env:  env,
+	})
+	if err != nil {
+		return "", fmt.Errorf("Could not read the tree of %s: %s\n%s", baseRef, err, strings.TrimSpace(string(output)))
+	}
+
+	output, err = r.combinedOutput(Command{
+		Args: []string{"apply", "--cached", patch},
+		Dir:  r.repo,
+		Env:  env,
+	})
+	if err != nil {
+		return "", fmt.Errorf("Patch %q could not be applied to %s: %s\n%s", patch, baseRef, err, strings.TrimSpace(string(output)))
+	}
+
+	output, err = r.combinedOutput(Command{
+		Args: []string{"write-tree"},
+		Dir:  r.repo,
+		Env:  env,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
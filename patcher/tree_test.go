@@ -0,0 +1,97 @@
+package patcher_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pivotal-cf/knit/patcher"
+	"github.com/pivotal-cf/knit/patcher/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ApplyPatchToTree", func() {
+	var (
+		runner   *fakes.CommandRunner
+		tempDir  string
+		failures map[string]error
+		r        patcher.Repo
+	)
+
+	BeforeEach(func() {
+		var err error
+		tempDir, err = ioutil.TempDir("", "")
+		Expect(err).NotTo(HaveOccurred())
+
+		failures = map[string]error{}
+
+		runner = &fakes.CommandRunner{}
+		runner.CombinedOutputCall.Stub = func(command patcher.Command) ([]byte, error) {
+			if command.Args[0] == "write-tree" {
+				return []byte("4b825dc642cb6eb9a060e54bf8d69288fbee4904\n"), nil
+			}
+
+			return nil, failures[command.Args[0]]
+		}
+
+		r = patcher.NewRepo(runner, "/some/repo", "testbot", "foo@example.com").WithTempDir(tempDir)
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(tempDir)).To(Succeed())
+	})
+
+	It("applies the patch to a temporary index and returns the written tree", func() {
+		tree, err := r.ApplyPatchToTree("1.9.2", "/patches/some.patch")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tree).To(Equal("4b825dc642cb6eb9a060e54bf8d69288fbee4904"))
+
+		commands := runner.CombinedOutputCall.Receives.Commands
+		Expect(commands).To(HaveLen(3))
+		Expect(commands[0].Args).To(Equal([]string{"read-tree", "1.9.2"}))
+		Expect(commands[1].Args).To(Equal([]string{"apply", "--cached", "/patches/some.patch"}))
+		Expect(commands[2].Args).To(Equal([]string{"write-tree"}))
+
+		env := commands[0].Env
+		Expect(env).To(HaveLen(1))
+		Expect(env[0]).To(HavePrefix("GIT_INDEX_FILE=" + tempDir))
+		for _, command := range commands {
+			Expect(command.Dir).To(Equal("/some/repo"))
+			Expect(command.Env).To(Equal(env))
+		}
+
+		Expect(runner.RunCall.Receives.Commands).To(BeEmpty())
+	})
+
+	It("removes the temporary index afterwards", func() {
+		_, err := r.ApplyPatchToTree("1.9.2", "/patches/some.patch")
+		Expect(err).NotTo(HaveOccurred())
+
+		index := strings.TrimPrefix(runner.CombinedOutputCall.Receives.Commands[0].Env[0], "GIT_INDEX_FILE=")
+		_, err = os.Stat(filepath.Dir(index))
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+
+	Context("when the patch path is relative", func() {
+		It("resolves it against the repository", func() {
+			_, err := r.ApplyPatchToTree("1.9.2", "patches/some.patch")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(runner.CombinedOutputCall.Receives.Commands[1].Args).To(Equal([]string{"apply", "--cached", "/some/repo/patches/some.patch"}))
+		})
+	})
+
+	Context("when the patch does not apply", func() {
+		It("returns an error without writing a tree", func() {
+			failures["apply"] = errors.New("exit status 1")
+
+			_, err := r.ApplyPatchToTree("1.9.2", "/patches/some.patch")
+			Expect(err).To(MatchError(ContainSubstring(`Patch "/patches/some.patch" could not be applied to 1.9.2`)))
+			Expect(runner.CombinedOutputCall.Receives.Commands).To(HaveLen(2))
+		})
+	})
+})